@@ -0,0 +1,160 @@
+package simplestore
+
+import (
+	"context"
+	"reflect"
+	"time"
+	"unsafe"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AuditEntry is a record of a write to an audited collection
+// Stored in the `AuditEntry` collection. Use `AddTableMaps` to change the collection.
+type AuditEntry struct {
+	ID string
+	// Actor is the actor specified with `WithAuditActor`.
+	Actor     string
+	Operation OperationKind
+	// Collection is the collection name resolved with table maps.
+	Collection string
+	// Path is the path of the document relative to the database root.
+	Path string
+	// Changes is the list of changed fields.
+	Changes []AuditChange
+	// Time is set by the server.
+	Time time.Time `firestore:"Time,serverTimestamp"`
+}
+
+// AuditChange is a change of a field
+type AuditChange struct {
+	// Field is the name of the field in firestore.
+	Field string
+	// Before is the value before the write. nil for new documents.
+	Before any
+	// After is the value after the write. nil for deleted documents.
+	After any
+}
+
+type auditActorKey struct{}
+
+// WithAuditActor returns a new context with the actor recorded to audit entries
+// Typically used per HTTP request with the authenticated user.
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// AuditActorFrom returns the actor in the context
+// Returns an empty string if the context doesn't have an actor.
+func AuditActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(auditActorKey{}).(string)
+	return actor
+}
+
+// EnableAudit records writes to documents of the types as AuditEntry
+// os must be pointers to structs.
+// Use `AddAuditedTableMaps` to enable auditing with table maps.
+func (c *Client) EnableAudit(os ...any) error {
+	for _, o := range os {
+		pt := reflect.TypeOf(o)
+		if _, err := newAccessor(pt, c.tableMaps); err != nil {
+			return err
+		}
+		if c.auditedTypes == nil {
+			c.auditedTypes = make(map[reflect.Type]bool, len(os))
+		}
+		c.auditedTypes[pt.Elem()] = true
+	}
+	return nil
+}
+
+func (c *Client) isAudited(a *accessor) bool {
+	if a.t == reflect.TypeOf(AuditEntry{}) {
+		// audit entries are not audited
+		return false
+	}
+	return a.audited || c.auditedTypes[a.t]
+}
+
+// newAuditEntry reads the current document and builds the audit entry for the write
+// Must be called in a transaction before other writes.
+// The entry is staged with the write in `RunTransaction`.
+func (c *Client) newAuditEntry(ctx context.Context, kind OperationKind, a *accessor, doc *firestore.DocumentRef, pv reflect.Value) (*AuditEntry, error) {
+	var before reflect.Value
+	if kind != OperationCreate {
+		docsnap, err := c.FirestoreTransaction.Get(doc)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+		if docsnap.Exists() {
+			before = reflect.New(a.t)
+			if err := docsnap.DataTo(before.Interface()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var after reflect.Value
+	if kind != OperationDelete {
+		after = pv
+	}
+	return &AuditEntry{
+		Actor:      AuditActorFrom(ctx),
+		Operation:  kind,
+		Collection: a.collectionName,
+		Path:       relativePath(doc.Path),
+		Changes:    diffFields(a.t, before, after),
+	}, nil
+}
+
+// diffFields returns changes of stored fields between pointers to structs
+// Fields of embedded structs are compared with their stored names.
+// Invalid values represent missing documents.
+func diffFields(t reflect.Type, before, after reflect.Value) []AuditChange {
+	var changes []AuditChange
+	for _, f := range storedFields(t) {
+		if len(f.index) == 1 && t.Field(f.index[0]).Name == ParentFieldName {
+			continue
+		}
+		var beforeV, afterV any
+		if before.IsValid() {
+			beforeV = storedFieldValue(before.Elem(), f)
+		}
+		if after.IsValid() {
+			afterV = storedFieldValue(after.Elem(), f)
+		}
+		if before.IsValid() && after.IsValid() && equalFieldValues(beforeV, afterV) {
+			continue
+		}
+		changes = append(changes, AuditChange{
+			Field:  f.name,
+			Before: beforeV,
+			After:  afterV,
+		})
+	}
+	return changes
+}
+
+// storedFieldValue returns the value of the field, or nil if it's in a nil embedded struct
+// v must be addressable to read fields of unexported embedded structs.
+func storedFieldValue(v reflect.Value, f *storedField) any {
+	fv, err := v.FieldByIndexErr(f.index)
+	if err != nil {
+		return nil
+	}
+	if !fv.CanInterface() {
+		// promoted from an unexported embedded struct
+		fv = reflect.NewAt(fv.Type(), unsafe.Pointer(fv.UnsafeAddr())).Elem()
+	}
+	return fv.Interface()
+}
+
+func equalFieldValues(a, b any) bool {
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			return at.Equal(bt)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
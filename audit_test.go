@@ -0,0 +1,292 @@
+package simplestore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type AuditedDocument struct {
+	Parent  *ParentDocument
+	ID      string
+	Name    string
+	Count   int
+	Updated time.Time
+	Memo    string `firestore:"-"`
+}
+
+func TestAuditActor(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "", AuditActorFrom(ctx))
+	ctx = WithAuditActor(ctx, "alice")
+	assert.Equal(t, "alice", AuditActorFrom(ctx))
+}
+
+func TestEnableAudit(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	a, err := newAccessor(reflect.TypeOf(&MyDocument{}), client.tableMaps)
+	require.NoError(t, err)
+	assert.False(t, client.isAudited(a))
+
+	require.NoError(t, client.EnableAudit(&MyDocument{}))
+	assert.True(t, client.isAudited(a))
+
+	client.AddAuditedTableMaps(map[string]string{"ParentDocument": "parents"})
+	a, err = newAccessor(reflect.TypeOf(&ParentDocument{}), client.tableMaps)
+	require.NoError(t, err)
+	assert.Equal(t, "parents", a.collectionName)
+	assert.True(t, client.isAudited(a))
+
+	// audit entries are never audited
+	require.NoError(t, client.EnableAudit(&AuditEntry{}))
+	a, err = newAccessor(reflect.TypeOf(&AuditEntry{}), client.tableMaps)
+	require.NoError(t, err)
+	assert.False(t, client.isAudited(a))
+
+	// types with the same name in other packages aren't audited
+	type MyDocument struct {
+		ID string
+	}
+	a, err = newAccessor(reflect.TypeOf(&MyDocument{}), client.tableMaps)
+	require.NoError(t, err)
+	assert.False(t, client.isAudited(a))
+
+	assertProgrammingError(t, client.EnableAudit(packageMyDocument{}))
+
+	_, err = client.Set(ctx, &packageMyDocument{ID: "doc1"}, firestore.MergeAll)
+	assertProgrammingError(t, err)
+}
+
+func TestDiffFields(t *testing.T) {
+	now := time.Now()
+	before := &AuditedDocument{
+		Parent:  &ParentDocument{ID: "parent1"},
+		ID:      "doc1",
+		Name:    "before",
+		Count:   1,
+		Updated: now,
+		Memo:    "before",
+	}
+	after := &AuditedDocument{
+		Parent:  &ParentDocument{ID: "parent2"},
+		ID:      "doc1",
+		Name:    "after",
+		Count:   1,
+		Updated: now.UTC(),
+		Memo:    "after",
+	}
+	typ := reflect.TypeOf(AuditedDocument{})
+
+	assert.Equal(
+		t,
+		[]AuditChange{
+			{Field: "Name", Before: "before", After: "after"},
+		},
+		diffFields(typ, reflect.ValueOf(before), reflect.ValueOf(after)),
+	)
+	assert.Equal(
+		t,
+		[]AuditChange{
+			{Field: "ID", After: "doc1"},
+			{Field: "Name", After: "after"},
+			{Field: "Count", After: 1},
+			{Field: "Updated", After: now.UTC()},
+		},
+		diffFields(typ, reflect.Value{}, reflect.ValueOf(after)),
+	)
+	assert.Equal(
+		t,
+		[]AuditChange{
+			{Field: "ID", Before: "doc1"},
+			{Field: "Name", Before: "before"},
+			{Field: "Count", Before: 1},
+			{Field: "Updated", Before: now},
+		},
+		diffFields(typ, reflect.ValueOf(before), reflect.Value{}),
+	)
+}
+
+type auditedBase struct {
+	Owner string `firestore:"owner"`
+}
+
+type AuditedEmbedded struct {
+	Ref *firestore.DocumentRef
+}
+
+func TestDiffFieldsEmbedded(t *testing.T) {
+	type Document struct {
+		auditedBase
+		*AuditedEmbedded
+		ID    string
+		Title string `firestore:"title"`
+	}
+	before := &Document{auditedBase: auditedBase{Owner: "alice"}, ID: "doc1", Title: "before"}
+	ref := &firestore.DocumentRef{ID: "ref1"}
+	after := &Document{auditedBase: auditedBase{Owner: "bob"}, AuditedEmbedded: &AuditedEmbedded{Ref: ref}, ID: "doc1", Title: "after"}
+	typ := reflect.TypeOf(Document{})
+
+	// fields of embedded structs are flattened with stored names
+	assert.Equal(
+		t,
+		[]AuditChange{
+			{Field: "owner", Before: "alice", After: "bob"},
+			// nil embedded structs have no values
+			{Field: "Ref", Before: nil, After: ref},
+			{Field: "title", Before: "before", After: "after"},
+		},
+		diffFields(typ, reflect.ValueOf(before), reflect.ValueOf(after)),
+	)
+}
+
+func getAuditEntries(t *testing.T, client *Client) []*AuditEntry {
+	var entries []*AuditEntry
+	require.NoError(t, client.Query(&entries).OrderBy("Time", firestore.Asc).GetAll(context.Background()))
+	return entries
+}
+
+func TestAudit(t *testing.T) {
+	clearAllDocuments(t, &AuditEntry{})
+	clearAllDocuments(t, &MyDocument{})
+	ctx := WithAuditActor(context.Background(), "alice")
+	client, err := New(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.EnableAudit(&MyDocument{}))
+
+	doc := &MyDocument{Name: "created"}
+	_, err = client.Create(ctx, doc)
+	require.NoError(t, err)
+	require.NotEmpty(t, doc.ID)
+
+	doc.Name = "updated"
+	_, err = client.Set(ctx, doc)
+	require.NoError(t, err)
+
+	_, err = client.Delete(ctx, doc)
+	require.NoError(t, err)
+
+	entries := getAuditEntries(t, client)
+	require.Len(t, entries, 3)
+	for _, entry := range entries {
+		assert.Equal(t, "alice", entry.Actor)
+		assert.Equal(t, "MyDocument", entry.Collection)
+		assert.Equal(t, "MyDocument/"+doc.ID, entry.Path)
+		assert.False(t, entry.Time.IsZero())
+	}
+	assert.Equal(t, OperationCreate, entries[0].Operation)
+	assert.Equal(
+		t,
+		[]AuditChange{
+			{Field: "ID", After: doc.ID},
+			{Field: "Name", After: "created"},
+		},
+		entries[0].Changes,
+	)
+	assert.Equal(t, OperationSet, entries[1].Operation)
+	assert.Equal(
+		t,
+		[]AuditChange{
+			{Field: "Name", Before: "created", After: "updated"},
+		},
+		entries[1].Changes,
+	)
+	assert.Equal(t, OperationDelete, entries[2].Operation)
+	assert.Equal(
+		t,
+		[]AuditChange{
+			{Field: "ID", Before: doc.ID},
+			{Field: "Name", Before: "updated"},
+		},
+		entries[2].Changes,
+	)
+}
+
+func TestAuditInTransaction(t *testing.T) {
+	clearAllDocuments(t, &AuditEntry{})
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	client.AddAuditedTableMaps(map[string]string{"MyDocument": "MyDocument"})
+
+	// rolled back with the change
+	errRollback := errors.New("rollback")
+	err = client.RunTransaction(ctx, func(ctx context.Context, client *Client) error {
+		if _, err := client.Set(ctx, &MyDocument{ID: "doc1", Name: "test"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+	assert.Empty(t, getAuditEntries(t, client))
+
+	err = client.RunTransaction(ctx, func(ctx context.Context, client *Client) error {
+		_, err := client.Set(ctx, &MyDocument{ID: "doc1", Name: "test"})
+		return err
+	})
+	require.NoError(t, err)
+	entries := getAuditEntries(t, client)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "", entries[0].Actor)
+	assert.Equal(t, OperationSet, entries[0].Operation)
+	assert.Equal(t, "MyDocument/doc1", entries[0].Path)
+}
+
+func TestAuditMultipleWritesInTransaction(t *testing.T) {
+	clearAllDocuments(t, &AuditEntry{})
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.EnableAudit(&MyDocument{}))
+
+	_, err = client.Create(ctx, &MyDocument{ID: "doc1", Name: "before"})
+	require.NoError(t, err)
+	clearAllDocuments(t, &AuditEntry{})
+
+	// reads for the second write are before writes
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		if _, err := tc.Set(ctx, &MyDocument{ID: "doc1", Name: "after"}); err != nil {
+			return err
+		}
+		_, err := tc.Create(ctx, &MyDocument{ID: "doc2", Name: "created"})
+		return err
+	})
+	require.NoError(t, err)
+	entries := getAuditEntries(t, client)
+	require.Len(t, entries, 2)
+	byPath := map[string]*AuditEntry{}
+	for _, entry := range entries {
+		byPath[entry.Path] = entry
+	}
+	require.Contains(t, byPath, "MyDocument/doc1")
+	assert.Equal(
+		t,
+		[]AuditChange{
+			{Field: "Name", Before: "before", After: "after"},
+		},
+		byPath["MyDocument/doc1"].Changes,
+	)
+	require.Contains(t, byPath, "MyDocument/doc2")
+	assert.Equal(t, OperationCreate, byPath["MyDocument/doc2"].Operation)
+
+	// the diff of the second write would miss the first write
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		if _, err := tc.Set(ctx, &MyDocument{ID: "doc1", Name: "first"}); err != nil {
+			return err
+		}
+		_, err := tc.Set(ctx, &MyDocument{ID: "doc1", Name: "second"})
+		return err
+	})
+	assertProgrammingError(t, err)
+}
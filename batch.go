@@ -0,0 +1,200 @@
+package simplestore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+const (
+	// DefaultBatchWindow is the default duration to wait for other reads to merge
+	DefaultBatchWindow = 2 * time.Millisecond
+	// DefaultMaxBatchSize is the default max number of documents in a batch
+	DefaultMaxBatchSize = 100
+)
+
+type batchConfig struct {
+	window  time.Duration
+	maxSize int
+}
+
+// BatchOption configures batching of reads
+type BatchOption func(*batchConfig)
+
+// WithBatchWindow specifies the duration to wait for other reads to merge
+// Defaults to DefaultBatchWindow.
+func WithBatchWindow(window time.Duration) BatchOption {
+	return func(c *batchConfig) {
+		c.window = window
+	}
+}
+
+// WithMaxBatchSize specifies the max number of documents in a batch
+// A batch is dispatched immediately when it reaches the size.
+// Defaults to DefaultMaxBatchSize.
+func WithMaxBatchSize(n int) BatchOption {
+	return func(c *batchConfig) {
+		c.maxSize = n
+	}
+}
+
+// batcher merges concurrent reads into a single `GetAll` call
+type batcher struct {
+	batchConfig
+	mu      sync.Mutex
+	pending map[*firestore.Client]*batch
+}
+
+// batch is the set of reads merged into a single `GetAll` call
+// The batch reads with values of the context of the first caller,
+// and with the latest deadline of callers (no deadline if any caller has no deadline).
+// It's canceled only when all callers give up.
+type batch struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	deadline   time.Time
+	noDeadline bool
+	waiting    int
+	docs       []*firestore.DocumentRef
+	fetch      snapshotFetcher
+	timer      *time.Timer
+	done       chan struct{}
+	results    []*firestore.DocumentSnapshot
+	err        error
+}
+
+func newBatcher(opts ...BatchOption) *batcher {
+	b := &batcher{
+		batchConfig: batchConfig{
+			window:  DefaultBatchWindow,
+			maxSize: DefaultMaxBatchSize,
+		},
+		pending: map[*firestore.Client]*batch{},
+	}
+	for _, opt := range opts {
+		opt(&b.batchConfig)
+	}
+	return b
+}
+
+// EnableBatching merges concurrent `Get` and `GetAll` calls of the client into a single `GetAll` call
+// Each call waits for other calls for the batch window.
+// Reads in transactions are never batched.
+// A batch reads with values of the context of the first call and the latest deadline of calls,
+// and is canceled only when all calls are canceled.
+func (c *Client) EnableBatching(opts ...BatchOption) {
+	c.batcher = newBatcher(opts...)
+}
+
+type batchScopeKey struct{}
+
+// WithBatchScope returns a new context with a batch scope
+// Concurrent `Get` and `GetAll` calls with the context are merged into a single `GetAll` call
+// regardless of whether batching is enabled for the client.
+// Calls in different scopes are never merged.
+func WithBatchScope(ctx context.Context, opts ...BatchOption) context.Context {
+	return context.WithValue(ctx, batchScopeKey{}, newBatcher(opts...))
+}
+
+func batcherFrom(ctx context.Context) *batcher {
+	b, _ := ctx.Value(batchScopeKey{}).(*batcher)
+	return b
+}
+
+// getAll enqueues docs to the pending batch and waits for the result
+// fetch of the first caller is used for the batch: it must be same for the same firestore client.
+func (b *batcher) getAll(ctx context.Context, fc *firestore.Client, docs []*firestore.DocumentRef, fetch snapshotFetcher) ([]*firestore.DocumentSnapshot, error) {
+	b.mu.Lock()
+	bt := b.pending[fc]
+	if bt == nil {
+		bt = &batch{
+			fetch: fetch,
+			done:  make(chan struct{}),
+		}
+		// the batch outlives the context of the first caller
+		bt.ctx, bt.cancel = context.WithCancel(detachedContext{parent: ctx})
+		b.pending[fc] = bt
+		bt.timer = time.AfterFunc(b.window, func() {
+			b.dispatch(fc, bt)
+		})
+	}
+	if deadline, ok := ctx.Deadline(); !ok {
+		bt.noDeadline = true
+	} else if deadline.After(bt.deadline) {
+		bt.deadline = deadline
+	}
+	bt.waiting++
+	start := len(bt.docs)
+	bt.docs = append(bt.docs, docs...)
+	full := len(bt.docs) >= b.maxSize
+	b.mu.Unlock()
+
+	if full {
+		b.dispatch(fc, bt)
+	}
+	select {
+	case <-bt.done:
+	case <-ctx.Done():
+		b.mu.Lock()
+		bt.waiting--
+		if bt.waiting == 0 {
+			// nobody waits for the result: later callers start a new batch
+			if b.pending[fc] == bt {
+				delete(b.pending, fc)
+				bt.timer.Stop()
+			}
+			bt.cancel()
+		}
+		b.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	if bt.err != nil {
+		return nil, bt.err
+	}
+	return bt.results[start : start+len(docs)], nil
+}
+
+func (b *batcher) dispatch(fc *firestore.Client, bt *batch) {
+	b.mu.Lock()
+	if b.pending[fc] != bt {
+		// already dispatched
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, fc)
+	bt.timer.Stop()
+	ctx := bt.ctx
+	if !bt.noDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, bt.deadline)
+		defer cancel()
+	}
+	b.mu.Unlock()
+
+	bt.results, bt.err = bt.fetch(ctx, bt.docs)
+	bt.cancel()
+	close(bt.done)
+}
+
+// detachedContext carries values of the parent without its cancellation and deadline
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key any) any {
+	return c.parent.Value(key)
+}
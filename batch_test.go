@@ -0,0 +1,230 @@
+package simplestore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fetchRecorder struct {
+	mu       sync.Mutex
+	batches  [][]string
+	contexts []context.Context
+	ctxErrs  []error
+	err      error
+}
+
+func (r *fetchRecorder) fetch(ctx context.Context, docs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.contexts = append(r.contexts, ctx)
+	r.ctxErrs = append(r.ctxErrs, ctx.Err())
+	var ids []string
+	var docsnapList []*firestore.DocumentSnapshot
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+		docsnapList = append(docsnapList, &firestore.DocumentSnapshot{Ref: doc})
+	}
+	r.batches = append(r.batches, ids)
+	return docsnapList, r.err
+}
+
+func TestBatcherMergesConcurrentReads(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	ids := []string{"1", "2", "3", "4"}
+	// dispatched when all reads are merged
+	b := newBatcher(WithBatchWindow(time.Hour), WithMaxBatchSize(len(ids)))
+	recorder := &fetchRecorder{}
+	results := make([]*firestore.DocumentSnapshot, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			doc := client.FirestoreClient.Collection("MyDocument").Doc(id)
+			docsnapList, err := b.getAll(ctx, client.FirestoreClient, []*firestore.DocumentRef{doc}, recorder.fetch)
+			assert.NoError(t, err)
+			results[i] = docsnapList[0]
+		}(i, id)
+	}
+	wg.Wait()
+
+	require.Len(t, recorder.batches, 1)
+	assert.ElementsMatch(t, ids, recorder.batches[0])
+	for i, id := range ids {
+		assert.Equal(t, id, results[i].Ref.ID)
+	}
+}
+
+func TestBatcherMaxBatchSize(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	b := newBatcher(WithBatchWindow(time.Hour), WithMaxBatchSize(2))
+	recorder := &fetchRecorder{}
+	docs := []*firestore.DocumentRef{
+		client.FirestoreClient.Collection("MyDocument").Doc("1"),
+		client.FirestoreClient.Collection("MyDocument").Doc("2"),
+	}
+	// dispatched immediately without waiting for the window
+	docsnapList, err := b.getAll(ctx, client.FirestoreClient, docs, recorder.fetch)
+	require.NoError(t, err)
+	assert.Len(t, docsnapList, 2)
+	assert.Equal(t, [][]string{{"1", "2"}}, recorder.batches)
+}
+
+func TestBatcherError(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	errFailed := errors.New("failed")
+	b := newBatcher(WithBatchWindow(time.Millisecond))
+	recorder := &fetchRecorder{err: errFailed}
+	doc := client.FirestoreClient.Collection("MyDocument").Doc("1")
+	_, err = b.getAll(ctx, client.FirestoreClient, []*firestore.DocumentRef{doc}, recorder.fetch)
+	assert.ErrorIs(t, err, errFailed)
+}
+
+type batchTestKey struct{}
+
+// waitForPending waits until the pending batch has n documents
+func waitForPending(t *testing.T, b *batcher, fc *firestore.Client, n int) *batch {
+	var bt *batch
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		bt = b.pending[fc]
+		return bt != nil && len(bt.docs) == n
+	}, 5*time.Second, time.Millisecond)
+	return bt
+}
+
+func TestBatcherContext(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	doc1 := client.FirestoreClient.Collection("MyDocument").Doc("1")
+	doc2 := client.FirestoreClient.Collection("MyDocument").Doc("2")
+
+	b := newBatcher(WithBatchWindow(time.Hour), WithMaxBatchSize(3))
+	recorder := &fetchRecorder{}
+	deadline := time.Now().Add(time.Hour)
+	firstCtx, cancelFirst := context.WithDeadline(context.WithValue(ctx, batchTestKey{}, "first"), deadline)
+	defer cancelFirst()
+	secondCtx, cancelSecond := context.WithDeadline(ctx, deadline.Add(time.Hour))
+	defer cancelSecond()
+
+	firstErr := make(chan error)
+	go func() {
+		_, err := b.getAll(firstCtx, client.FirestoreClient, []*firestore.DocumentRef{doc1}, recorder.fetch)
+		firstErr <- err
+	}()
+	waitForPending(t, b, client.FirestoreClient, 1)
+	secondErr := make(chan error)
+	go func() {
+		_, err := b.getAll(secondCtx, client.FirestoreClient, []*firestore.DocumentRef{doc2}, recorder.fetch)
+		secondErr <- err
+	}()
+	waitForPending(t, b, client.FirestoreClient, 2)
+	// the batch isn't canceled when the first caller gives up
+	cancelFirst()
+	assert.ErrorIs(t, <-firstErr, context.Canceled)
+
+	_, err = b.getAll(secondCtx, client.FirestoreClient, []*firestore.DocumentRef{doc1}, recorder.fetch)
+	require.NoError(t, err)
+	require.NoError(t, <-secondErr)
+	require.Len(t, recorder.contexts, 1)
+	fetchCtx := recorder.contexts[0]
+	assert.NoError(t, recorder.ctxErrs[0])
+	// values of the first caller and the latest deadline
+	assert.Equal(t, "first", fetchCtx.Value(batchTestKey{}))
+	fetchDeadline, ok := fetchCtx.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, deadline.Add(time.Hour), fetchDeadline)
+
+	// no deadline if any caller has no deadline
+	b = newBatcher(WithBatchWindow(time.Hour), WithMaxBatchSize(2))
+	recorder = &fetchRecorder{}
+	go func() {
+		_, err := b.getAll(secondCtx, client.FirestoreClient, []*firestore.DocumentRef{doc1}, recorder.fetch)
+		assert.NoError(t, err)
+	}()
+	waitForPending(t, b, client.FirestoreClient, 1)
+	_, err = b.getAll(ctx, client.FirestoreClient, []*firestore.DocumentRef{doc2}, recorder.fetch)
+	require.NoError(t, err)
+	require.Len(t, recorder.contexts, 1)
+	_, ok = recorder.contexts[0].Deadline()
+	assert.False(t, ok)
+}
+
+func TestBatcherCanceledByAllCallers(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	doc := client.FirestoreClient.Collection("MyDocument").Doc("1")
+
+	b := newBatcher(WithBatchWindow(time.Hour))
+	recorder := &fetchRecorder{}
+	callerCtx, cancel := context.WithCancel(ctx)
+	callerErr := make(chan error)
+	go func() {
+		_, err := b.getAll(callerCtx, client.FirestoreClient, []*firestore.DocumentRef{doc}, recorder.fetch)
+		callerErr <- err
+	}()
+	bt := waitForPending(t, b, client.FirestoreClient, 1)
+	assert.NoError(t, bt.ctx.Err())
+	cancel()
+	assert.ErrorIs(t, <-callerErr, context.Canceled)
+	assert.ErrorIs(t, bt.ctx.Err(), context.Canceled)
+
+	// later calls start a new batch
+	b.mu.Lock()
+	assert.Empty(t, b.pending)
+	b.mu.Unlock()
+	assert.Empty(t, recorder.batches)
+}
+
+func TestBatchScope(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	_, err = client.Set(ctx, &MyDocument{ID: "docid1", Name: "Alice"})
+	require.NoError(t, err)
+	_, err = client.Set(ctx, &MyDocument{ID: "docid2", Name: "Bob"})
+	require.NoError(t, err)
+
+	docs := []*MyDocument{{ID: "docid1"}, {ID: "docid2"}, {ID: "docid3"}}
+	ctx = WithBatchScope(ctx, WithBatchWindow(time.Hour), WithMaxBatchSize(len(docs)))
+	typesafed := TypeSafed[MyDocument](client)
+	errs := make([]error, len(docs))
+	var wg sync.WaitGroup
+	for i := range docs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = typesafed.Get(ctx, docs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	assert.NoError(t, errs[0])
+	assert.Equal(t, "Alice", docs[0].Name)
+	assert.NoError(t, errs[1])
+	assert.Equal(t, "Bob", docs[1].Name)
+	assert.Equal(t, codes.NotFound, status.Code(errs[2]))
+}
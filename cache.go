@@ -0,0 +1,285 @@
+package simplestore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+const (
+	// DefaultCacheSize is the default number of documents stored in the LRU cache
+	DefaultCacheSize = 1000
+	// DefaultCacheTTL is the default duration to keep documents in the cache
+	DefaultCacheTTL = 5 * time.Minute
+)
+
+// Cache stores document snapshots
+// Keys are full paths of documents.
+// Snapshots of missing documents are also stored.
+// Implementations must be safe for concurrent use.
+type Cache interface {
+	// Get returns the snapshot for the key
+	// Returns false if not stored or expired.
+	Get(key string) (*firestore.DocumentSnapshot, bool)
+	// Set stores the snapshot for the key
+	// ttl is 0 when the snapshot never expires.
+	Set(key string, docsnap *firestore.DocumentSnapshot, ttl time.Duration)
+	// Delete removes the snapshot for the key
+	Delete(key string)
+}
+
+// CacheStats is statistics of the cache
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+type cacheConfig struct {
+	store       Cache
+	ttl         time.Duration
+	collections map[string]bool
+	listenCtx   context.Context
+}
+
+// CacheOption configures the read-through cache
+type CacheOption func(*cacheConfig)
+
+// WithCacheStore specifies the store of the cache
+// Defaults to an LRU cache with DefaultCacheSize.
+func WithCacheStore(store Cache) CacheOption {
+	return func(c *cacheConfig) {
+		c.store = store
+	}
+}
+
+// WithCacheTTL specifies the duration to keep documents in the cache
+// Defaults to DefaultCacheTTL. 0 to keep documents until evicted.
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.ttl = ttl
+	}
+}
+
+// WithCacheCollections specifies collection names to cache
+// Defaults to collections registered with `AddReadonlyTableMaps`.
+func WithCacheCollections(collections ...string) CacheOption {
+	return func(c *cacheConfig) {
+		c.collections = make(map[string]bool, len(collections))
+		for _, collection := range collections {
+			c.collections[collection] = true
+		}
+	}
+}
+
+// WithCacheSnapshotListener invalidates the cache with snapshot listeners for cached collections
+// Listeners run until ctx is canceled.
+// Without `WithCacheCollections`, listens collections registered with `AddReadonlyTableMaps` before `EnableCache`.
+func WithCacheSnapshotListener(ctx context.Context) CacheOption {
+	return func(c *cacheConfig) {
+		c.listenCtx = ctx
+	}
+}
+
+// readCache is a read-through cache for documents
+type readCache struct {
+	cacheConfig
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// EnableCache enables the read-through cache for `Get` and `GetAll`
+// Collections registered with `AddReadonlyTableMaps` are cached by default.
+// Reads in transactions always read firestore, and writes through the client invalidate the cache.
+func (c *Client) EnableCache(opts ...CacheOption) {
+	rc := &readCache{
+		cacheConfig: cacheConfig{
+			ttl: DefaultCacheTTL,
+		},
+	}
+	for _, opt := range opts {
+		opt(&rc.cacheConfig)
+	}
+	if rc.store == nil {
+		rc.store = NewLRUCache(DefaultCacheSize)
+	}
+	c.cache = rc
+	if rc.listenCtx != nil {
+		for _, collection := range c.cachedCollections() {
+			go rc.listen(rc.listenCtx, c.FirestoreClient.CollectionGroup(collection).Query)
+		}
+	}
+}
+
+// CacheStats returns statistics of the read-through cache
+// Returns zero values if the cache is not enabled.
+func (c *Client) CacheStats() CacheStats {
+	if c.cache == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Hits:   c.cache.hits.Load(),
+		Misses: c.cache.misses.Load(),
+	}
+}
+
+func (c *Client) cachedCollections() []string {
+	var collections []string
+	if c.cache.collections != nil {
+		for collection := range c.cache.collections {
+			collections = append(collections, collection)
+		}
+		return collections
+	}
+	for _, entry := range c.tableMaps {
+		if entry.ReadOnly {
+			collections = append(collections, entry.CollectionName)
+		}
+	}
+	return collections
+}
+
+// isCached returns whether the document is in a cached collection
+func (c *Client) isCached(doc *firestore.DocumentRef) bool {
+	if c.cache.collections != nil {
+		return c.cache.collections[doc.Parent.ID]
+	}
+	for _, entry := range c.tableMaps {
+		if entry.ReadOnly && entry.CollectionName == doc.Parent.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// getAllCached returns snapshots of documents in the cache, and fetches others
+func (c *Client) getAllCached(ctx context.Context, op *Operation, docs []*firestore.DocumentRef, fetch snapshotFetcher) ([]*firestore.DocumentSnapshot, error) {
+	rc := c.cache
+	misses := 0
+	docsnapList, served, err := getSnapshotsThrough(
+		ctx,
+		docs,
+		func(doc *firestore.DocumentRef) (*firestore.DocumentSnapshot, bool) {
+			if !c.isCached(doc) {
+				return nil, false
+			}
+			docsnap, ok := rc.store.Get(doc.Path)
+			if !ok {
+				misses++
+			}
+			return docsnap, ok
+		},
+		fetch,
+		func(docsnap *firestore.DocumentSnapshot) {
+			if c.isCached(docsnap.Ref) {
+				rc.store.Set(docsnap.Ref.Path, docsnap, rc.ttl)
+			}
+		},
+	)
+	rc.hits.Add(int64(served))
+	rc.misses.Add(int64(misses))
+	op.CachedCount += served
+	return docsnapList, err
+}
+
+func (rc *readCache) invalidate(doc *firestore.DocumentRef) {
+	if doc == nil {
+		return
+	}
+	rc.store.Delete(doc.Path)
+}
+
+func (rc *readCache) listen(ctx context.Context, q firestore.Query) {
+	iter := q.Snapshots(ctx)
+	defer iter.Stop()
+	for {
+		snap, err := iter.Next()
+		if err != nil {
+			// stops on cancel and on unrecoverable errors
+			return
+		}
+		for _, change := range snap.Changes {
+			rc.store.Delete(change.Doc.Ref.Path)
+		}
+	}
+}
+
+// LRUCache is an in-memory Cache with the LRU eviction
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type lruEntry struct {
+	key     string
+	docsnap *firestore.DocumentSnapshot
+	expires time.Time
+}
+
+var _ Cache = (*LRUCache)(nil)
+
+// NewLRUCache returns a new LRUCache storing up to size documents
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// Get is an implementation for Cache
+func (c *LRUCache) Get(key string) (*firestore.DocumentSnapshot, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.docsnap, true
+}
+
+// Set is an implementation for Cache
+func (c *LRUCache) Set(key string, docsnap *firestore.DocumentSnapshot, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry{
+		key:     key,
+		docsnap: docsnap,
+	}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Delete is an implementation for Cache
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
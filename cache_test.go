@@ -0,0 +1,85 @@
+package simplestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache(2)
+	snap1 := &firestore.DocumentSnapshot{}
+	snap2 := &firestore.DocumentSnapshot{}
+	snap3 := &firestore.DocumentSnapshot{}
+
+	cache.Set("1", snap1, 0)
+	cache.Set("2", snap2, 0)
+	// touch 1 to make 2 the oldest
+	_, ok := cache.Get("1")
+	assert.True(t, ok)
+	cache.Set("3", snap3, 0)
+
+	_, ok = cache.Get("2")
+	assert.False(t, ok, "the least recently used entry is evicted")
+	got, ok := cache.Get("1")
+	assert.True(t, ok)
+	assert.Same(t, snap1, got)
+
+	cache.Delete("1")
+	_, ok = cache.Get("1")
+	assert.False(t, ok)
+
+	cache.Set("4", snap1, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	_, ok = cache.Get("4")
+	assert.False(t, ok, "expired entries are not returned")
+}
+
+func TestGetAllCached(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	client.AddReadonlyTableMaps(map[string]string{
+		"TestReadOnlyDocument": "readonly_collection",
+	})
+	client.EnableCache()
+
+	recorder := &fetchRecorder{}
+	readonlyDoc := client.FirestoreClient.Collection("readonly_collection").Doc("1")
+	normalDoc := client.FirestoreClient.Collection("MyDocument").Doc("1")
+	for i := 0; i < 2; i++ {
+		docsnapList, err := client.getAllCached(ctx, &Operation{}, []*firestore.DocumentRef{readonlyDoc, normalDoc}, recorder.fetch)
+		require.NoError(t, err)
+		assert.Equal(t, readonlyDoc, docsnapList[0].Ref)
+		assert.Equal(t, normalDoc, docsnapList[1].Ref)
+	}
+	assert.Equal(t, [][]string{{"1", "1"}, {"1"}}, recorder.batches)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, client.CacheStats())
+}
+
+func TestCacheForReadonlyCollection(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	require.NoError(t, DeleteCollection(ctx, client.FirestoreClient, "readonly_collection", 100))
+	client.AddReadonlyTableMaps(map[string]string{
+		"TestReadOnlyDocument": "readonly_collection",
+	})
+	client.EnableCache(WithCacheTTL(time.Minute))
+
+	_, err = client.FirestoreClient.Collection("readonly_collection").Doc("config1").Set(ctx, map[string]any{
+		"Name": "Alice",
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		doc := &TestReadOnlyDocument{ID: "config1"}
+		require.NoError(t, client.Get(ctx, doc))
+		assert.Equal(t, "Alice", doc.Name)
+	}
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1}, client.CacheStats())
+}
@@ -0,0 +1,192 @@
+package simplestore
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+)
+
+const (
+	// ChildrenTagOption is the tag option for fields to load children
+	// Fields must be also tagged with `firestore:"-"` not to be stored.
+	// e.g. `firestore:"-" simplestore:"children"`
+	ChildrenTagOption = "children"
+	// DefaultChildConcurrency is the default number of queries run concurrently to load children
+	DefaultChildConcurrency = 10
+)
+
+type childConfig struct {
+	modifiers   []func(q *Query) *Query
+	concurrency int
+}
+
+// ChildOption configures loading children
+type ChildOption func(*childConfig)
+
+// ChildWhere sets the condition for the query of children
+func ChildWhere(path, op string, value any) ChildOption {
+	return func(c *childConfig) {
+		c.modifiers = append(c.modifiers, func(q *Query) *Query {
+			return q.Where(path, op, value)
+		})
+	}
+}
+
+// ChildOrderBy sets the order of children
+func ChildOrderBy(path string, dir firestore.Direction) ChildOption {
+	return func(c *childConfig) {
+		c.modifiers = append(c.modifiers, func(q *Query) *Query {
+			return q.OrderBy(path, dir)
+		})
+	}
+}
+
+// ChildLimit sets the max count of children for each parent
+func ChildLimit(n int) ChildOption {
+	return func(c *childConfig) {
+		c.modifiers = append(c.modifiers, func(q *Query) *Query {
+			return q.Limit(n)
+		})
+	}
+}
+
+// ChildConcurrency sets the number of queries run concurrently
+// Defaults to DefaultChildConcurrency. Queries in transactions are always run sequentially.
+func ChildConcurrency(n int) ChildOption {
+	return func(c *childConfig) {
+		c.concurrency = n
+	}
+}
+
+type childrenLoad struct {
+	field string
+	opts  []ChildOption
+}
+
+// With loads children to the field of results
+// The field must be a slice of pointers to structs tagged with `simplestore:"children"`.
+// Children are queried for each result concurrently.
+func (q *Query) With(field string, opts ...ChildOption) *Query {
+	newQ := *q
+	// avoid sharing the backing array with other queries
+	newQ.withs = append(q.withs[:len(q.withs):len(q.withs)], childrenLoad{field: field, opts: opts})
+	return &newQ
+}
+
+// Load loads children to the field of o
+// o must be a pointer to a struct or a slice of pointers to structs.
+// The field must be a slice of pointers to structs tagged with `simplestore:"children"`,
+// and Parent of children is set to the object.
+// Children are queried for each object concurrently, and once for objects appearing multiple times.
+func (c *Client) Load(ctx context.Context, o any, field string, opts ...ChildOption) error {
+	cfg := &childConfig{
+		concurrency: DefaultChildConcurrency,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if c.FirestoreTransaction != nil || cfg.concurrency < 1 {
+		cfg.concurrency = 1
+	}
+
+	v := reflect.ValueOf(o)
+	if !v.IsValid() {
+		return NewProgrammingError("value must be a pointer of a struct or a slice of pointers of structs")
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct {
+		return NewProgrammingError("value must be a pointer of a struct or a slice of pointers of structs")
+	}
+	f, ok := t.Elem().FieldByName(field)
+	if !ok {
+		return NewProgrammingErrorf("field doesn't exist: %s.%s.%s", t.Elem().PkgPath(), t.Elem().Name(), field)
+	}
+	if !hasTagOption(f, ChildrenTagOption) {
+		return NewProgrammingErrorf("field must be tagged with %s:\"%s\": %s.%s.%s", TagName, ChildrenTagOption, t.Elem().PkgPath(), t.Elem().Name(), field)
+	}
+	if err := validateChildrenFields(t.Elem()); err != nil {
+		return err
+	}
+
+	var parents []reflect.Value
+	if v.Kind() == reflect.Slice {
+		// the same object appearing multiple times is loaded once
+		seen := make(map[uintptr]bool, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if !v.Index(i).IsNil() && !seen[v.Index(i).Pointer()] {
+				seen[v.Index(i).Pointer()] = true
+				parents = append(parents, v.Index(i))
+			}
+		}
+	} else if !v.IsNil() {
+		parents = append(parents, v)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, cfg.concurrency)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for _, parent := range parents {
+		parent := parent
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := c.loadChildren(ctx, parent, f, cfg); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (c *Client) loadChildren(ctx context.Context, parent reflect.Value, f reflect.StructField, cfg *childConfig) error {
+	// start with an empty slice to tell loaded from not loaded
+	target := reflect.New(f.Type)
+	target.Elem().Set(reflect.MakeSlice(f.Type, 0, 0))
+	q, err := c.QueryNestedSafe(parent.Interface(), target.Interface())
+	if err != nil {
+		return err
+	}
+	for _, modify := range cfg.modifiers {
+		q = modify(q)
+	}
+	if err := q.GetAll(ctx); err != nil {
+		return err
+	}
+	parent.Elem().FieldByIndex(f.Index).Set(target.Elem())
+	return nil
+}
+
+// validateChildrenFields checks fields for children are not stored
+func validateChildrenFields(t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !hasTagOption(f, ChildrenTagOption) {
+			continue
+		}
+		if f.Type.Kind() != reflect.Slice || f.Type.Elem().Kind() != reflect.Pointer || f.Type.Elem().Elem().Kind() != reflect.Struct {
+			return NewProgrammingErrorf("children field must be a slice of pointers of structs: %s.%s.%s", t.PkgPath(), t.Name(), f.Name)
+		}
+		if firestoreFieldName(f) != "" {
+			return NewProgrammingErrorf("children field must be tagged with firestore:\"-\": %s.%s.%s", t.PkgPath(), t.Name(), f.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package simplestore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Order struct {
+	ID    string
+	Name  string
+	Items []*OrderItem `firestore:"-" simplestore:"children"`
+}
+
+type OrderItem struct {
+	Parent *Order
+	ID     string
+	Price  int
+}
+
+func TestChildrenFieldValidation(t *testing.T) {
+	_, err := newAccessor(reflect.TypeOf(&Order{}), nil)
+	assert.NoError(t, err)
+
+	_, err = newAccessor(reflect.TypeOf(&struct {
+		ID    string
+		Items []*OrderItem `simplestore:"children"`
+	}{}), nil)
+	assertProgrammingError(t, err)
+
+	_, err = newAccessor(reflect.TypeOf(&struct {
+		ID    string
+		Items []OrderItem `firestore:"-" simplestore:"children"`
+	}{}), nil)
+	assertProgrammingError(t, err)
+
+	ctx := context.Background()
+	client := &Client{}
+	assertProgrammingError(t, client.Load(ctx, &Order{}, "Unknown"))
+	assertProgrammingError(t, client.Load(ctx, &Order{}, "Name"))
+	assertProgrammingError(t, client.Load(ctx, Order{}, "Items"))
+	assertProgrammingError(t, client.Load(ctx, nil, "Items"))
+	assert.NoError(t, client.Load(ctx, []*Order{nil}, "Items"))
+}
+
+func createOrders(t *testing.T, client *Client) []*Order {
+	ctx := context.Background()
+	orders := []*Order{
+		{ID: "order1", Name: "Order1"},
+		{ID: "order2", Name: "Order2"},
+		{ID: "order3", Name: "Order3"},
+	}
+	for i, order := range orders {
+		_, err := client.Set(ctx, order)
+		require.NoError(t, err)
+		for j := 0; j < i; j++ {
+			_, err := client.Create(ctx, &OrderItem{Parent: order, Price: (j + 1) * 100})
+			require.NoError(t, err)
+		}
+	}
+	return orders
+}
+
+func TestLoadChildren(t *testing.T) {
+	clearAllDocuments(t, &Order{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	createOrders(t, client)
+
+	order := &Order{ID: "order3"}
+	require.NoError(t, client.Get(ctx, order))
+	require.NoError(t, client.Load(ctx, order, "Items", ChildOrderBy("Price", firestore.Desc)))
+	require.Len(t, order.Items, 2)
+	assert.Equal(t, 200, order.Items[0].Price)
+	assert.Equal(t, 100, order.Items[1].Price)
+	assert.Same(t, order, order.Items[0].Parent)
+
+	// children fields are not stored
+	_, err = client.Set(ctx, order)
+	require.NoError(t, err)
+	stored := &Order{ID: "order3"}
+	require.NoError(t, client.Get(ctx, stored))
+	assert.Nil(t, stored.Items)
+
+	orders := []*Order{{ID: "order1"}, {ID: "order2"}, {ID: "order3"}}
+	require.NoError(t, client.Load(ctx, orders, "Items", ChildLimit(1), ChildConcurrency(2)))
+	assert.Equal(t, []*OrderItem{}, orders[0].Items)
+	assert.Len(t, orders[1].Items, 1)
+	assert.Len(t, orders[2].Items, 1)
+
+	// duplicated objects are loaded once
+	var ops []Operation
+	client.Use(recordOperations(&ops))
+	require.NoError(t, client.Load(ctx, []*Order{order, order}, "Items"))
+	assert.Len(t, order.Items, 2)
+	queries := 0
+	for _, op := range ops {
+		if op.Kind == OperationQuery {
+			queries++
+		}
+	}
+	assert.Equal(t, 1, queries)
+}
+
+func TestQueryWith(t *testing.T) {
+	clearAllDocuments(t, &Order{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	createOrders(t, client)
+
+	var ops []Operation
+	client.Use(recordOperations(&ops))
+
+	var orders []*Order
+	err = client.Query(&orders).
+		OrderBy("Name", firestore.Asc).
+		With("Items", ChildWhere("Price", ">=", 200)).
+		GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, orders, 3)
+	assert.Empty(t, orders[0].Items)
+	assert.Empty(t, orders[1].Items)
+	require.Len(t, orders[2].Items, 1)
+	assert.Equal(t, 200, orders[2].Items[0].Price)
+	assert.Same(t, orders[2], orders[2].Items[0].Parent)
+	// a query for orders and a query for each order
+	assert.Len(t, ops, 4)
+}
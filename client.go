@@ -25,6 +25,8 @@ package simplestore
 import (
 	"context"
 	"os"
+	"reflect"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/option"
@@ -40,6 +42,8 @@ var KnownProjectIDEnvs = []string{
 type TableMapEntry struct {
 	CollectionName string
 	ReadOnly       bool
+	// Audit records writes to the collection as AuditEntry.
+	Audit bool
 }
 
 // Client is a client for simplestore
@@ -51,6 +55,19 @@ type Client struct {
 	DatabaseID                  string
 	transactionFailureCallbacks []func()
 	tableMaps                   map[string]TableMapEntry
+	middlewares                 []Middleware
+	transactionAttempt          int
+	txWrites                    *transactionWrites
+	batcher                     *batcher
+	cache                       *readCache
+	types                       map[reflect.Type]bool
+	auditedTypes                map[reflect.Type]bool
+	historyTypes                map[reflect.Type]*historyConfig
+	migrations                  []*Migration
+	schemaTypes                 map[reflect.Type]*schemaConfig
+	clientOptions               []option.ClientOption
+	readTime                    time.Time
+	readOnlyTransaction         bool
 }
 
 // New returns a new client
@@ -84,6 +101,7 @@ func NewClientWithProjectIDAndDatabase(ctx context.Context, projectID, database
 		FirestoreClient: client,
 		ProjectID:       actualProjectID,
 		DatabaseID:      database,
+		clientOptions:   opts,
 	}, nil
 }
 
@@ -141,3 +159,42 @@ func (c *Client) AddReadonlyTableMaps(tableMap map[string]string) {
 		}
 	}
 }
+
+// WithTableMaps returns a new client with table mapping configurations added
+// c is not changed. Use for collections of a component sharing the client.
+func (c *Client) WithTableMaps(tableMap map[string]string) *Client {
+	newClient := *c
+	newClient.tableMaps = make(map[string]TableMapEntry, len(c.tableMaps)+len(tableMap))
+	for structName, entry := range c.tableMaps {
+		newClient.tableMaps[structName] = entry
+	}
+	newClient.AddTableMaps(tableMap)
+	return &newClient
+}
+
+// AddAuditedTableMaps adds table mapping configurations with auditing to the client
+// tableMap maps struct names to collection names
+func (c *Client) AddAuditedTableMaps(tableMap map[string]string) {
+	if c.tableMaps == nil {
+		c.tableMaps = make(map[string]TableMapEntry, len(tableMap))
+	}
+	for structName, collectionName := range tableMap {
+		c.tableMaps[structName] = TableMapEntry{
+			CollectionName: collectionName,
+			Audit:          true,
+		}
+	}
+}
+
+// CheckWritableCollection returns ProgrammingError if the client must not write to the collection
+// Collections of readonly table mappings are not writable,
+// and neither are any collections for clients from `AtReadTime` and in read-only transactions.
+// Use this to check before writing with `FirestoreClient` directly.
+func (c *Client) CheckWritableCollection(collectionName string) error {
+	for _, entry := range c.tableMaps {
+		if entry.ReadOnly && entry.CollectionName == collectionName {
+			return NewProgrammingErrorf("cannot write to readonly collection: %s", collectionName)
+		}
+	}
+	return c.checkWritable()
+}
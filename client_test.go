@@ -65,3 +65,16 @@ func TestNewWithProjectID(t *testing.T) {
 	require.NoError(t, err, "expected no error while creating client with project ID")
 	assert.NotNil(t, client, "client should not be nil")
 }
+
+func TestWithTableMaps(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	client.AddReadonlyTableMaps(map[string]string{"TestSimpleDoc": "simple"})
+
+	mapped := client.WithTableMaps(map[string]string{"MyDocument": "documents"})
+	assert.Equal(t, "documents", mapped.GetDocumentRef(&MyDocument{ID: "a"}).Parent.ID)
+	assert.Equal(t, TableMapEntry{CollectionName: "simple", ReadOnly: true}, mapped.tableMaps["TestSimpleDoc"])
+	// the original client is not changed
+	assert.Equal(t, "MyDocument", client.GetDocumentRef(&MyDocument{ID: "a"}).Parent.ID)
+}
@@ -0,0 +1,467 @@
+/*
+Command simplestore exports and imports firestore documents as JSON Lines,
+compares and syncs documents between databases, and shows states of migrations.
+
+Usage:
+
+	simplestore [global flags] export [flags] PATH
+	simplestore [global flags] import [flags] [FILE]
+	simplestore [global flags] diff -dest-database DATABASE [flags] [PATH...]
+	simplestore [global flags] migrations [flags]
+
+Global flags:
+
+	-project string   project ID (defaults to CLOUDSDK_CORE_PROJECT, GOOGLE_CLOUD_PROJECT or credentials)
+	-database string  database ID (defaults to "(default)")
+
+PATH with odd segments (`User`, `User/alice/Post`) exports the collection,
+and with even segments (`User/alice`) exports the document and all documents under it.
+diff compares documents in PATHs, or all documents if omitted,
+and -apply makes documents in the destination same as in the database of global flags.
+-protect marks collections in the destination that -apply must not write to.
+diff loads all compared documents of both databases into memory,
+so specify PATHs for large databases.
+migrations lists states of migrations run with `Client.Migrate`.
+Set FIRESTORE_EMULATOR_HOST to connect to the emulator.
+See the package dump for the format.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ikedam/simplestore"
+	"github.com/ikedam/simplestore/dump"
+)
+
+// errUsage indicates wrong arguments, which are already reported
+var errUsage = errors.New("usage error")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "simplestore: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type globalFlags struct {
+	project  string
+	database string
+}
+
+func (g *globalFlags) newClient(ctx context.Context) (*simplestore.Client, error) {
+	if g.project == "" {
+		return simplestore.NewClientWithDatabase(ctx, g.database)
+	}
+	return simplestore.NewClientWithProjectIDAndDatabase(ctx, g.project, g.database)
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	g := &globalFlags{}
+	fs := flag.NewFlagSet("simplestore", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&g.project, "project", "", "project ID")
+	fs.StringVar(&g.database, "database", firestore.DefaultDatabaseID, "database ID")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: simplestore [global flags] export|import|diff|migrations [flags] ...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return errUsage
+	}
+	commands := map[string]func(ctx context.Context, g *globalFlags, args []string, stdin io.Reader, stdout, stderr io.Writer) error{
+		"export":     runExport,
+		"import":     runImport,
+		"diff":       runDiff,
+		"migrations": runMigrations,
+	}
+	command, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command: %s\n", fs.Arg(0))
+		fs.Usage()
+		return errUsage
+	}
+	return command(ctx, g, fs.Args()[1:], stdin, stdout, stderr)
+}
+
+func runExport(ctx context.Context, g *globalFlags, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("o", "-", "output file (- for stdout)")
+	group := fs.Bool("group", false, "export the collection group with the ID PATH")
+	recursive := fs.Bool("recursive", false, "export also subcollections of collections")
+	readTime := fs.String("read-time", "", "export documents at the time (RFC 3339)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: simplestore export [flags] PATH")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	source := dump.ParseSource(fs.Arg(0))
+	if *group {
+		if strings.Contains(fs.Arg(0), "/") {
+			fmt.Fprintln(stderr, "collection group ID must not contain /")
+			fs.Usage()
+			return errUsage
+		}
+		source = dump.CollectionGroup(fs.Arg(0))
+	}
+	var at time.Time
+	if *readTime != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, *readTime); err != nil {
+			fmt.Fprintf(stderr, "invalid -read-time: %v\n", err)
+			fs.Usage()
+			return errUsage
+		}
+	}
+
+	client, err := g.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if !at.IsZero() {
+		view, err := client.AtReadTime(at)
+		if err != nil {
+			return err
+		}
+		defer view.Close()
+		client = view
+	}
+
+	w := stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	var opts []dump.ExportOption
+	if *recursive {
+		opts = append(opts, dump.WithRecursive())
+	}
+	n, err := dump.Export(ctx, client, w, source, opts...)
+	fmt.Fprintf(stderr, "exported %d documents from %s\n", n, source)
+	return err
+}
+
+// rewriteFlags is a flag for path rewrites `FROM=TO` specified multiple times
+type rewriteFlags []string
+
+func (r *rewriteFlags) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *rewriteFlags) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return errors.New("must be FROM=TO")
+	}
+	*r = append(*r, value)
+	return nil
+}
+
+func runImport(ctx context.Context, g *globalFlags, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	batchSize := fs.Int("batch-size", dump.DefaultBatchSize, "number of documents written at once")
+	dryRun := fs.Bool("dry-run", false, "validate records without writing")
+	var rewrites rewriteFlags
+	fs.Var(&rewrites, "rewrite", "rewrite path prefix FROM=TO of documents and references (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: simplestore import [flags] [FILE]")
+		fmt.Fprintln(stderr, "Reads stdin if FILE is omitted or -.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+	if *batchSize < 1 {
+		fmt.Fprintf(stderr, "-batch-size must be positive: %d\n", *batchSize)
+		fs.Usage()
+		return errUsage
+	}
+
+	r := stdin
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	client, err := g.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	opts := []dump.ImportOption{dump.WithBatchSize(*batchSize)}
+	for _, rw := range rewrites {
+		from, to, _ := strings.Cut(rw, "=")
+		opts = append(opts, dump.WithPathRewrite(from, to))
+	}
+	if *dryRun {
+		opts = append(opts, dump.WithDryRun())
+	}
+	n, err := dump.Import(ctx, client, r, opts...)
+	if *dryRun {
+		fmt.Fprintf(stderr, "validated %d documents (dry run)\n", n)
+	} else {
+		fmt.Fprintf(stderr, "imported %d documents\n", n)
+	}
+	return err
+}
+
+// collectionFlags is a flag for collection names specified multiple times
+type collectionFlags []string
+
+func (c *collectionFlags) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *collectionFlags) Set(value string) error {
+	if value == "" || strings.Contains(value, "/") {
+		return errors.New("must be a collection name")
+	}
+	*c = append(*c, value)
+	return nil
+}
+
+// checkProtectedPath fails if any collections in the path are protected
+func checkProtectedPath(path string, protected []string) error {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(segments); i += 2 {
+		for _, collection := range protected {
+			if segments[i] == collection {
+				return fmt.Errorf("%s: cannot write to protected collection: %s", path, collection)
+			}
+		}
+	}
+	return nil
+}
+
+func runDiff(ctx context.Context, g *globalFlags, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dest := &globalFlags{}
+	fs.StringVar(&dest.project, "dest-project", "", "project ID of the destination (defaults to -project)")
+	fs.StringVar(&dest.database, "dest-database", "", "database ID of the destination (required)")
+	recursive := fs.Bool("recursive", false, "compare also subcollections of collections")
+	jsonOutput := fs.Bool("json", false, "write differences as JSON Lines")
+	apply := fs.Bool("apply", false, "write differences to the destination")
+	batchSize := fs.Int("batch-size", dump.DefaultBatchSize, "number of documents written at once with -apply")
+	skipRemoved := fs.Bool("skip-removed", false, "keep documents existing only in the destination with -apply")
+	var protected collectionFlags
+	fs.Var(&protected, "protect", "collection in the destination not to write to with -apply (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: simplestore diff -dest-database DATABASE [flags] [PATH...]")
+		fmt.Fprintln(stderr, "Compares all documents if PATH is omitted.")
+		fmt.Fprintln(stderr, "Loads all compared documents of both databases into memory.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if dest.database == "" {
+		fmt.Fprintln(stderr, "-dest-database is required")
+		fs.Usage()
+		return errUsage
+	}
+	if dest.project == "" {
+		dest.project = g.project
+	}
+	if dest.project == g.project && dest.database == g.database {
+		fmt.Fprintln(stderr, "the destination must be another database")
+		fs.Usage()
+		return errUsage
+	}
+	if *batchSize < 1 {
+		fmt.Fprintf(stderr, "-batch-size must be positive: %d\n", *batchSize)
+		fs.Usage()
+		return errUsage
+	}
+	sources := []dump.Source{dump.Database()}
+	if fs.NArg() > 0 {
+		sources = nil
+		for _, path := range fs.Args() {
+			if *apply {
+				// fail before reading documents
+				if err := checkProtectedPath(path, protected); err != nil {
+					return err
+				}
+			}
+			sources = append(sources, dump.ParseSource(path))
+		}
+	}
+
+	src, err := g.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := dest.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	readonly := make(map[string]string, len(protected))
+	for _, collection := range protected {
+		readonly[collection] = collection
+	}
+	// makes dump.Apply fail without writing anything
+	dst.AddReadonlyTableMaps(readonly)
+
+	var exportOpts []dump.ExportOption
+	if *recursive {
+		exportOpts = append(exportOpts, dump.WithRecursive())
+	}
+	var diffs []*dump.DocumentDiff
+	for _, source := range sources {
+		found, err := dump.Diff(ctx, src, dst, source, exportOpts...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		diffs = append(diffs, found...)
+	}
+	if err := writeDiffs(stdout, diffs, *jsonOutput); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "found %d different documents\n", len(diffs))
+	if !*apply {
+		return nil
+	}
+
+	applyOpts := []dump.ApplyOption{dump.WithApplyBatchSize(*batchSize)}
+	if *skipRemoved {
+		applyOpts = append(applyOpts, dump.WithSkipRemoved())
+	}
+	n, err := dump.Apply(ctx, dst, diffs, applyOpts...)
+	fmt.Fprintf(stderr, "applied %d documents\n", n)
+	return err
+}
+
+// writeDiffs writes differences as text like `diff`, or as JSON Lines
+func writeDiffs(w io.Writer, diffs []*dump.DocumentDiff, jsonOutput bool) error {
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		for _, diff := range diffs {
+			if err := enc.Encode(diff); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	marks := map[dump.ChangeKind]string{
+		dump.ChangeAdded:   "+",
+		dump.ChangeRemoved: "-",
+		dump.ChangeChanged: "~",
+	}
+	for _, diff := range diffs {
+		if _, err := fmt.Fprintf(w, "%s %s\n", marks[diff.Kind], diff.Path); err != nil {
+			return err
+		}
+		for _, field := range diff.Fields {
+			if _, err := fmt.Fprintf(w, "\t%s: %s -> %s\n", field.Field, formatValue(field.Destination), formatValue(field.Source)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatValue(v dump.Value) string {
+	if v == nil {
+		return "(missing)"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func runMigrations(ctx context.Context, g *globalFlags, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("migrations", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	jsonOutput := fs.Bool("json", false, "write states as JSON Lines")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: simplestore migrations [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	client, err := g.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	states, err := client.MigrationStates(ctx)
+	if err != nil {
+		return err
+	}
+	return writeMigrationStates(stdout, states, *jsonOutput)
+}
+
+// writeMigrationStates writes states as a table, or as JSON Lines
+func writeMigrationStates(w io.Writer, states []*simplestore.MigrationState, jsonOutput bool) error {
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		for _, state := range states {
+			if err := enc.Encode(state); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tPROCESSED\tCHANGED\tUPDATED\tLAST ERROR")
+	for _, state := range states {
+		updated := ""
+		if !state.UpdatedAt.IsZero() {
+			updated = state.UpdatedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", state.ID, state.Status, state.Processed, state.Changed, updated, state.LastError)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ikedam/simplestore"
+	"github.com/ikedam/simplestore/dump"
+	"github.com/ikedam/simplestore/simplestoretest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type Document struct {
+	ID   string
+	Name string
+}
+
+type CommandTestSuite struct {
+	simplestoretest.FirestoreTestSuite
+}
+
+func TestCommandTestSuite(t *testing.T) {
+	suite.Run(t, new(CommandTestSuite))
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"-unknown", "export", "Document"},
+		{"export"},
+		{"export", "Document", "Other"},
+		{"export", "-group", "Document/doc1/Child"},
+		{"export", "-read-time", "yesterday", "Document"},
+		{"import", "a.jsonl", "b.jsonl"},
+		{"import", "-batch-size", "0"},
+		{"import", "-rewrite", "Document"},
+		{"diff", "Document"},
+		{"diff", "-dest-database", "(default)"},
+		{"diff", "-dest-database", "staging", "-batch-size", "0"},
+		{"diff", "-dest-database", "staging", "-protect", "Master/plan1"},
+		{"migrations", "extra"},
+	} {
+		var stderr bytes.Buffer
+		err := run(context.Background(), args, strings.NewReader(""), &bytes.Buffer{}, &stderr)
+		assert.ErrorIs(t, err, errUsage, "%v", args)
+		assert.Contains(t, stderr.String(), "Usage", "%v", args)
+	}
+}
+
+func TestRunDiffProtected(t *testing.T) {
+	for _, path := range []string{"Master", "/Master/plan1/", "User/alice/Master"} {
+		var stderr bytes.Buffer
+		err := run(
+			context.Background(),
+			[]string{"diff", "-dest-database", "staging", "-protect", "Master", "-apply", path},
+			strings.NewReader(""),
+			&bytes.Buffer{},
+			&stderr,
+		)
+		assert.ErrorContains(t, err, "cannot write to protected collection: Master", path)
+	}
+}
+
+func TestWriteDiffs(t *testing.T) {
+	diffs := []*dump.DocumentDiff{
+		{Path: "Document/doc1", Kind: dump.ChangeAdded},
+		{
+			Path: "Document/doc2",
+			Kind: dump.ChangeChanged,
+			Fields: []dump.FieldDiff{
+				{Field: "Name", Source: dump.Value{"string": "Bob"}, Destination: dump.Value{"string": "Robert"}},
+				{Field: "Note", Destination: dump.Value{"null": nil}},
+			},
+		},
+		{Path: "Document/doc3", Kind: dump.ChangeRemoved},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writeDiffs(&buf, diffs, false))
+	assert.Equal(
+		t,
+		"+ Document/doc1\n"+
+			"~ Document/doc2\n"+
+			"\tName: {\"string\":\"Robert\"} -> {\"string\":\"Bob\"}\n"+
+			"\tNote: {\"null\":null} -> (missing)\n"+
+			"- Document/doc3\n",
+		buf.String(),
+	)
+
+	buf.Reset()
+	require.NoError(t, writeDiffs(&buf, diffs[:1], true))
+	assert.JSONEq(t, `{"path":"Document/doc1","kind":"added"}`, buf.String())
+}
+
+func TestWriteMigrationStates(t *testing.T) {
+	states := []*simplestore.MigrationState{
+		{
+			ID:        "001-rename",
+			Status:    simplestore.MigrationApplied,
+			Processed: 10,
+			Changed:   8,
+			UpdatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{ID: "002-split", Status: simplestore.MigrationRunning, LastError: "test error"},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writeMigrationStates(&buf, states, false))
+	assert.Equal(
+		t,
+		"ID          STATUS   PROCESSED  CHANGED  UPDATED               LAST ERROR\n"+
+			"001-rename  applied  10         8        2024-01-02T03:04:05Z  \n"+
+			"002-split   running  0          0                              test error\n",
+		buf.String(),
+	)
+}
+
+func (s *CommandTestSuite) run(stdin string, args ...string) (string, error) {
+	args = append([]string{"-database", s.FirestoreDatabaseID}, args...)
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	s.T().Log(stderr.String())
+	return stdout.String(), err
+}
+
+func (s *CommandTestSuite) TestExportImport() {
+	ctx := context.Background()
+	client := s.SimplestoreClient
+	_, err := client.Set(ctx, &Document{ID: "doc1", Name: "Alice"})
+	s.Require().NoError(err)
+
+	exported, err := s.run("", "export", "Document")
+	s.Require().NoError(err)
+	s.Contains(exported, `"path":"Document/doc1"`)
+
+	// dry run
+	_, err = s.run(exported, "import", "-dry-run", "-rewrite", "Document/=Copy/")
+	s.Require().NoError(err)
+	var copies []*Document
+	client.AddTableMaps(map[string]string{"Document": "Copy"})
+	defer client.AddTableMaps(map[string]string{"Document": "Document"})
+	s.Require().NoError(client.Query(&copies).GetAll(ctx))
+	s.Empty(copies)
+
+	// from a file
+	file := filepath.Join(s.T().TempDir(), "export.jsonl")
+	s.Require().NoError(os.WriteFile(file, []byte(exported), 0o600))
+	_, err = s.run("", "import", "-rewrite", "Document/=Copy/", file)
+	s.Require().NoError(err)
+	s.Require().NoError(client.Query(&copies).GetAll(ctx))
+	s.Equal([]*Document{{ID: "doc1", Name: "Alice"}}, copies)
+
+	// to a file
+	file = filepath.Join(s.T().TempDir(), "copy.jsonl")
+	_, err = s.run("", "export", "-o", file, "Copy/doc1")
+	s.Require().NoError(err)
+	content, err := os.ReadFile(file)
+	s.Require().NoError(err)
+	s.Contains(string(content), `"path":"Copy/doc1"`)
+}
+
+func (s *CommandTestSuite) TestDiff() {
+	ctx := context.Background()
+	dstDatabaseID := s.FirestoreDatabaseID + "-dst"
+	dst, err := simplestore.NewClientWithProjectIDAndDatabase(ctx, s.SimplestoreClient.ProjectID, dstDatabaseID)
+	s.Require().NoError(err)
+	defer func() {
+		simplestoretest.ClearFirestore(s.T(), dst)
+		dst.Close()
+	}()
+	_, err = s.SimplestoreClient.Set(ctx, &Document{ID: "doc1", Name: "Alice"})
+	s.Require().NoError(err)
+	_, err = dst.Set(ctx, &Document{ID: "doc2", Name: "Bob"})
+	s.Require().NoError(err)
+
+	output, err := s.run("", "diff", "-dest-database", dstDatabaseID, "Document")
+	s.Require().NoError(err)
+	s.Equal("+ Document/doc1\n- Document/doc2\n", output)
+
+	_, err = s.run("", "diff", "-dest-database", dstDatabaseID, "-apply", "-skip-removed")
+	s.Require().NoError(err)
+	var documents []*Document
+	s.Require().NoError(dst.Query(&documents).OrderBy("ID", firestore.Asc).GetAll(ctx))
+	s.Equal([]*Document{{ID: "doc1", Name: "Alice"}, {ID: "doc2", Name: "Bob"}}, documents)
+
+	output, err = s.run("", "diff", "-dest-database", dstDatabaseID, "-json")
+	s.Require().NoError(err)
+	s.JSONEq(`{"path":"Document/doc2","kind":"removed"}`, output)
+
+	// protected collections aren't written
+	_, err = s.run("", "diff", "-dest-database", dstDatabaseID, "-protect", "Document", "-apply")
+	var perr *simplestore.ProgrammingError
+	s.ErrorAs(err, &perr)
+	s.Require().NoError(dst.Query(&documents).OrderBy("ID", firestore.Asc).GetAll(ctx))
+	s.Equal([]*Document{{ID: "doc1", Name: "Alice"}, {ID: "doc2", Name: "Bob"}}, documents)
+}
@@ -0,0 +1,136 @@
+package simplestore
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// DefaultCounterShards is the default number of shards of counters
+	DefaultCounterShards = 10
+	// CounterCollectionPrefix is the prefix of collections for shards of counters
+	// Shards are stored as `<parent>/_counter.<name>/<index>`.
+	CounterCollectionPrefix = "_counter."
+	// counterField is the field of shards to store counts
+	counterField = "count"
+)
+
+type counterConfig struct {
+	shards int
+}
+
+// CounterOption configures a counter
+type CounterOption func(*counterConfig)
+
+// WithCounterShards specifies the number of shards
+// Defaults to DefaultCounterShards.
+// The number can be changed without losing counts as `Value` sums up all existing shards.
+func WithCounterShards(n int) CounterOption {
+	return func(c *counterConfig) {
+		c.shards = n
+	}
+}
+
+// Counter is a sharded counter under a document
+// Increments are distributed to shards to avoid the write rate limit for a document.
+type Counter struct {
+	counterConfig
+	client     *Client
+	collection *firestore.CollectionRef
+}
+
+// CounterSafe returns a counter named name under the document specified by `parent`
+// parent must be a pointer to a struct.
+// Counters created from a client in a transaction are incremented and read in the transaction.
+func (c *Client) CounterSafe(parent any, name string, opts ...CounterOption) (*Counter, error) {
+	if name == "" {
+		return nil, NewProgrammingError("counter name is empty")
+	}
+	doc, err := c.GetDocumentRefSafe(parent)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, NewProgrammingError("parent is nil")
+	}
+	counter := &Counter{
+		counterConfig: counterConfig{
+			shards: DefaultCounterShards,
+		},
+		client:     c,
+		collection: doc.Collection(CounterCollectionPrefix + name),
+	}
+	for _, opt := range opts {
+		opt(&counter.counterConfig)
+	}
+	if counter.shards < 1 {
+		return nil, NewProgrammingErrorf("counter must have at least one shard: %d", counter.shards)
+	}
+	return counter, nil
+}
+
+// Counter returns a counter named name under the document specified by `parent`
+// parent must be a pointer to a struct.
+// Panic if inappropriate parent is specified.
+func (c *Client) Counter(parent any, name string, opts ...CounterOption) *Counter {
+	counter, err := c.CounterSafe(parent, name, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return counter
+}
+
+// Increment adds delta to the counter
+// delta can be negative.
+func (ct *Counter) Increment(ctx context.Context, delta int64) error {
+	if err := ct.client.checkWritable(); err != nil {
+		return err
+	}
+	shard := ct.collection.Doc(strconv.Itoa(rand.Intn(ct.shards)))
+	data := map[string]any{
+		counterField: firestore.Increment(delta),
+	}
+	if tx := ct.client.FirestoreTransaction; tx != nil {
+		return tx.Set(shard, data, firestore.MergeAll)
+	}
+	_, err := shard.Set(ctx, data, firestore.MergeAll)
+	return err
+}
+
+// Value returns the sum of all shards
+func (ct *Counter) Value(ctx context.Context) (int64, error) {
+	var iter *firestore.DocumentIterator
+	if ct.client.FirestoreTransaction != nil {
+		iter = ct.client.FirestoreTransaction.Documents(ct.collection)
+	} else {
+		iter = ct.collection.Documents(ctx)
+	}
+	defer iter.Stop()
+	var total int64
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		count, err := docsnap.DataAt(counterField)
+		if err != nil {
+			continue
+		}
+		if n, ok := count.(int64); ok {
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// Shards returns the number of shards to increment
+func (ct *Counter) Shards() int {
+	return ct.shards
+}
@@ -0,0 +1,97 @@
+package simplestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterSafe(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	counter, err := client.CounterSafe(&MyDocument{ID: "docid"}, "likes")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCounterShards, counter.Shards())
+	assert.Equal(t, "MyDocument/docid/_counter.likes", relativePath(counter.collection.Path))
+
+	counter, err = client.CounterSafe(&MyDocument{ID: "docid"}, "likes", WithCounterShards(3))
+	require.NoError(t, err)
+	assert.Equal(t, 3, counter.Shards())
+
+	_, err = client.CounterSafe(&MyDocument{ID: "docid"}, "likes", WithCounterShards(0))
+	assertProgrammingError(t, err)
+	_, err = client.CounterSafe(&MyDocument{ID: "docid"}, "")
+	assertProgrammingError(t, err)
+	_, err = client.CounterSafe(&MyDocument{}, "likes")
+	assertProgrammingError(t, err)
+	_, err = client.CounterSafe((*MyDocument)(nil), "likes")
+	assertProgrammingError(t, err)
+}
+
+func TestCounter(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	doc := &MyDocument{ID: "docid"}
+	// shards are cleared with the parent
+	_, err = client.Set(ctx, doc)
+	require.NoError(t, err)
+
+	counter := client.Counter(doc, "likes", WithCounterShards(5))
+	value, err := counter.Value(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), value)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, counter.Increment(ctx, 1))
+	}
+	require.NoError(t, counter.Increment(ctx, -3))
+	value, err = counter.Value(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), value)
+
+	// counts in removed shards are kept
+	counter = client.Counter(doc, "likes", WithCounterShards(1))
+	require.NoError(t, counter.Increment(ctx, 1))
+	value, err = counter.Value(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), value)
+
+	// counters are independent
+	value, err = client.Counter(doc, "views").Value(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), value)
+}
+
+func TestCounterInTransaction(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	doc := &MyDocument{ID: "docid"}
+
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		counter := tc.Counter(doc, "likes")
+		value, err := counter.Value(ctx)
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, int64(0), value)
+		if err := counter.Increment(ctx, 2); err != nil {
+			return err
+		}
+		_, err = tc.Set(ctx, doc)
+		return err
+	})
+	require.NoError(t, err)
+
+	value, err := client.Counter(doc, "likes").Value(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), value)
+}
@@ -3,35 +3,87 @@ package simplestore
 import (
 	"context"
 	"reflect"
+	"strings"
 
 	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Get retrieves a document from firestore
 // o must be a pointer to a struct.
 // Fill o with the found document.
+// Documents with old schema versions are upgraded for types configured with `EnableSchemaUpgrade`.
 func (c *Client) Get(ctx context.Context, o any) error {
-	doc, err := c.GetDocumentRefSafe(o)
+	accessor, err := newAccessor(reflect.TypeOf(o), c.tableMaps)
 	if err != nil {
 		return err
 	}
-	var docsnap *firestore.DocumentSnapshot
-	if c.FirestoreTransaction == nil {
-		docsnap, err = doc.Get(ctx)
-	} else {
-		docsnap, err = c.FirestoreTransaction.Get(doc)
-	}
+	doc, _, err := accessor.getDocumentRef(c, reflect.ValueOf(o), false)
 	if err != nil {
 		return err
 	}
-	return docsnap.DataTo(o)
+	op := &Operation{
+		Kind:       OperationGet,
+		Collection: accessor.collectionName,
+		ReadOnly:   accessor.readOnly,
+		Target:     o,
+	}
+	if doc != nil {
+		op.Path = relativePath(doc.Path)
+	}
+	_, err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (any, error) {
+		docsnap, err := c.getSnapshot(ctx, op, doc)
+		if status.Code(err) == codes.NotFound {
+			op.MissingCount = 1
+		}
+		if err != nil {
+			return nil, err
+		}
+		op.ResultCount = 1
+		return nil, c.dataTo(ctx, docsnap, o)
+	})
+	return err
 }
 
 // GetAll retrieves multiple documents from firestore
 // os must be a slice of a pointer to a struct.
 // Fill os with found documents.
 // Returns slice of found objects.
+// Missing documents and nil entries are silently skipped. Use `GetAllDetailed` to know them.
 func (c *Client) GetAll(ctx context.Context, os any) (any, error) {
+	result, err := c.getAll(ctx, os)
+	if result == nil {
+		return nil, err
+	}
+	return result.Found, err
+}
+
+// GetAllDetailed retrieves multiple documents from firestore and reports missing documents
+// os must be a slice of a pointer to a struct.
+// Fill os with found documents.
+// With `RequireAll()`, returns a `*NotFoundError` (`errors.Is(err, ErrNotFound)`) if any documents are missing.
+func (c *Client) GetAllDetailed(ctx context.Context, os any, opts ...GetAllOption) (*GetAllResult, error) {
+	var cfg getAllConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	result, err := c.getAll(ctx, os)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = &GetAllResult{
+			Found: reflect.MakeSlice(reflect.TypeOf(os), 0, 0).Interface(),
+		}
+	}
+	if cfg.requireAll && len(result.Missing) > 0 {
+		return result, &NotFoundError{Refs: result.Missing}
+	}
+	return result, nil
+}
+
+func (c *Client) getAll(ctx context.Context, os any) (*GetAllResult, error) {
 	docList, err := c.GetDocumentRefListSafe(os)
 	if err != nil {
 		return nil, err
@@ -40,129 +92,360 @@ func (c *Client) GetAll(ctx context.Context, os any) (any, error) {
 		return nil, nil
 	}
 	validList := make([]*firestore.DocumentRef, 0, len(docList))
+	validIndexes := make([]int, 0, len(docList))
 	// make dstList as same type of os
 	osRef := reflect.ValueOf(os)
 	dstList := reflect.MakeSlice(osRef.Type(), 0, len(docList))
+	op := &Operation{
+		Kind:   OperationGetAll,
+		Paths:  make([]string, 0, len(docList)),
+		Target: os,
+	}
+	result := &GetAllResult{}
 	for idx, doc := range docList {
 		if doc == nil {
+			result.Skipped = append(result.Skipped, idx)
 			continue
 		}
 		validList = append(validList, doc)
+		validIndexes = append(validIndexes, idx)
 		dstList = reflect.Append(dstList, osRef.Index(idx))
-	}
-	var docsnapList []*firestore.DocumentSnapshot
-	if c.FirestoreTransaction == nil {
-		docsnapList, err = c.FirestoreClient.GetAll(ctx, validList)
-	} else {
-		docsnapList, err = c.FirestoreTransaction.GetAll(validList)
-	}
-	if err != nil {
-		return nil, err
-	}
-	// make retList as same type of os
-	retList := reflect.MakeSlice(osRef.Type(), 0, dstList.Len())
-	for idx, docsnap := range docsnapList {
-		if !docsnap.Exists() {
-			continue
+		op.Paths = append(op.Paths, relativePath(doc.Path))
+		if op.Collection == "" {
+			op.Collection = doc.Parent.ID
 		}
-		elem := dstList.Index(idx)
-		err := docsnap.DataTo(elem.Interface())
+	}
+	found, err := c.invoke(ctx, op, func(ctx context.Context, op *Operation) (any, error) {
+		docsnapList, err := c.getSnapshots(ctx, op, validList)
 		if err != nil {
 			return nil, err
 		}
-		// Append the populated element to dstList
-		retList = reflect.Append(retList, elem)
+		// make retList as same type of os
+		retList := reflect.MakeSlice(osRef.Type(), 0, dstList.Len())
+		for idx, docsnap := range docsnapList {
+			if !docsnap.Exists() {
+				op.MissingCount++
+				result.Missing = append(result.Missing, validList[idx])
+				result.MissingIndexes = append(result.MissingIndexes, validIndexes[idx])
+				continue
+			}
+			elem := dstList.Index(idx)
+			err := c.dataTo(ctx, docsnap, elem.Interface())
+			if err != nil {
+				return nil, err
+			}
+			// Append the populated element to dstList
+			retList = reflect.Append(retList, elem)
+			op.ResultCount++
+		}
+		return retList.Interface(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reflect.TypeOf(found) != osRef.Type() {
+		// middlewares may short-circuit without results
+		found = reflect.MakeSlice(osRef.Type(), 0, 0).Interface()
 	}
-	return retList.Interface(), nil
+	result.Found = found
+	return result, nil
 }
 
-// Create creates a new document in firestore
-// o must be a pointer to a struct.
-// Generates and sets ID if not set.
-// WriteResult will be alwasys `nil` while transaction.
-func (c *Client) Create(ctx context.Context, o any) (*firestore.WriteResult, error) {
-	accessor, err := newAccessor(reflect.TypeOf(o), c.tableMaps)
+// getSnapshot retrieves the snapshot of a document
+// Returns NotFound error with the snapshot if the document doesn't exist.
+func (c *Client) getSnapshot(ctx context.Context, op *Operation, doc *firestore.DocumentRef) (*firestore.DocumentSnapshot, error) {
+	docsnapList, err := c.getSnapshots(ctx, op, []*firestore.DocumentRef{doc})
 	if err != nil {
 		return nil, err
 	}
-	if accessor.readOnly {
-		return nil, NewProgrammingErrorf("cannot create document in readonly collection: %s", accessor.collectionName)
+	docsnap := docsnapList[0]
+	if !docsnap.Exists() {
+		return docsnap, status.Errorf(codes.NotFound, "%q not found", doc.Path)
 	}
+	return docsnap, nil
+}
 
-	doc, resetID, err := c.prepareSetDocument(o)
-	if err != nil {
-		return nil, err
+// snapshotFetcher retrieves snapshots of documents in the same order of docs
+type snapshotFetcher func(ctx context.Context, docs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error)
+
+// getSnapshots retrieves snapshots of documents in the same order of docs
+// Snapshots are returned also for missing documents.
+// Documents are served from the session and the cache if available, and reads are batched if enabled.
+func (c *Client) getSnapshots(ctx context.Context, op *Operation, docs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error) {
+	if c.FirestoreTransaction != nil {
+		return c.FirestoreTransaction.GetAll(docs)
 	}
-	var result *firestore.WriteResult
-	if c.FirestoreTransaction == nil {
-		result, err = doc.Create(ctx, o)
-		if err != nil {
-			resetID()
-			return result, err
+	if !c.readTime.IsZero() {
+		// the session and batches hold the latest documents
+		return c.FirestoreClient.GetAll(ctx, docs)
+	}
+	var fetch snapshotFetcher = func(ctx context.Context, docs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error) {
+		return c.FirestoreClient.GetAll(ctx, docs)
+	}
+	b := batcherFrom(ctx)
+	if b == nil {
+		b = c.batcher
+	}
+	if b != nil {
+		getAll := fetch
+		fetch = func(ctx context.Context, docs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error) {
+			return b.getAll(ctx, c.FirestoreClient, docs, getAll)
+		}
+	}
+	if c.cache != nil {
+		getAll := fetch
+		fetch = func(ctx context.Context, docs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error) {
+			return c.getAllCached(ctx, op, docs, getAll)
+		}
+	}
+	if sess := SessionFrom(ctx); sess != nil {
+		return sess.getAll(ctx, op, docs, fetch)
+	}
+	return fetch(ctx, docs)
+}
+
+// getSnapshotsThrough serves documents found with lookup, and fetches others
+// Fetched snapshots are passed to store.
+// Returns the number of documents served with lookup.
+func getSnapshotsThrough(
+	ctx context.Context,
+	docs []*firestore.DocumentRef,
+	lookup func(doc *firestore.DocumentRef) (*firestore.DocumentSnapshot, bool),
+	fetch snapshotFetcher,
+	store func(docsnap *firestore.DocumentSnapshot),
+) ([]*firestore.DocumentSnapshot, int, error) {
+	docsnapList := make([]*firestore.DocumentSnapshot, len(docs))
+	var fetchList []*firestore.DocumentRef
+	var fetchIndexes []int
+	for idx, doc := range docs {
+		if doc != nil {
+			if docsnap, ok := lookup(doc); ok {
+				docsnapList[idx] = docsnap
+				continue
+			}
 		}
-	} else {
-		err = c.FirestoreTransaction.Create(doc, o)
+		fetchList = append(fetchList, doc)
+		fetchIndexes = append(fetchIndexes, idx)
+	}
+	served := len(docs) - len(fetchList)
+	if len(fetchList) > 0 {
+		fetched, err := fetch(ctx, fetchList)
 		if err != nil {
-			return result, err
+			return nil, served, err
+		}
+		for i, docsnap := range fetched {
+			docsnapList[fetchIndexes[i]] = docsnap
+			store(docsnap)
 		}
-		c.transactionFailureCallbacks = append(c.transactionFailureCallbacks, resetID)
 	}
-	return result, nil
+	return docsnapList, served, nil
+}
+
+// Create creates a new document in firestore
+// o must be a pointer to a struct.
+// Generates and sets ID if not set.
+// WriteResult will be alwasys `nil` while transaction.
+// Values of fields tagged with `simplestore:"unique"` are reserved in a transaction (WriteResult is `nil`),
+// and `*UniqueViolationError` (`errors.Is(err, ErrUniqueViolation)`) is returned if used by another document.
+// Writes to audited collections are recorded with AuditEntry in a transaction (WriteResult is `nil`).
+// SchemaVersion is set to the current version for types configured with `EnableSchemaUpgrade`.
+func (c *Client) Create(ctx context.Context, o any) (*firestore.WriteResult, error) {
+	return c.write(ctx, OperationCreate, o, func(ctx context.Context, c *Client, doc *firestore.DocumentRef, o any) (*firestore.WriteResult, error) {
+		if c.FirestoreTransaction == nil {
+			return doc.Create(ctx, o)
+		}
+		return nil, c.FirestoreTransaction.Create(doc, o)
+	})
 }
 
 // Set updates a document if exists nor create a new document
 // o must be a pointer to a struct.
 // Generates and sets ID if not set.
 // WriteResult will be alwasys `nil` while transaction.
+// Values of fields tagged with `simplestore:"unique"` are reserved and old values are released in a transaction (WriteResult is `nil`).
+// Writes to audited collections are recorded with AuditEntry in a transaction (WriteResult is `nil`).
+// The previous version is kept in a transaction for types configured with `EnableHistory` (WriteResult is `nil`).
+// SchemaVersion is set to the current version for types configured with `EnableSchemaUpgrade`.
+// opts are not allowed for documents with unique fields or in audited collections.
 func (c *Client) Set(ctx context.Context, o any, opts ...firestore.SetOption) (*firestore.WriteResult, error) {
-	accessor, err := newAccessor(reflect.TypeOf(o), c.tableMaps)
-	if err != nil {
-		return nil, err
-	}
-	if accessor.readOnly {
-		return nil, NewProgrammingErrorf("cannot set document in readonly collection: %s", accessor.collectionName)
-	}
-
-	doc, resetID, err := c.prepareSetDocument(o)
-	if err != nil {
-		return nil, err
-	}
-	var result *firestore.WriteResult
-	if c.FirestoreTransaction == nil {
-		result, err = doc.Set(ctx, o, opts...)
-		if err != nil {
-			resetID()
-			return result, err
+	if len(opts) > 0 {
+		if accessor, err := newAccessor(reflect.TypeOf(o), c.tableMaps); err == nil {
+			if len(accessor.uniqueFields) > 0 {
+				return nil, NewProgrammingError("cannot set document with unique fields with options")
+			}
+			if c.isAudited(accessor) {
+				return nil, NewProgrammingError("cannot set audited document with options")
+			}
 		}
-	} else {
-		err = c.FirestoreTransaction.Set(doc, o)
-		if err != nil {
-			return result, err
-		}
-		c.transactionFailureCallbacks = append(c.transactionFailureCallbacks, resetID)
 	}
-	return result, nil
+	return c.write(ctx, OperationSet, o, func(ctx context.Context, c *Client, doc *firestore.DocumentRef, o any) (*firestore.WriteResult, error) {
+		if c.FirestoreTransaction == nil {
+			return doc.Set(ctx, o, opts...)
+		}
+		return nil, c.FirestoreTransaction.Set(doc, o, opts...)
+	})
 }
 
 // Delete deletes a document
 // o must be a pointer to a struct.
 // WriteResult will be alwasys `nil` while transaction.
+// Values of fields tagged with `simplestore:"unique"` are released in a transaction (WriteResult is `nil`).
+// Writes to audited collections are recorded with AuditEntry in a transaction (WriteResult is `nil`).
+// The previous version is kept in a transaction for types configured with `EnableHistory` (WriteResult is `nil`).
 func (c *Client) Delete(ctx context.Context, o any, opts ...firestore.Precondition) (*firestore.WriteResult, error) {
+	return c.write(ctx, OperationDelete, o, func(ctx context.Context, c *Client, doc *firestore.DocumentRef, o any) (*firestore.WriteResult, error) {
+		if c.FirestoreTransaction == nil {
+			return doc.Delete(ctx, opts...)
+		}
+		return nil, c.FirestoreTransaction.Delete(doc, opts...)
+	})
+}
+
+// write runs a write operation through middlewares
+// For Create and Set, generates ID for a new document and resets it when the write fails.
+// In a transaction, f for documents with unique fields, audit or history is staged with a copy of o
+// and called when the transaction function returns.
+func (c *Client) write(
+	ctx context.Context,
+	kind OperationKind,
+	o any,
+	f func(ctx context.Context, c *Client, doc *firestore.DocumentRef, o any) (*firestore.WriteResult, error),
+) (*firestore.WriteResult, error) {
 	accessor, err := newAccessor(reflect.TypeOf(o), c.tableMaps)
 	if err != nil {
 		return nil, err
 	}
-	if accessor.readOnly {
-		return nil, NewProgrammingErrorf("cannot delete document in readonly collection: %s", accessor.collectionName)
+	audited := c.isAudited(accessor)
+	history := c.historyConfig(accessor)
+	tracked := len(accessor.uniqueFields) > 0 || audited || history != nil
+	if tracked && c.FirestoreTransaction == nil && !accessor.readOnly && c.readTime.IsZero() {
+		// unique indexes, audit entries and versions are written in a transaction
+		return nil, c.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+			_, err := tc.write(ctx, kind, o, f)
+			return err
+		})
 	}
-
-	doc, err := c.GetDocumentRefSafe(o)
-	if err != nil {
-		return nil, err
+	pv := reflect.ValueOf(o)
+	mightNew := kind != OperationDelete
+	// errors are reported after the readonly check
+	doc, isNew, docErr := accessor.getDocumentRef(c, pv, mightNew)
+	op := &Operation{
+		Kind:       kind,
+		Collection: accessor.collectionName,
+		ReadOnly:   accessor.readOnly,
+		Target:     o,
 	}
-	if c.FirestoreTransaction == nil {
-		return doc.Delete(ctx, opts...)
-	} else {
-		return nil, c.FirestoreTransaction.Delete(doc, opts...)
+	if doc != nil {
+		op.Path = relativePath(doc.Path)
 	}
+	result, err := c.invoke(ctx, op, func(ctx context.Context, op *Operation) (any, error) {
+		if accessor.readOnly {
+			return nil, NewProgrammingErrorf("cannot %s document in readonly collection: %s", strings.ToLower(string(kind)), accessor.collectionName)
+		}
+		if err := c.checkWritable(); err != nil {
+			return nil, err
+		}
+		if docErr != nil {
+			return nil, docErr
+		}
+		if doc == nil && mightNew {
+			return nil, NewProgrammingError("object is nil")
+		}
+		if tracked && c.txWrites != nil && doc != nil {
+			// reads for the second write can't see the first write
+			if c.txWrites.written[doc.Path] {
+				return nil, NewProgrammingErrorf("cannot write document twice in a transaction: %s", relativePath(doc.Path))
+			}
+			if c.txWrites.written == nil {
+				c.txWrites.written = map[string]bool{}
+			}
+			c.txWrites.written[doc.Path] = true
+		}
+		resetID := nop
+		if isNew {
+			accessor.setID(pv, doc.ID)
+			resetID = func() {
+				accessor.setID(pv, "")
+			}
+		}
+		if mightNew {
+			// structs are always the current schema
+			c.setSchemaVersion(pv)
+		}
+		var entry *AuditEntry
+		if audited && doc != nil {
+			// read the current document before other writes
+			var err error
+			if entry, err = c.newAuditEntry(ctx, kind, accessor, doc, pv); err != nil {
+				resetID()
+				return nil, err
+			}
+		}
+		var writeVersion func() error
+		if history != nil && doc != nil && kind != OperationCreate {
+			var err error
+			if writeVersion, err = c.prepareHistory(kind, history, doc); err != nil {
+				resetID()
+				return nil, err
+			}
+		}
+		if len(accessor.uniqueFields) > 0 && doc != nil {
+			if err := c.updateUniqueIndexes(kind, accessor, doc, pv); err != nil {
+				resetID()
+				return nil, err
+			}
+		}
+		var result *firestore.WriteResult
+		var err error
+		staging := tracked && c.txWrites != nil
+		if staging {
+			// later changes of o must not affect the write
+			staged := o
+			if mightNew {
+				staged = deepCopy(pv).Interface()
+			}
+			c.txWrites.stage(func() error {
+				_, err := f(ctx, c, doc, staged)
+				return err
+			})
+		} else {
+			result, err = f(ctx, c, doc, o)
+		}
+		if sess := SessionFrom(ctx); sess != nil {
+			sess.invalidate(doc)
+		}
+		if c.cache != nil {
+			c.cache.invalidate(doc)
+		}
+		if err != nil {
+			resetID()
+			return result, err
+		}
+		if writeVersion != nil && staging {
+			c.txWrites.stage(writeVersion)
+		} else if writeVersion != nil {
+			if err := writeVersion(); err != nil {
+				resetID()
+				return nil, err
+			}
+		}
+		if entry != nil && staging {
+			c.txWrites.stage(func() error {
+				_, err := c.Create(ctx, entry)
+				return err
+			})
+		} else if entry != nil {
+			if _, err := c.Create(ctx, entry); err != nil {
+				resetID()
+				return nil, err
+			}
+		}
+		if c.FirestoreTransaction != nil {
+			c.transactionFailureCallbacks = append(c.transactionFailureCallbacks, resetID)
+		}
+		return result, nil
+	})
+	// middlewares may short-circuit writes without results
+	wr, _ := result.(*firestore.WriteResult)
+	return wr, err
 }
@@ -6,6 +6,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestGetDocument(t *testing.T) {
@@ -129,3 +131,51 @@ func TestReadWithParent(t *testing.T) {
 	assert.Equal(t, "parent1", retrievedChild.Parent.ID)
 	assert.Equal(t, "ParentName", retrievedChild.Parent.Name)
 }
+
+func TestGetAllDetailed(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	doc1 := &MyDocument{
+		ID:   "docid1",
+		Name: "Name1",
+	}
+	_, err = client.Set(ctx, doc1)
+	require.NoError(t, err)
+
+	result, err := client.GetAllDetailed(ctx, []*MyDocument{
+		{ID: "docid2"}, // does not exist
+		nil,
+		{ID: "docid1"}, // exists
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []*MyDocument{doc1}, result.Found)
+	require.Len(t, result.Missing, 1)
+	assert.Equal(t, "docid2", result.Missing[0].ID)
+	assert.Equal(t, []int{0}, result.MissingIndexes)
+	assert.Equal(t, []int{1}, result.Skipped)
+}
+
+func TestGetAllDetailedRequireAll(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	_, err = client.Set(ctx, &MyDocument{ID: "docid1"})
+	require.NoError(t, err)
+
+	_, err = client.GetAllDetailed(ctx, []*MyDocument{{ID: "docid1"}}, RequireAll())
+	require.NoError(t, err)
+
+	result, err := client.GetAllDetailed(ctx, []*MyDocument{{ID: "docid1"}, {ID: "docid2"}, {ID: "docid3"}}, RequireAll())
+	require.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	var notFound *NotFoundError
+	require.ErrorAs(t, err, &notFound)
+	assert.Len(t, notFound.Refs, 2)
+	assert.Contains(t, err.Error(), "docid2, docid3")
+	assert.Len(t, result.Found, 1)
+}
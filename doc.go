@@ -119,6 +119,36 @@ For multiple documents
 		// TODO: Handle error.
 	}
 
+To know missing documents:
+
+	result, err := client.GetAllDetailed(ctx, []*MyDocument{doc1, nil, doc2})
+	if err != nil {
+		// TODO: Handle error.
+	}
+	found := result.Found.([]*MyDocument)	// found documents in the input order
+	fmt.Println(result.Missing)	// references of missing documents
+	fmt.Println(result.Skipped)	// indexes of nil entries: [1]
+
+Missing documents can be an error with `RequireAll()`:
+
+	_, err := client.GetAllDetailed(ctx, []*MyDocument{doc1, doc2}, simplestore.RequireAll())
+	if errors.Is(err, simplestore.ErrNotFound) {
+		// err is *simplestore.NotFoundError listing missing documents.
+	}
+
+To retrieve a document from a path, register types in advance:
+
+	err := client.Register(&MyDocument{}, &ChildDocument{})
+	o, err := client.GetByPath(ctx, "ParentDocument/parentid/ChildDocument/childid")
+	child := o.(*ChildDocument)	// child.Parent is also set
+
+Paths of documents can be stored and restored:
+
+	path, err := client.PathOf(child)	// "ParentDocument/parentid/ChildDocument/childid"
+	ref := client.FirestoreClient.Doc(path)
+	child := &ChildDocument{}
+	err := client.FromRef(ref, child)	// fills ID and Parent
+
 # Writing
 
 `Create` creates a new document, and returns an error if the document already exists:
@@ -216,6 +246,330 @@ You can call methods just like outside of transaction.
 		// TODO: Handle error.
 	}
 
+Firestore doesn't allow reads after writes in a transaction.
+Writes of documents with unique fields, audit or history read index documents and current documents,
+so they are staged and sent when the function returns, after other writes.
+You can read after them, but the reads don't see them, and their errors are returned from `RunTransaction`.
+Other writes are sent immediately: reads after them fail.
+
+`RunReadOnlyTransaction` (or `RunTransaction` with `firestore.ReadOnly`) reads documents consistently,
+and writes in it fail with ProgrammingError:
+
+	err := client.RunReadOnlyTransaction(ctx, func(ctx context.Context, client *simplestore.Client) error {
+		// consistent reads
+	})
+
+# Reading at a time
+
+`AtReadTime` returns a read-only client reading documents as of the time with point-in-time recovery (PITR):
+
+	past, err := client.AtReadTime(time.Now().Add(-30*time.Minute))
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer past.Close()
+	err = past.Get(ctx, doc)
+
+`Get`, `GetAll`, queries and `Count` read at the time, and writes fail with ProgrammingError.
+The returned client has its own connection to firestore, as firestore applies read times per client: close it with `Close`.
+`Count` lists names of matching documents as aggregations don't support read times: it's billed one read per document.
+`RunTransaction` runs the function without a transaction, as reads at a time are already consistent.
+
+# References
+
+References are stored in `*firestore.DocumentRef` fields as firestore references.
+`Preload` loads referenced documents for multiple documents at once
+into fields tagged with `simplestore:"ref=FIELD"` (also tagged with `firestore:"-"` not to be stored):
+
+	type Book struct {
+		ID          string
+		Author      *firestore.DocumentRef
+		AuthorValue *Author `firestore:"-" simplestore:"ref=Author"`
+	}
+
+	// a `GetAll` for each type of referenced documents
+	err := client.Preload(ctx, books, "Author")
+	fmt.Println(books[0].AuthorValue.Name)
+
+Ref is a typed wrapper of a reference:
+
+	ref, err := simplestore.RefTo(client, &Author{ID: "authorid"})
+	book := &Book{ID: "bookid", Author: ref.Ref}
+
+	author, err := simplestore.NewRef[Author](book.Author).Get(ctx, client)
+
+# Children
+
+Slice fields tagged with `simplestore:"children"` can be filled with documents in the subcollection.
+They must be also tagged with `firestore:"-"` not to be stored:
+
+	type Order struct {
+		ID    string
+		Items []*OrderItem `firestore:"-" simplestore:"children"`
+	}
+
+	type OrderItem struct {
+		Parent *Order
+		ID     string
+		Price  int
+	}
+
+	// Parent of items is set to order
+	err := client.Load(ctx, order, "Items", simplestore.ChildOrderBy("Price", firestore.Desc))
+
+	// children are queried for each order concurrently
+	var orders []*Order
+	err := client.Query(&orders).With("Items", simplestore.ChildLimit(10)).GetAll(ctx)
+
+# Unique fields
+
+Fields tagged with `simplestore:"unique"` can't have the same value in the collection:
+
+	type User struct {
+		ID    string
+		Email string `firestore:"email" simplestore:"unique"`
+	}
+
+	_, err := client.Create(ctx, user)
+	if errors.Is(err, simplestore.ErrUniqueViolation) {
+		// err is *simplestore.UniqueViolationError
+	}
+
+Values are reserved with index documents `_unique/<Collection>.<field>/values/<value>`.
+`Create`, `Set` and `Delete` maintain index documents in a transaction, and `Set` releases the old value.
+Notes:
+
+* Zero values are not reserved.
+* Reservations are shared among collections with the same name under different parents.
+* Values reserved and released by other documents in the same transaction are taken into account.
+* A document with unique fields can't be written twice in a transaction.
+* `Set` with options is not allowed.
+
+# Counters
+
+Counter distributes increments to shard documents under a document to avoid the write rate limit for a document:
+
+	counter := client.Counter(post, "likes", simplestore.WithCounterShards(10))
+	err := counter.Increment(ctx, 1)
+	value, err := counter.Value(ctx)	// sum of all shards
+
+Shards are stored as `<parent>/_counter.<name>/<index>`.
+The number of shards can be changed anytime without losing counts.
+Counters created from the client in `RunTransaction` increment and read in the transaction.
+
+# Outbox
+
+Emit writes an event to the outbox atomically with other writes in `RunTransaction`:
+
+	err := client.RunTransaction(ctx, func(ctx context.Context, client *simplestore.Client) error {
+		if _, err := client.Set(ctx, order); err != nil {
+			return err
+		}
+		return client.Emit(ctx, &simplestore.OutboxEvent{
+			Topic:   "order.created",
+			Payload: payload,
+		})
+	})
+
+OutboxRelay delivers events to a `Publisher` in the order of creation and removes delivered events:
+
+	relay := client.NewOutboxRelay(publisher, simplestore.WithRelaySnapshotListener())
+	err := relay.Run(ctx)	// until ctx is canceled
+
+Events are delivered at least once: publishers should handle duplicates with `OutboxEvent.ID`.
+Delivery stops at a failed event and retries it in the next poll.
+Events failed `WithRelayMaxAttempts` times (5 by default) are moved to the `OutboxDeadEvent` collection,
+so they don't block following events.
+Events are stored in the `OutboxEvent` collection.
+
+# Audit log
+
+Writes to audited types are recorded as `AuditEntry` with the actor, the operation, the path and changed fields:
+
+	err := client.EnableAudit(&User{})
+	// or with table maps
+	client.AddAuditedTableMaps(map[string]string{"User": "users"})
+
+	ctx = simplestore.WithAuditActor(ctx, currentUser.ID)
+	_, err := client.Set(ctx, user)
+
+`Create`, `Set` and `Delete` read the current document and write the entry in a transaction,
+atomically with the change.
+Entries are stored in the `AuditEntry` collection.
+Notes:
+
+* In transactions, entries are staged with the writes, and an audited document can't be written twice.
+* Partial updates with `FirestoreClient` are not audited, as simplestore has no `Update`.
+* `Set` with options is not allowed.
+
+# History
+
+Previous versions of documents are kept on `Set` and `Delete` for types configured with `EnableHistory`:
+
+	err := client.EnableHistory(&Article{}, simplestore.WithHistoryMaxVersions(10), simplestore.WithHistoryMaxAge(30*24*time.Hour))
+
+	versions, err := client.History(ctx, article)	// from the latest
+	err := client.Restore(ctx, article, versions[0].Version)
+
+Versions are stored as `<document>/_history/<version>` in a transaction with the write.
+Versions exceeding the limits are deleted on writes. Ages are measured with the server time.
+`Restore` works also for deleted documents, and keeps the current content as a new version.
+In transactions, versions are staged with the writes, and a document with history can't be written twice.
+Partial updates with `FirestoreClient` don't keep versions, as simplestore has no `Update`.
+
+# Migrations
+
+Migrations transform stored documents when models change.
+Register them in order, and `Migrate` applies ones not applied yet:
+
+	err := client.RegisterMigrations(
+		// raw data of documents in all collections with the ID
+		simplestore.NewMigration("001-rename-name", "User", func(ctx context.Context, data map[string]any) (map[string]any, error) {
+			data["FullName"] = data["Name"]
+			delete(data, "Name")
+			return data, nil	// or nil to keep the document as is
+		}, nil),
+		// typed documents: the collection is resolved from UserV2
+		simplestore.NewTypedMigration("002-split-name", splitName, joinName),
+	)
+	states, err := client.Migrate(ctx)
+	states, err := client.Migrate(ctx, simplestore.WithMigrationDryRun(func(change *simplestore.MigrationChange) {
+		log.Printf("%s: %v -> %v", change.Path, change.Before, change.After)
+	}))
+	state, err := client.RollbackMigration(ctx, "002-split-name")	// with the down function
+
+* Documents are migrated in batches of `WithMigrationBatchSize`, each in a transaction with the checkpoint in `_migrations/<migration ID>`. Interrupted migrations resume from the checkpoint.
+* Concurrent runs of the same migration fail with `ErrMigrationConflict`.
+* Documents are written as is: unique indexes, audit logs and histories are not updated.
+* Typed migrations set ID and `Parent` of both passed and returned documents from the migrated document, so returned documents don't need to copy them.
+* Only the latest applied migration can be rolled back, and its state is removed after rolled back.
+* `simplestore migrations` only lists the states. Migrations run and roll back in your programs as they are Go functions.
+
+# Schema versions
+
+Types with `SchemaVersion` field are upgraded lazily on reads, so documents with old and new schemas coexist
+without migrating whole collections:
+
+	type User struct {
+		ID            string
+		SchemaVersion int	// version 0 for documents without the field
+		FirstName     string
+		LastName      string
+	}
+
+	err := client.EnableSchemaUpgrade(&User{}, map[int64]simplestore.SchemaUpgrader{
+		// from version 0 to 1
+		0: func(ctx context.Context, data map[string]any) (map[string]any, error) {
+			data["FirstName"], data["LastName"], _ = strings.Cut(data["Name"].(string), " ")
+			delete(data, "Name")
+			return data, nil
+		},
+	}, simplestore.WithSchemaWriteBack())
+
+* `Get`, `GetAll` and `Query` run upgraders from the version of the document to the current one on the raw data before filling structs. `History`, `Restore` and typed migrations also read old versions through upgraders.
+* Upgraded data is converted as it would be stored and decoded with `DocumentSnapshot.DataTo`: for example, `int` set by upgraders is read as `int64` and `[]string` as `[]any`. Structs can't be set.
+* The current version is the next of the latest upgrader. `Create` and `Set` write it to `SchemaVersion`.
+* Documents with newer versions, written by newer applications, are read as is.
+* `WithSchemaWriteBack` writes upgraded documents back unless they are updated after read. Not written back in transactions, in readonly collections (including parents) nor with clients from `AtReadTime`. Writes are sent in the background in batches with a `BulkWriter`, so reads don't wait for them. Like migrations, they don't go through middlewares, so they're not metered, and don't update unique indexes, audit logs and histories.
+* Use migrations to upgrade all remaining documents later.
+
+# Middlewares
+
+`Use` registers middlewares wrapping every operation of the client.
+A middleware receives an `Operation` describing the kind (`Get`, `GetAll`, `Create`, `Set`, `Delete`, `Query`, `Count`, `Transaction`),
+the collection, the document path and whether it runs in a transaction.
+It can observe, modify results of, or short-circuit operations.
+Clients passed in `RunTransaction` inherit middlewares.
+
+	client.Use(func(next simplestore.Handler) simplestore.Handler {
+		return func(ctx context.Context, op *simplestore.Operation) (any, error) {
+			start := time.Now()
+			result, err := next(ctx, op)
+			log.Printf("%s %s: %v (%v)", op.Kind, op.Path, err, time.Since(start))
+			return result, err
+		}
+	})
+
+# Logging
+
+`UseLogger` logs each operation with `log/slog`: the collection, the document path, query conditions, the duration and the error.
+It's available with Go 1.21 or later as `log/slog` is.
+
+	client.UseLogger(
+		slog.Default(),
+		simplestore.WithLogLevel(slog.LevelInfo),	// level for succeeded operations (default: Debug)
+		simplestore.WithLogErrorLevel(slog.LevelWarn),	// level for failed operations (default: Error)
+		simplestore.WithLogSampleRate(0.1),	// log only 10% of succeeded operations
+	)
+
+Values of fields tagged with `simplestore:"redact"` are logged as `[REDACTED]`:
+
+	type User struct {
+		ID    string
+		Email string `simplestore:"redact"`
+	}
+
+Fields of nested structs are redacted too. Cursor values of queries are redacted
+if any field of `OrderBy` is redacted, and only paths are logged for snapshots passed as cursors.
+
+# Usage metering
+
+`WithUsageMeter` returns a context counting document reads and writes consumed through the context,
+which helps to attribute Firestore costs to API endpoints:
+
+	ctx = simplestore.WithUsageMeter(ctx)
+	// ... operations with ctx ...
+	usage := simplestore.UsageFrom(ctx)
+	fmt.Println(usage.Total().Reads)
+	for collection, u := range usage.Collections() {
+		fmt.Println(collection, u.Reads, u.Writes, u.Deletes)
+	}
+
+# Sessions
+
+`WithSession` returns a context with a per-context identity map.
+Repeated `Get` and `GetAll` for the same documents are served from the session,
+writes through the client invalidate documents in the session, and transactions always read firestore.
+
+	ctx = simplestore.WithSession(ctx)
+	err := client.Get(ctx, doc)	// reads firestore
+	err = client.Get(ctx, doc)	// served from the session
+	fmt.Println(simplestore.SessionFrom(ctx).Stats().Hits)	// the number of saved reads
+
+# Batching reads
+
+Concurrent `Get` and `GetAll` calls can be merged into a single `GetAll` call, which is useful for GraphQL resolvers.
+Enable batching for all reads of the client:
+
+	client.EnableBatching(simplestore.WithBatchWindow(2 * time.Millisecond))
+
+Or only for reads in a scope:
+
+	ctx = simplestore.WithBatchScope(ctx)
+
+Each call waits for other calls for the batch window, or until the batch reaches the max size (`WithMaxBatchSize`).
+Results and errors (e.g. NotFound) are returned to each call. Reads in transactions are never batched.
+A batch reads with values of the context of the first call (e.g. for tracing),
+and with the latest deadline of calls. A call returns when its context is done,
+and the batch is canceled only when all calls in it are canceled.
+
+# Read-through cache
+
+`EnableCache` caches `Get` and `GetAll` results for collections registered with `AddReadonlyTableMaps`:
+
+	client.AddReadonlyTableMaps(map[string]string{
+		"Config": "configs",
+	})
+	client.EnableCache(
+		simplestore.WithCacheTTL(10 * time.Minute),
+		simplestore.WithCacheSnapshotListener(ctx),	// invalidate the cache on changes
+	)
+	fmt.Println(client.CacheStats().Hits)
+
+An in-memory LRU cache is used by default. You can pass other implementations of `Cache` with `WithCacheStore`,
+and other collections to cache with `WithCacheCollections`.
+Reads in transactions always read firestore.
+
 # Type safed client
 
 Many parameters of simpleclient.Client is typed `any`, and you can easily create runtime errors by passing unmached types.
@@ -226,6 +580,14 @@ You can use TypeSafedClient to avoid type assertion errors:
 	}
 	err := TypeSafed[MyDocument](client).Get(ctx, doc)	// you can restrict to pass *MyDocument
 
+TypeSafedClient can retrieve documents without constructing structs:
+
+	doc, err := TypeSafed[MyDocument](client).GetByID(ctx, "docid")
+	docs, err := TypeSafed[MyDocument](client).GetByIDs(ctx, []string{"docid1", "docid2"})
+
+	// Parent of the returned object is set
+	child, err := TypeSafedWithParent[ChildDocument, ParentDocument](client).GetByIDWithParent(ctx, parent, "childid")
+
 # Table Mapping
 
 simplestore supports table mapping to customize collection names and set readonly flags for specific structs.
@@ -250,6 +612,10 @@ You can also set collections as readonly to prevent write operations:
 
 When a collection is marked as readonly, all write operations (Create, Set, Delete) will return an error.
 
+`WithTableMaps` returns a new client with table maps added, keeping the original client unchanged:
+
+	jobs := client.WithTableMaps(map[string]string{"Job": "jobs"})
+
 ## Example Usage
 
 	type MyDocument struct {
@@ -0,0 +1,233 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ikedam/simplestore"
+)
+
+// ChangeKind is the kind of a change of a document
+type ChangeKind string
+
+const (
+	// ChangeAdded means the document exists only in the source
+	ChangeAdded ChangeKind = "added"
+	// ChangeRemoved means the document exists only in the destination
+	ChangeRemoved ChangeKind = "removed"
+	// ChangeChanged means fields of the document differ
+	ChangeChanged ChangeKind = "changed"
+)
+
+// FieldDiff is a difference of a field
+type FieldDiff struct {
+	// Field is the path of the field. Fields in maps are joined with `.`.
+	Field string `json:"field"`
+	// Source is the value in the source, or nil if missing.
+	Source Value `json:"source,omitempty"`
+	// Destination is the value in the destination, or nil if missing.
+	Destination Value `json:"destination,omitempty"`
+}
+
+// DocumentDiff is a change to make the document in the destination same as in the source
+type DocumentDiff struct {
+	// Path is the path of the document relative to the database root.
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+	// Fields are differences of fields for ChangeChanged.
+	Fields []FieldDiff `json:"fields,omitempty"`
+	// source is the document in the source for ChangeAdded and ChangeChanged
+	source map[string]Value
+}
+
+// Diff compares documents of the source in two databases
+// Returns changes to make documents in dst same as in src, sorted by paths.
+// Values are compared as encoded, so references are same if they have the same relative paths.
+// opts are same as for Export.
+// All documents of the source are read into memory.
+func Diff(ctx context.Context, src, dst *simplestore.Client, source Source, opts ...ExportOption) ([]*DocumentDiff, error) {
+	srcRecords, err := collectRecords(ctx, src, source, opts)
+	if err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	dstRecords, err := collectRecords(ctx, dst, source, opts)
+	if err != nil {
+		return nil, fmt.Errorf("destination: %w", err)
+	}
+
+	var diffs []*DocumentDiff
+	for path, srcFields := range srcRecords {
+		dstFields, ok := dstRecords[path]
+		if !ok {
+			diffs = append(diffs, &DocumentDiff{Path: path, Kind: ChangeAdded, source: srcFields})
+			continue
+		}
+		fields := diffFields("", srcFields, dstFields)
+		if len(fields) > 0 {
+			diffs = append(diffs, &DocumentDiff{Path: path, Kind: ChangeChanged, Fields: fields, source: srcFields})
+		}
+	}
+	for path := range dstRecords {
+		if _, ok := srcRecords[path]; !ok {
+			diffs = append(diffs, &DocumentDiff{Path: path, Kind: ChangeRemoved})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs, nil
+}
+
+func collectRecords(ctx context.Context, client *simplestore.Client, source Source, opts []ExportOption) (map[string]map[string]Value, error) {
+	records := make(map[string]map[string]Value)
+	_, err := walk(ctx, client, source, opts, func(record *Record) error {
+		records[record.Path] = record.Fields
+		return nil
+	})
+	return records, err
+}
+
+// diffFields returns differences of fields sorted by names
+// Compares fields in maps recursively.
+func diffFields(prefix string, src, dst map[string]Value) []FieldDiff {
+	names := make([]string, 0, len(src)+len(dst))
+	for name := range src {
+		names = append(names, name)
+	}
+	for name := range dst {
+		if _, ok := src[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []FieldDiff
+	for _, name := range names {
+		srcValue, dstValue := src[name], dst[name]
+		if reflect.DeepEqual(srcValue, dstValue) {
+			continue
+		}
+		srcMap, srcIsMap := srcValue["map"].(map[string]Value)
+		dstMap, dstIsMap := dstValue["map"].(map[string]Value)
+		if srcIsMap && dstIsMap {
+			diffs = append(diffs, diffFields(prefix+name+".", srcMap, dstMap)...)
+			continue
+		}
+		diffs = append(diffs, FieldDiff{
+			Field:       prefix + name,
+			Source:      srcValue,
+			Destination: dstValue,
+		})
+	}
+	return diffs
+}
+
+type applyConfig struct {
+	batchSize   int
+	skipRemoved bool
+}
+
+// ApplyOption configures Apply
+type ApplyOption func(*applyConfig)
+
+// WithApplyBatchSize specifies the number of documents written at once
+// Defaults to DefaultBatchSize.
+func WithApplyBatchSize(n int) ApplyOption {
+	return func(c *applyConfig) {
+		c.batchSize = n
+	}
+}
+
+// WithSkipRemoved keeps documents existing only in the destination
+func WithSkipRemoved() ApplyOption {
+	return func(c *applyConfig) {
+		c.skipRemoved = true
+	}
+}
+
+// Apply writes changes from Diff to dst
+// Added and changed documents are overwritten with the ones in the source,
+// and removed documents are deleted.
+// Fails with ProgrammingError without writing anything
+// if dst must not write to any of collections (see `Client.CheckWritableCollection`).
+// Returns the number of written documents.
+func Apply(ctx context.Context, dst *simplestore.Client, diffs []*DocumentDiff, opts ...ApplyOption) (int, error) {
+	cfg := &applyConfig{
+		batchSize: DefaultBatchSize,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.batchSize < 1 {
+		return 0, simplestore.NewProgrammingErrorf("batch size must be positive: %d", cfg.batchSize)
+	}
+	if dst.FirestoreTransaction != nil {
+		return 0, simplestore.NewProgrammingError("cannot apply changes in a transaction")
+	}
+	for _, diff := range diffs {
+		if diff.Kind == ChangeRemoved && cfg.skipRemoved {
+			continue
+		}
+		if diff.Kind != ChangeRemoved && diff.source == nil {
+			return 0, simplestore.NewProgrammingErrorf("%s: not from Diff", diff.Path)
+		}
+		if err := checkWritablePath(dst, diff.Path); err != nil {
+			return 0, err
+		}
+	}
+
+	dec := NewDecoder(dst.FirestoreClient)
+	w := &batchWriter{
+		bw:   dst.FirestoreClient.BulkWriter(ctx),
+		size: cfg.batchSize,
+	}
+	defer w.bw.End()
+	count := 0
+	for _, diff := range diffs {
+		doc := dst.FirestoreClient.Doc(diff.Path)
+		if doc == nil {
+			return count, simplestore.NewProgrammingErrorf("invalid document path: %s", diff.Path)
+		}
+		switch diff.Kind {
+		case ChangeRemoved:
+			if cfg.skipRemoved {
+				continue
+			}
+			if err := w.delete(doc); err != nil {
+				return count, fmt.Errorf("%s: %w", diff.Path, err)
+			}
+		default:
+			// decode for dst to make references to documents in dst
+			data, err := dec.DecodeFields(diff.source)
+			if err != nil {
+				return count, fmt.Errorf("%s: %w", diff.Path, err)
+			}
+			if err := w.set(doc, data); err != nil {
+				return count, fmt.Errorf("%s: %w", diff.Path, err)
+			}
+		}
+		if w.full() {
+			n, err := w.flush()
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+	n, err := w.flush()
+	return count + n, err
+}
+
+// checkWritablePath checks all collections in the path of the document are writable
+func checkWritablePath(client *simplestore.Client, path string) error {
+	segments := strings.Split(path, "/")
+	for i := 0; i < len(segments); i += 2 {
+		if err := client.CheckWritableCollection(segments[i]); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
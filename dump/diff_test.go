@@ -0,0 +1,145 @@
+package dump
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ikedam/simplestore"
+	"github.com/ikedam/simplestore/simplestoretest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDiffFields(t *testing.T) {
+	src := map[string]Value{
+		"Name":  {"string": "Alice"},
+		"Age":   {"integer": "20"},
+		"Email": {"string": "alice@example.com"},
+		"Address": {"map": map[string]Value{
+			"City": {"string": "Tokyo"},
+			"Zip":  {"string": "100-0001"},
+		}},
+	}
+	dst := map[string]Value{
+		"Name":  {"string": "Alice"},
+		"Age":   {"double": 20.0},
+		"Phone": {"string": "000-0000"},
+		"Address": {"map": map[string]Value{
+			"City": {"string": "Osaka"},
+			"Zip":  {"string": "100-0001"},
+		}},
+	}
+	assert.Equal(
+		t,
+		[]FieldDiff{
+			{Field: "Address.City", Source: Value{"string": "Tokyo"}, Destination: Value{"string": "Osaka"}},
+			{Field: "Age", Source: Value{"integer": "20"}, Destination: Value{"double": 20.0}},
+			{Field: "Email", Source: Value{"string": "alice@example.com"}},
+			{Field: "Phone", Destination: Value{"string": "000-0000"}},
+		},
+		diffFields("", src, dst),
+	)
+	assert.Empty(t, diffFields("", src, src))
+}
+
+func TestApplyChecksDestination(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	diffs := []*DocumentDiff{
+		{Path: "User/alice", Kind: ChangeAdded, source: map[string]Value{}},
+		{Path: "Master/m1/Item/i1", Kind: ChangeRemoved},
+	}
+	var perr *simplestore.ProgrammingError
+
+	_, err := Apply(ctx, client, diffs, WithApplyBatchSize(0))
+	assert.ErrorAs(t, err, &perr)
+
+	// nothing is written for readonly collections
+	client.AddReadonlyTableMaps(map[string]string{"Item": "Item"})
+	_, err = Apply(ctx, client, diffs)
+	assert.ErrorAs(t, err, &perr)
+	assert.ErrorContains(t, err, "Master/m1/Item/i1")
+
+	// diffs not from Diff
+	_, err = Apply(ctx, client, []*DocumentDiff{{Path: "User/alice", Kind: ChangeChanged}})
+	assert.ErrorAs(t, err, &perr)
+}
+
+// newDestinationClient returns a client for another database cleared after the test
+func newDestinationClient(s *DumpTestSuite) *simplestore.Client {
+	client, err := simplestore.NewClientWithProjectIDAndDatabase(
+		context.Background(),
+		s.SimplestoreClient.ProjectID,
+		s.FirestoreDatabaseID+"-dst",
+	)
+	s.Require().NoError(err)
+	s.T().Cleanup(func() {
+		simplestoretest.ClearFirestore(s.T(), client)
+		client.Close()
+	})
+	return client
+}
+
+func (s *DumpTestSuite) TestDiffAndApply() {
+	ctx := context.Background()
+	src := s.SimplestoreClient
+	dst := newDestinationClient(s)
+	s.setupDocuments()
+	for _, o := range []any{
+		&User{ID: "alice", Name: "Alicia"},
+		&User{ID: "bob", Name: "Bob"},
+		&User{ID: "dave", Name: "Dave"},
+		&Post{Parent: &User{ID: "bob"}, ID: "1", Title: "Hi"},
+	} {
+		_, err := dst.Set(ctx, o)
+		s.Require().NoError(err)
+	}
+
+	diffs, err := Diff(ctx, src, dst, Collection("User"))
+	s.Require().NoError(err)
+	s.Require().Len(diffs, 2)
+	s.Equal("User/alice", diffs[0].Path)
+	s.Equal(ChangeChanged, diffs[0].Kind)
+	s.Equal(
+		[]FieldDiff{{Field: "Name", Source: Value{"string": "Alice"}, Destination: Value{"string": "Alicia"}}},
+		diffs[0].Fields,
+	)
+	s.Equal("User/dave", diffs[1].Path)
+	s.Equal(ChangeRemoved, diffs[1].Kind)
+
+	diffs, err = Diff(ctx, src, dst, Database())
+	s.Require().NoError(err)
+	paths := make(map[string]ChangeKind)
+	for _, diff := range diffs {
+		paths[diff.Path] = diff.Kind
+	}
+	s.Equal(
+		map[string]ChangeKind{
+			"User/alice":        ChangeChanged,
+			"User/alice/Post/1": ChangeAdded,
+			"User/alice/Post/2": ChangeAdded,
+			"User/carol/Post/1": ChangeAdded,
+			"User/dave":         ChangeRemoved,
+		},
+		paths,
+	)
+
+	// keep removed documents
+	n, err := Apply(ctx, dst, diffs, WithApplyBatchSize(2), WithSkipRemoved())
+	s.Require().NoError(err)
+	s.Equal(4, n)
+	s.Require().NoError(dst.Get(ctx, &User{ID: "dave"}))
+	user := &User{ID: "alice"}
+	s.Require().NoError(dst.Get(ctx, user))
+	s.Equal("Alice", user.Name)
+
+	n, err = Apply(ctx, dst, diffs)
+	s.Require().NoError(err)
+	s.Equal(5, n)
+	s.Equal(codes.NotFound, status.Code(dst.Get(ctx, &User{ID: "dave"})))
+
+	diffs, err = Diff(ctx, src, dst, Database())
+	s.Require().NoError(err)
+	s.Empty(diffs)
+}
@@ -0,0 +1,322 @@
+/*
+Package dump exports and imports firestore documents as JSON Lines.
+
+	n, err := dump.Export(ctx, client, w, dump.Collection("User"), dump.WithRecursive())
+	n, err := dump.Import(ctx, client, r, dump.WithPathRewrite("User/", "ArchivedUser/"))
+
+Each line is a Record with the path of the document relative to the database root
+and typed fields:
+
+	{"path":"User/alice","fields":{"name":{"string":"Alice"},"age":{"integer":"20"}}}
+
+Values are objects with one of keys
+`null`, `boolean`, `integer` (string), `double`, `timestamp` (RFC 3339), `string`,
+`bytes` (base64), `reference` (relative path), `geopoint`, `array` and `map`,
+so that firestore types are restored exactly.
+Doubles that JSON can't express are written as strings `NaN`, `Infinity` and `-Infinity`.
+
+Diff compares documents in two databases with encoded values, and Apply writes the differences:
+
+	diffs, err := dump.Diff(ctx, production, staging, dump.Collection("Master"))
+	n, err := dump.Apply(ctx, staging, diffs)
+*/
+package dump
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+// Record is a document in JSON Lines
+type Record struct {
+	// Path is the path of the document relative to the database root.
+	// e.g. `ParentDocument/p/ChildDocument/c`
+	Path string `json:"path"`
+	// Fields is the encoded fields of the document.
+	Fields map[string]Value `json:"fields"`
+}
+
+// Value is an encoded firestore value
+// An object with a key for the type.
+type Value map[string]any
+
+// relativePath returns the path relative to the database root
+func relativePath(path string) string {
+	_, rel, found := strings.Cut(path, "/documents/")
+	if !found {
+		return path
+	}
+	return rel
+}
+
+// NewRecord encodes a document snapshot
+func NewRecord(docsnap *firestore.DocumentSnapshot) (*Record, error) {
+	fields, err := EncodeFields(docsnap.Data())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", relativePath(docsnap.Ref.Path), err)
+	}
+	return &Record{
+		Path:   relativePath(docsnap.Ref.Path),
+		Fields: fields,
+	}, nil
+}
+
+// EncodeFields encodes fields from `DocumentSnapshot.Data()`
+func EncodeFields(data map[string]any) (map[string]Value, error) {
+	fields := make(map[string]Value, len(data))
+	for name, v := range data {
+		encoded, err := EncodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		fields[name] = encoded
+	}
+	return fields, nil
+}
+
+// EncodeValue encodes a value from `DocumentSnapshot.Data()`
+func EncodeValue(v any) (Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return Value{"null": nil}, nil
+	case bool:
+		return Value{"boolean": v}, nil
+	case int64:
+		return Value{"integer": strconv.FormatInt(v, 10)}, nil
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return Value{"double": "NaN"}, nil
+		case math.IsInf(v, 1):
+			return Value{"double": "Infinity"}, nil
+		case math.IsInf(v, -1):
+			return Value{"double": "-Infinity"}, nil
+		}
+		return Value{"double": v}, nil
+	case time.Time:
+		return Value{"timestamp": v.UTC().Format(time.RFC3339Nano)}, nil
+	case string:
+		return Value{"string": v}, nil
+	case []byte:
+		return Value{"bytes": base64.StdEncoding.EncodeToString(v)}, nil
+	case *firestore.DocumentRef:
+		return Value{"reference": relativePath(v.Path)}, nil
+	case *latlng.LatLng:
+		return Value{"geopoint": map[string]float64{
+			"latitude":  v.GetLatitude(),
+			"longitude": v.GetLongitude(),
+		}}, nil
+	case []any:
+		values := make([]Value, 0, len(v))
+		for i, e := range v {
+			encoded, err := EncodeValue(e)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			values = append(values, encoded)
+		}
+		return Value{"array": values}, nil
+	case map[string]any:
+		fields, err := EncodeFields(v)
+		if err != nil {
+			return nil, err
+		}
+		return Value{"map": fields}, nil
+	}
+	return nil, fmt.Errorf("unsupported type: %T", v)
+}
+
+// Decoder decodes records to values to write to firestore
+type Decoder struct {
+	client   *firestore.Client
+	rewrites []rewrite
+}
+
+type rewrite struct {
+	from string
+	to   string
+}
+
+// NewDecoder returns a new Decoder
+// References are resolved with client.
+func NewDecoder(client *firestore.Client) *Decoder {
+	return &Decoder{
+		client: client,
+	}
+}
+
+// AddPathRewrite rewrites paths of documents and references starting with from
+// Rewrites are tested in the order of additions, and the first matching one is applied.
+func (d *Decoder) AddPathRewrite(from, to string) {
+	d.rewrites = append(d.rewrites, rewrite{from: from, to: to})
+}
+
+// RewritePath returns the path with rewrites applied
+func (d *Decoder) RewritePath(path string) string {
+	for _, r := range d.rewrites {
+		if rest, ok := strings.CutPrefix(path, r.from); ok {
+			return r.to + rest
+		}
+	}
+	return path
+}
+
+// DecodeFields decodes fields to a map to write to firestore
+func (d *Decoder) DecodeFields(fields map[string]Value) (map[string]any, error) {
+	data := make(map[string]any, len(fields))
+	for name, v := range fields {
+		decoded, err := d.DecodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		data[name] = decoded
+	}
+	return data, nil
+}
+
+// DecodeValue decodes a value to write to firestore
+// Accepts values both from EncodeValue and from JSON.
+func (d *Decoder) DecodeValue(v Value) (any, error) {
+	if len(v) != 1 {
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return nil, fmt.Errorf("value must have exactly one type: %v", keys)
+	}
+	for typ, raw := range v {
+		return d.decodeTyped(typ, raw)
+	}
+	panic("unreachable")
+}
+
+func (d *Decoder) decodeTyped(typ string, raw any) (any, error) {
+	switch typ {
+	case "null":
+		return nil, nil
+	case "boolean":
+		if b, ok := raw.(bool); ok {
+			return b, nil
+		}
+	case "integer":
+		switch raw := raw.(type) {
+		case string:
+			return strconv.ParseInt(raw, 10, 64)
+		case json.Number:
+			return raw.Int64()
+		case float64:
+			if raw == math.Trunc(raw) {
+				return int64(raw), nil
+			}
+		}
+	case "double":
+		switch raw := raw.(type) {
+		case float64:
+			return raw, nil
+		case json.Number:
+			return raw.Float64()
+		case string:
+			switch raw {
+			case "NaN":
+				return math.NaN(), nil
+			case "Infinity":
+				return math.Inf(1), nil
+			case "-Infinity":
+				return math.Inf(-1), nil
+			}
+		}
+	case "timestamp":
+		if s, ok := raw.(string); ok {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+	case "string":
+		if s, ok := raw.(string); ok {
+			return s, nil
+		}
+	case "bytes":
+		if s, ok := raw.(string); ok {
+			return base64.StdEncoding.DecodeString(s)
+		}
+	case "reference":
+		if s, ok := raw.(string); ok {
+			return d.client.Doc(d.RewritePath(s)), nil
+		}
+	case "geopoint":
+		if m, ok := raw.(map[string]float64); ok {
+			raw = map[string]any{"latitude": m["latitude"], "longitude": m["longitude"]}
+		}
+		if m, ok := raw.(map[string]any); ok {
+			lat, latOK := toFloat(m["latitude"])
+			lng, lngOK := toFloat(m["longitude"])
+			if latOK && lngOK {
+				return &latlng.LatLng{Latitude: lat, Longitude: lng}, nil
+			}
+		}
+	case "array":
+		if a, ok := raw.([]Value); ok {
+			values := make([]any, 0, len(a))
+			for i, e := range a {
+				decoded, err := d.DecodeValue(e)
+				if err != nil {
+					return nil, fmt.Errorf("[%d]: %w", i, err)
+				}
+				values = append(values, decoded)
+			}
+			return values, nil
+		}
+		if a, ok := raw.([]any); ok {
+			values := make([]any, 0, len(a))
+			for i, e := range a {
+				m, ok := e.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("[%d]: value must be an object", i)
+				}
+				decoded, err := d.DecodeValue(Value(m))
+				if err != nil {
+					return nil, fmt.Errorf("[%d]: %w", i, err)
+				}
+				values = append(values, decoded)
+			}
+			return values, nil
+		}
+	case "map":
+		if m, ok := raw.(map[string]Value); ok {
+			return d.DecodeFields(m)
+		}
+		if m, ok := raw.(map[string]any); ok {
+			fields := make(map[string]Value, len(m))
+			for name, e := range m {
+				em, ok := e.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("%s: value must be an object", name)
+				}
+				fields[name] = Value(em)
+			}
+			return d.DecodeFields(fields)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type: %s", typ)
+	}
+	return nil, fmt.Errorf("invalid %s: %v", typ, raw)
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,152 @@
+package dump
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ikedam/simplestore"
+	"github.com/ikedam/simplestore/simplestoretest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+type DumpTestSuite struct {
+	simplestoretest.FirestoreTestSuite
+}
+
+func TestDumpTestSuite(t *testing.T) {
+	suite.Run(t, new(DumpTestSuite))
+}
+
+func newTestClient(t *testing.T) *simplestore.Client {
+	client, err := simplestore.NewWithProjectID(context.Background(), "testproject")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+func TestEncodeValue(t *testing.T) {
+	client := newTestClient(t)
+	ts := time.Date(2023, 4, 5, 6, 7, 8, 9, time.FixedZone("JST", 9*60*60))
+	data := map[string]any{
+		"null":      nil,
+		"boolean":   true,
+		"integer":   int64(math.MaxInt64),
+		"double":    1.5,
+		"timestamp": ts,
+		"string":    "text",
+		"bytes":     []byte{0, 1, 2},
+		"reference": client.FirestoreClient.Doc("User/alice"),
+		"geopoint":  &latlng.LatLng{Latitude: 35.6, Longitude: 139.7},
+		"array":     []any{int64(1), "two"},
+		"map":       map[string]any{"nested": false},
+	}
+	fields, err := EncodeFields(data)
+	require.NoError(t, err)
+	encoded, err := json.Marshal(fields)
+	require.NoError(t, err)
+	assert.JSONEq(
+		t,
+		`{
+			"null": {"null": null},
+			"boolean": {"boolean": true},
+			"integer": {"integer": "9223372036854775807"},
+			"double": {"double": 1.5},
+			"timestamp": {"timestamp": "2023-04-04T21:07:08.000000009Z"},
+			"string": {"string": "text"},
+			"bytes": {"bytes": "AAEC"},
+			"reference": {"reference": "User/alice"},
+			"geopoint": {"geopoint": {"latitude": 35.6, "longitude": 139.7}},
+			"array": {"array": [{"integer": "1"}, {"string": "two"}]},
+			"map": {"map": {"nested": {"boolean": false}}}
+		}`,
+		string(encoded),
+	)
+
+	// round trip
+	var record Record
+	dec := json.NewDecoder(strings.NewReader(`{"path":"User/alice","fields":` + string(encoded) + `}`))
+	dec.UseNumber()
+	require.NoError(t, dec.Decode(&record))
+	decoded, err := NewDecoder(client.FirestoreClient).DecodeFields(record.Fields)
+	require.NoError(t, err)
+	assert.True(t, ts.Equal(decoded["timestamp"].(time.Time)))
+	decoded["timestamp"] = ts
+	assert.Equal(t, "User/alice", relativePath(decoded["reference"].(*firestore.DocumentRef).Path))
+	decoded["reference"] = data["reference"]
+	assert.Equal(t, data["geopoint"].(*latlng.LatLng).String(), decoded["geopoint"].(*latlng.LatLng).String())
+	decoded["geopoint"] = data["geopoint"]
+	assert.Equal(t, data, decoded)
+
+	// without JSON
+	decoded, err = NewDecoder(client.FirestoreClient).DecodeFields(fields)
+	require.NoError(t, err)
+	assert.Equal(t, data["array"], decoded["array"])
+	assert.Equal(t, data["map"], decoded["map"])
+	assert.Equal(t, data["geopoint"].(*latlng.LatLng).String(), decoded["geopoint"].(*latlng.LatLng).String())
+}
+
+func TestEncodeSpecialDoubles(t *testing.T) {
+	client := newTestClient(t)
+	dec := NewDecoder(client.FirestoreClient)
+	for _, v := range []float64{math.Inf(1), math.Inf(-1), math.NaN()} {
+		encoded, err := EncodeValue(v)
+		require.NoError(t, err)
+		_, err = json.Marshal(encoded)
+		require.NoError(t, err)
+		decoded, err := dec.DecodeValue(encoded)
+		require.NoError(t, err)
+		if math.IsNaN(v) {
+			assert.True(t, math.IsNaN(decoded.(float64)))
+		} else {
+			assert.Equal(t, v, decoded)
+		}
+	}
+
+	_, err := EncodeValue(struct{}{})
+	assert.Error(t, err)
+}
+
+func TestDecodeValueErrors(t *testing.T) {
+	client := newTestClient(t)
+	dec := NewDecoder(client.FirestoreClient)
+	for _, v := range []Value{
+		{},
+		{"string": "a", "integer": "1"},
+		{"unknown": "a"},
+		{"integer": "one"},
+		{"integer": 1.5},
+		{"double": "one"},
+		{"timestamp": "yesterday"},
+		{"bytes": "!"},
+		{"geopoint": map[string]any{"latitude": 1.0}},
+		{"array": []any{"raw"}},
+		{"map": map[string]any{"field": "raw"}},
+	} {
+		_, err := dec.DecodeValue(v)
+		assert.Error(t, err, "%v", v)
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	client := newTestClient(t)
+	dec := NewDecoder(client.FirestoreClient)
+	dec.AddPathRewrite("User/alice/", "User/bob/")
+	dec.AddPathRewrite("User/", "ArchivedUser/")
+	assert.Equal(t, "User/bob/Post/1", dec.RewritePath("User/alice/Post/1"))
+	assert.Equal(t, "ArchivedUser/carol", dec.RewritePath("User/carol"))
+	assert.Equal(t, "Post/1", dec.RewritePath("Post/1"))
+
+	ref, err := dec.DecodeValue(Value{"reference": "User/carol"})
+	require.NoError(t, err)
+	assert.Equal(t, "ArchivedUser/carol", relativePath(ref.(*firestore.DocumentRef).Path))
+}
@@ -0,0 +1,273 @@
+package dump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ikedam/simplestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type sourceKind int
+
+const (
+	sourceCollection sourceKind = iota
+	sourceCollectionGroup
+	sourceDocument
+	sourceDatabase
+)
+
+// Source specifies documents to export
+type Source struct {
+	kind sourceKind
+	path string
+}
+
+// Collection exports documents in the collection
+// path is like `ParentDocument/p/ChildDocument`.
+func Collection(path string) Source {
+	return Source{kind: sourceCollection, path: strings.Trim(path, "/")}
+}
+
+// CollectionGroup exports documents in all collections with the id
+func CollectionGroup(id string) Source {
+	return Source{kind: sourceCollectionGroup, path: id}
+}
+
+// Document exports the document and all documents under it
+// path is like `ParentDocument/p`.
+func Document(path string) Source {
+	return Source{kind: sourceDocument, path: strings.Trim(path, "/")}
+}
+
+// Database exports all documents in the database
+func Database() Source {
+	return Source{kind: sourceDatabase}
+}
+
+// ParseSource returns the source for the path
+// Paths with odd segments are collections, and with even segments are documents.
+// An empty path is the database.
+func ParseSource(path string) Source {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return Database()
+	}
+	if strings.Count(path, "/")%2 == 1 {
+		return Document(path)
+	}
+	return Collection(path)
+}
+
+// String returns the description of the source
+func (s Source) String() string {
+	switch s.kind {
+	case sourceCollectionGroup:
+		return "collection group " + s.path
+	case sourceDocument:
+		return "document " + s.path
+	case sourceDatabase:
+		return "database"
+	}
+	return "collection " + s.path
+}
+
+type exportConfig struct {
+	recursive bool
+}
+
+// ExportOption configures Export
+type ExportOption func(*exportConfig)
+
+// WithRecursive exports also documents in subcollections of collections
+// Documents are always exported recursively for `Document` and `Database`.
+func WithRecursive() ExportOption {
+	return func(c *exportConfig) {
+		c.recursive = true
+	}
+}
+
+// Export writes documents of the source to w as JSON Lines
+// Use a client from `AtReadTime` to export documents at a time.
+// Subcollections are listed at the latest as listing collections doesn't support read times.
+// Returns the number of exported documents.
+func Export(ctx context.Context, client *simplestore.Client, w io.Writer, source Source, opts ...ExportOption) (int, error) {
+	enc := json.NewEncoder(w)
+	return walk(ctx, client, source, opts, func(record *Record) error {
+		return enc.Encode(record)
+	})
+}
+
+// walk calls visit for each document of the source
+// Returns the number of visited documents.
+func walk(ctx context.Context, client *simplestore.Client, source Source, opts []ExportOption, visit func(record *Record) error) (int, error) {
+	cfg := &exportConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if source.path == "" && source.kind != sourceDatabase {
+		return 0, simplestore.NewProgrammingError("path is empty")
+	}
+	e := &exporter{
+		visit: visit,
+	}
+	var err error
+	switch source.kind {
+	case sourceCollectionGroup:
+		// nested collections with the id are found with the query
+		e.skipCollectionID = source.path
+		err = e.exportQuery(ctx, client.FirestoreClient.CollectionGroup(source.path).Query, cfg.recursive)
+	case sourceDocument:
+		doc := client.FirestoreClient.Doc(source.path)
+		if doc == nil {
+			return 0, simplestore.NewProgrammingErrorf("invalid document path: %s", source.path)
+		}
+		err = e.exportDocument(ctx, doc)
+	case sourceDatabase:
+		err = e.exportDatabase(ctx, client.FirestoreClient)
+	default:
+		collection := client.FirestoreClient.Collection(source.path)
+		if collection == nil {
+			return 0, simplestore.NewProgrammingErrorf("invalid collection path: %s", source.path)
+		}
+		if cfg.recursive {
+			err = e.exportCollection(ctx, collection)
+		} else {
+			err = e.exportQuery(ctx, collection.Query, false)
+		}
+	}
+	return e.count, err
+}
+
+type exporter struct {
+	visit func(record *Record) error
+	count int
+	// skipCollectionID is the id of subcollections not to export
+	skipCollectionID string
+}
+
+func (e *exporter) write(docsnap *firestore.DocumentSnapshot) error {
+	record, err := NewRecord(docsnap)
+	if err != nil {
+		return err
+	}
+	if err := e.visit(record); err != nil {
+		return err
+	}
+	e.count++
+	return nil
+}
+
+// exportQuery exports documents found with the query
+func (e *exporter) exportQuery(ctx context.Context, q firestore.Query, recursive bool) error {
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := e.write(docsnap); err != nil {
+			return err
+		}
+		if recursive {
+			if err := e.exportSubcollections(ctx, docsnap.Ref); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// exportDocument exports the document and documents under it
+// Subcollections are exported also for missing documents.
+func (e *exporter) exportDocument(ctx context.Context, doc *firestore.DocumentRef) error {
+	docsnap, err := doc.Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	if docsnap.Exists() {
+		if err := e.write(docsnap); err != nil {
+			return err
+		}
+	}
+	return e.exportSubcollections(ctx, doc)
+}
+
+// exportCollection exports documents in the collection recursively
+// Documents are read with a query, and listed without fields to find subcollections of missing documents.
+func (e *exporter) exportCollection(ctx context.Context, collection *firestore.CollectionRef) error {
+	found := map[string]bool{}
+	iter := collection.Documents(ctx)
+	defer iter.Stop()
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		found[docsnap.Ref.ID] = true
+		if err := e.write(docsnap); err != nil {
+			return err
+		}
+		if err := e.exportSubcollections(ctx, docsnap.Ref); err != nil {
+			return err
+		}
+	}
+	refs := collection.DocumentRefs(ctx)
+	for {
+		doc, err := refs.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if found[doc.ID] {
+			continue
+		}
+		if err := e.exportSubcollections(ctx, doc); err != nil {
+			return err
+		}
+	}
+}
+
+// exportDatabase exports documents in all root collections recursively
+func (e *exporter) exportDatabase(ctx context.Context, client *firestore.Client) error {
+	collections, err := client.Collections(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, collection := range collections {
+		if err := e.exportCollection(ctx, collection); err != nil {
+			return fmt.Errorf("%s: %w", collection.ID, err)
+		}
+	}
+	return nil
+}
+
+func (e *exporter) exportSubcollections(ctx context.Context, doc *firestore.DocumentRef) error {
+	collections, err := doc.Collections(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, collection := range collections {
+		if collection.ID == e.skipCollectionID {
+			continue
+		}
+		if err := e.exportCollection(ctx, collection); err != nil {
+			return fmt.Errorf("%s: %w", relativePath(collection.Path), err)
+		}
+	}
+	return nil
+}
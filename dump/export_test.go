@@ -0,0 +1,176 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type User struct {
+	ID   string
+	Name string
+}
+
+type Post struct {
+	Parent *User
+	ID     string
+	Title  string
+}
+
+func TestParseSource(t *testing.T) {
+	assert.Equal(t, Collection("User"), ParseSource("User"))
+	assert.Equal(t, Document("User/alice"), ParseSource("/User/alice/"))
+	assert.Equal(t, Collection("User/alice/Post"), ParseSource("User/alice/Post"))
+	assert.Equal(t, Database(), ParseSource("/"))
+	assert.Equal(t, "collection User", Collection("User").String())
+	assert.Equal(t, "collection group Post", CollectionGroup("Post").String())
+	assert.Equal(t, "document User/alice", Document("User/alice").String())
+	assert.Equal(t, "database", Database().String())
+}
+
+// exportedPaths returns sorted paths of records in JSON Lines
+func exportedPaths(s *DumpTestSuite, data string) []string {
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		if line == "" {
+			continue
+		}
+		var record Record
+		s.Require().NoError(json.Unmarshal([]byte(line), &record))
+		paths = append(paths, record.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *DumpTestSuite) setupDocuments() {
+	ctx := context.Background()
+	client := s.SimplestoreClient
+	alice := &User{ID: "alice", Name: "Alice"}
+	bob := &User{ID: "bob", Name: "Bob"}
+	for _, o := range []any{
+		alice,
+		bob,
+		&Post{Parent: alice, ID: "1", Title: "Hello"},
+		&Post{Parent: alice, ID: "2", Title: "World"},
+		&Post{Parent: bob, ID: "1", Title: "Hi"},
+		// a post under a missing user
+		&Post{Parent: &User{ID: "carol"}, ID: "1", Title: "Orphan"},
+	} {
+		_, err := client.Set(ctx, o)
+		s.Require().NoError(err)
+	}
+}
+
+func (s *DumpTestSuite) TestExportCollection() {
+	ctx := context.Background()
+	s.setupDocuments()
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, s.SimplestoreClient, &buf, Collection("User"))
+	s.Require().NoError(err)
+	s.Equal(2, n)
+	s.Equal([]string{"User/alice", "User/bob"}, exportedPaths(s, buf.String()))
+
+	buf.Reset()
+	n, err = Export(ctx, s.SimplestoreClient, &buf, Collection("User"), WithRecursive())
+	s.Require().NoError(err)
+	s.Equal(6, n)
+	s.Equal(
+		[]string{
+			"User/alice",
+			"User/alice/Post/1",
+			"User/alice/Post/2",
+			"User/bob",
+			"User/bob/Post/1",
+			"User/carol/Post/1",
+		},
+		exportedPaths(s, buf.String()),
+	)
+
+	var record Record
+	s.Require().NoError(json.Unmarshal([]byte(strings.SplitN(buf.String(), "\n", 2)[0]), &record))
+	s.Equal(Value{"string": "Alice"}, record.Fields["Name"])
+}
+
+func (s *DumpTestSuite) TestExportCollectionGroup() {
+	ctx := context.Background()
+	s.setupDocuments()
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, s.SimplestoreClient, &buf, CollectionGroup("Post"))
+	s.Require().NoError(err)
+	s.Equal(4, n)
+	s.Equal(
+		[]string{
+			"User/alice/Post/1",
+			"User/alice/Post/2",
+			"User/bob/Post/1",
+			"User/carol/Post/1",
+		},
+		exportedPaths(s, buf.String()),
+	)
+}
+
+func (s *DumpTestSuite) TestExportCollectionGroupRecursive() {
+	ctx := context.Background()
+	s.setupDocuments()
+	// nested collections with the same id are exported once
+	_, err := s.SimplestoreClient.FirestoreClient.Doc("User/alice/Post/1/Post/reply").Set(ctx, map[string]any{"Title": "Reply"})
+	s.Require().NoError(err)
+	_, err = s.SimplestoreClient.FirestoreClient.Doc("User/alice/Post/1/Comment/c1").Set(ctx, map[string]any{"Body": "Nice"})
+	s.Require().NoError(err)
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, s.SimplestoreClient, &buf, CollectionGroup("Post"), WithRecursive())
+	s.Require().NoError(err)
+	s.Equal(6, n)
+	s.Equal(
+		[]string{
+			"User/alice/Post/1",
+			"User/alice/Post/1/Comment/c1",
+			"User/alice/Post/1/Post/reply",
+			"User/alice/Post/2",
+			"User/bob/Post/1",
+			"User/carol/Post/1",
+		},
+		exportedPaths(s, buf.String()),
+	)
+}
+
+func (s *DumpTestSuite) TestExportDocument() {
+	ctx := context.Background()
+	s.setupDocuments()
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, s.SimplestoreClient, &buf, Document("User/alice"))
+	s.Require().NoError(err)
+	s.Equal(3, n)
+	s.Equal(
+		[]string{"User/alice", "User/alice/Post/1", "User/alice/Post/2"},
+		exportedPaths(s, buf.String()),
+	)
+
+	// subcollections of missing documents
+	buf.Reset()
+	n, err = Export(ctx, s.SimplestoreClient, &buf, Document("User/carol"))
+	s.Require().NoError(err)
+	s.Equal(1, n)
+	s.Equal([]string{"User/carol/Post/1"}, exportedPaths(s, buf.String()))
+}
+
+func (s *DumpTestSuite) TestExportInvalidPath() {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	_, err := Export(ctx, s.SimplestoreClient, &buf, Collection(""))
+	s.Error(err)
+	_, err = Export(ctx, s.SimplestoreClient, &buf, Collection("User/alice"))
+	s.Error(err)
+	_, err = Export(ctx, s.SimplestoreClient, &buf, Document("User"))
+	s.Error(err)
+}
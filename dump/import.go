@@ -0,0 +1,200 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ikedam/simplestore"
+)
+
+const (
+	// DefaultBatchSize is the default number of documents written at once in Import
+	DefaultBatchSize = 500
+	// maxLineSize is the max size of a line in JSON Lines
+	// Firestore documents are up to 1 MiB, and encoding adds overhead.
+	maxLineSize = 16 * 1024 * 1024
+)
+
+type importConfig struct {
+	batchSize int
+	rewrites  []rewrite
+	dryRun    bool
+}
+
+// ImportOption configures Import
+type ImportOption func(*importConfig)
+
+// WithBatchSize specifies the number of documents written at once
+// Defaults to DefaultBatchSize.
+func WithBatchSize(n int) ImportOption {
+	return func(c *importConfig) {
+		c.batchSize = n
+	}
+}
+
+// WithPathRewrite rewrites paths of documents and references starting with from
+// Can be specified multiple times, and the first matching one is applied.
+// e.g. `WithPathRewrite("User/", "ArchivedUser/")`
+func WithPathRewrite(from, to string) ImportOption {
+	return func(c *importConfig) {
+		c.rewrites = append(c.rewrites, rewrite{from: from, to: to})
+	}
+}
+
+// WithDryRun decodes and validates records without writing
+func WithDryRun() ImportOption {
+	return func(c *importConfig) {
+		c.dryRun = true
+	}
+}
+
+// Import writes documents in JSON Lines from r to firestore
+// Existing documents are overwritten.
+// Fails with ProgrammingError for documents in collections client must not write to
+// (see `Client.CheckWritableCollection`).
+// Returns the number of imported documents (or validated documents for `WithDryRun`).
+// Documents written before an error are kept.
+func Import(ctx context.Context, client *simplestore.Client, r io.Reader, opts ...ImportOption) (int, error) {
+	cfg := &importConfig{
+		batchSize: DefaultBatchSize,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.batchSize < 1 {
+		return 0, simplestore.NewProgrammingErrorf("batch size must be positive: %d", cfg.batchSize)
+	}
+	dec := NewDecoder(client.FirestoreClient)
+	for _, rw := range cfg.rewrites {
+		dec.AddPathRewrite(rw.from, rw.to)
+	}
+	var w *batchWriter
+	if !cfg.dryRun {
+		w = &batchWriter{
+			bw:   client.FirestoreClient.BulkWriter(ctx),
+			size: cfg.batchSize,
+		}
+		defer w.bw.End()
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	count := 0
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		doc, data, err := decodeRecord(client.FirestoreClient, dec, scanner.Bytes())
+		if err != nil {
+			return count, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := checkWritablePath(client, relativePath(doc.Path)); err != nil {
+			return count, fmt.Errorf("line %d: %w", line, err)
+		}
+		if w != nil {
+			if err := w.set(doc, data); err != nil {
+				return count, fmt.Errorf("line %d: %w", line, err)
+			}
+			if w.full() {
+				n, err := w.flush()
+				count += n
+				if err != nil {
+					return count, err
+				}
+			}
+		} else {
+			count++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("line %d: %w", line+1, err)
+	}
+	if w != nil {
+		n, err := w.flush()
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func decodeRecord(client *firestore.Client, dec *Decoder, line []byte) (*firestore.DocumentRef, map[string]any, error) {
+	jsonDec := json.NewDecoder(bytes.NewReader(line))
+	// keep precisions of numbers
+	jsonDec.UseNumber()
+	var record Record
+	if err := jsonDec.Decode(&record); err != nil {
+		return nil, nil, err
+	}
+	path := dec.RewritePath(strings.Trim(record.Path, "/"))
+	doc := client.Doc(path)
+	if doc == nil {
+		return nil, nil, fmt.Errorf("invalid document path: %q", path)
+	}
+	data, err := dec.DecodeFields(record.Fields)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return doc, data, nil
+}
+
+// batchWriter writes documents with BulkWriter and waits results per batch
+type batchWriter struct {
+	bw   *firestore.BulkWriter
+	size int
+	jobs []*firestore.BulkWriterJob
+	docs []*firestore.DocumentRef
+}
+
+func (w *batchWriter) set(doc *firestore.DocumentRef, data map[string]any) error {
+	job, err := w.bw.Set(doc, data)
+	if err != nil {
+		return err
+	}
+	w.jobs = append(w.jobs, job)
+	w.docs = append(w.docs, doc)
+	return nil
+}
+
+func (w *batchWriter) delete(doc *firestore.DocumentRef) error {
+	job, err := w.bw.Delete(doc)
+	if err != nil {
+		return err
+	}
+	w.jobs = append(w.jobs, job)
+	w.docs = append(w.docs, doc)
+	return nil
+}
+
+func (w *batchWriter) full() bool {
+	return len(w.jobs) >= w.size
+}
+
+// flush waits all pending writes
+// Returns the number of written documents and the first error.
+func (w *batchWriter) flush() (int, error) {
+	w.bw.Flush()
+	count := 0
+	var firstErr error
+	for i, job := range w.jobs {
+		if _, err := job.Results(); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", relativePath(w.docs[i].Path), err)
+			}
+			continue
+		}
+		count++
+	}
+	w.jobs = nil
+	w.docs = nil
+	return count, firstErr
+}
@@ -0,0 +1,142 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ikedam/simplestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Bookmark struct {
+	ID   string
+	Post *firestore.DocumentRef
+}
+
+func TestImportInvalidBatchSize(t *testing.T) {
+	client := newTestClient(t)
+	_, err := Import(context.Background(), client, strings.NewReader(""), WithBatchSize(0))
+	var perr *simplestore.ProgrammingError
+	assert.ErrorAs(t, err, &perr)
+}
+
+func TestImportDryRun(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	input := `{"path":"User/alice","fields":{"Name":{"string":"Alice"}}}
+
+{"path":"User/bob","fields":{"Name":{"string":"Bob"}}}
+`
+	n, err := Import(ctx, client, strings.NewReader(input), WithDryRun())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// errors are reported with line numbers
+	input = `{"path":"User/alice","fields":{"Name":{"string":"Alice"}}}
+{"path":"User","fields":{}}
+`
+	n, err = Import(ctx, client, strings.NewReader(input), WithDryRun())
+	assert.ErrorContains(t, err, "line 2")
+	assert.Equal(t, 1, n)
+
+	_, err = Import(ctx, client, strings.NewReader(`{"path":"User/alice","fields":{"Name":{"text":"Alice"}}}`), WithDryRun())
+	assert.ErrorContains(t, err, "line 1")
+	_, err = Import(ctx, client, strings.NewReader(`not json`), WithDryRun())
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestImportReadonlyCollection(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	client.AddReadonlyTableMaps(map[string]string{"User": "User"})
+	input := `{"path":"Post/1","fields":{}}
+{"path":"User/alice/Post/1","fields":{}}
+`
+	n, err := Import(ctx, client, strings.NewReader(input), WithDryRun())
+	var perr *simplestore.ProgrammingError
+	assert.ErrorAs(t, err, &perr)
+	assert.ErrorContains(t, err, "line 2")
+	assert.Equal(t, 1, n)
+
+	// nothing is written before the check
+	_, err = Import(ctx, client, strings.NewReader(`{"path":"User/alice","fields":{}}`))
+	assert.ErrorAs(t, err, &perr)
+
+	view, err := newTestClient(t).AtReadTime(time.Now())
+	require.NoError(t, err)
+	defer view.Close()
+	_, err = Import(ctx, view, strings.NewReader(`{"path":"Post/1","fields":{}}`))
+	assert.ErrorAs(t, err, &perr)
+}
+
+func (s *DumpTestSuite) TestExportImport() {
+	ctx := context.Background()
+	s.setupDocuments()
+	_, err := s.SimplestoreClient.Set(ctx, &Bookmark{
+		ID:   "b1",
+		Post: s.SimplestoreClient.GetDocumentRef(&Post{Parent: &User{ID: "alice"}, ID: "1"}),
+	})
+	s.Require().NoError(err)
+
+	var buf bytes.Buffer
+	_, err = Export(ctx, s.SimplestoreClient, &buf, Collection("User"), WithRecursive())
+	s.Require().NoError(err)
+	_, err = Export(ctx, s.SimplestoreClient, &buf, Collection("Bookmark"))
+	s.Require().NoError(err)
+	exported := buf.String()
+
+	// import to other collections with small batches
+	n, err := Import(
+		ctx,
+		s.SimplestoreClient,
+		strings.NewReader(exported),
+		WithBatchSize(2),
+		WithPathRewrite("User/", "ArchivedUser/"),
+		WithPathRewrite("Bookmark/", "ArchivedBookmark/"),
+	)
+	s.Require().NoError(err)
+	s.Equal(7, n)
+
+	s.SimplestoreClient.AddTableMaps(map[string]string{
+		"User":     "ArchivedUser",
+		"Bookmark": "ArchivedBookmark",
+	})
+	defer s.SimplestoreClient.AddTableMaps(map[string]string{
+		"User":     "User",
+		"Bookmark": "Bookmark",
+	})
+	user := &User{ID: "alice"}
+	s.Require().NoError(s.SimplestoreClient.Get(ctx, user))
+	s.Equal("Alice", user.Name)
+	post := &Post{Parent: user, ID: "2"}
+	s.Require().NoError(s.SimplestoreClient.Get(ctx, post))
+	s.Equal("World", post.Title)
+	orphan := &Post{Parent: &User{ID: "carol"}, ID: "1"}
+	s.Require().NoError(s.SimplestoreClient.Get(ctx, orphan))
+	s.Equal("Orphan", orphan.Title)
+	s.Equal(codes.NotFound, status.Code(s.SimplestoreClient.Get(ctx, &User{ID: "carol"})))
+
+	// references are rewritten
+	var bookmarks []*Bookmark
+	s.Require().NoError(s.SimplestoreClient.Query(&bookmarks).GetAll(ctx))
+	s.Require().Len(bookmarks, 1)
+	s.Equal(
+		s.SimplestoreClient.GetDocumentRef(&Post{Parent: &User{ID: "alice"}, ID: "1"}).Path,
+		bookmarks[0].Post.Path,
+	)
+}
+
+func (s *DumpTestSuite) TestImportDryRunWritesNothing() {
+	ctx := context.Background()
+	n, err := Import(ctx, s.SimplestoreClient, strings.NewReader(`{"path":"User/alice","fields":{}}`), WithDryRun())
+	s.Require().NoError(err)
+	s.Equal(1, n)
+	s.Equal(codes.NotFound, status.Code(s.SimplestoreClient.Get(ctx, &User{ID: "alice"})))
+}
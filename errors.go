@@ -1,6 +1,18 @@
 package simplestore
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNotFound indicates documents are not found
+// Test with `errors.Is(err, ErrNotFound)`.
+var ErrNotFound = errors.New("simplestore: document not found")
 
 // ProgrammingError indicates an error caused by specifying inappropriate value
 type ProgrammingError struct {
@@ -25,3 +37,28 @@ func NewProgrammingErrorf(format string, a ...any) *ProgrammingError {
 func (e *ProgrammingError) Error() string {
 	return e.msg
 }
+
+// NotFoundError indicates documents are not found
+// `status.Code(err)` also reports `codes.NotFound`.
+type NotFoundError struct {
+	Refs []*firestore.DocumentRef
+}
+
+// Error is an implementation for error
+func (e *NotFoundError) Error() string {
+	ids := make([]string, 0, len(e.Refs))
+	for _, ref := range e.Refs {
+		ids = append(ids, ref.ID)
+	}
+	return fmt.Sprintf("%v: %s", ErrNotFound, strings.Join(ids, ", "))
+}
+
+// Is reports NotFoundError is ErrNotFound
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// GRPCStatus returns the gRPC status for `status.Code()`
+func (e *NotFoundError) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, e.Error())
+}
@@ -0,0 +1,31 @@
+package simplestore
+
+import "cloud.google.com/go/firestore"
+
+// GetAllResult is the result of `GetAllDetailed`
+type GetAllResult struct {
+	// Found is the slice of found objects in the input order.
+	// The type is same to the input.
+	Found any
+	// Missing is the list of references of missing documents in the input order.
+	Missing []*firestore.DocumentRef
+	// MissingIndexes is the list of indexes in the input for Missing.
+	MissingIndexes []int
+	// Skipped is the list of indexes of nil entries in the input.
+	Skipped []int
+}
+
+type getAllConfig struct {
+	requireAll bool
+}
+
+// GetAllOption configures `GetAllDetailed`
+type GetAllOption func(*getAllConfig)
+
+// RequireAll makes missing documents an error
+// `GetAllDetailed` returns `*NotFoundError` listing missing documents with the result.
+func RequireAll() GetAllOption {
+	return func(c *getAllConfig) {
+		c.requireAll = true
+	}
+}
@@ -0,0 +1,277 @@
+package simplestore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// HistoryCollection is the subcollection to store previous versions of documents
+	// Versions are stored as `<document>/_history/<version>`.
+	HistoryCollection = "_history"
+	// fields of version documents in addition to fields of the document
+	historyVersionField   = "_version"
+	historyTimeField      = "_time"
+	historyOperationField = "_operation"
+)
+
+type historyConfig struct {
+	maxVersions int
+	maxAge      time.Duration
+}
+
+// HistoryOption configures history of a type
+type HistoryOption func(*historyConfig)
+
+// WithHistoryMaxVersions specifies the max number of versions kept for a document
+// Older versions are deleted on writes. Unlimited by default.
+func WithHistoryMaxVersions(n int) HistoryOption {
+	return func(c *historyConfig) {
+		c.maxVersions = n
+	}
+}
+
+// WithHistoryMaxAge specifies the max age of versions kept for a document
+// Older versions are deleted on writes. Unlimited by default.
+// Ages are measured with the server time, so the local clock doesn't matter.
+func WithHistoryMaxAge(d time.Duration) HistoryOption {
+	return func(c *historyConfig) {
+		c.maxAge = d
+	}
+}
+
+// HistoryVersion is a previous version of a document
+type HistoryVersion struct {
+	// Version is the version number starting from 1.
+	Version int64
+	// Time is the time the version is replaced.
+	Time time.Time
+	// Operation is the operation replacing the version.
+	Operation OperationKind
+	// Document is the content of the version.
+	// A pointer to the same type as the document.
+	Document any
+}
+
+// EnableHistory keeps previous versions of documents of the type on `Set` and `Delete`
+// o must be a pointer to a struct.
+func (c *Client) EnableHistory(o any, opts ...HistoryOption) error {
+	pt := reflect.TypeOf(o)
+	if _, err := newAccessor(pt, c.tableMaps); err != nil {
+		return err
+	}
+	config := &historyConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.maxVersions < 0 {
+		return NewProgrammingErrorf("max versions must not be negative: %d", config.maxVersions)
+	}
+	if config.maxAge < 0 {
+		return NewProgrammingErrorf("max age must not be negative: %v", config.maxAge)
+	}
+	if c.historyTypes == nil {
+		c.historyTypes = make(map[reflect.Type]*historyConfig)
+	}
+	c.historyTypes[pt.Elem()] = config
+	return nil
+}
+
+func (c *Client) historyConfig(a *accessor) *historyConfig {
+	return c.historyTypes[a.t]
+}
+
+// prepareHistory reads the current document and versions, and returns the function to write a new version
+// Must be called in a transaction before other writes.
+// The function is staged with the write in `RunTransaction`.
+// Returns nil if the document doesn't exist.
+func (c *Client) prepareHistory(kind OperationKind, config *historyConfig, doc *firestore.DocumentRef) (func() error, error) {
+	tx := c.FirestoreTransaction
+	docsnap, err := tx.Get(doc)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+	if !docsnap.Exists() {
+		return nil, nil
+	}
+
+	versions := doc.Collection(HistoryCollection)
+	query := versions.OrderBy(historyVersionField, firestore.Desc)
+	if config.maxVersions > 0 {
+		// the latest and versions to keep
+		query = query.Limit(config.maxVersions + 1)
+	} else {
+		query = query.Limit(1)
+	}
+	latest, err := tx.Documents(query).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	var expired []*firestore.DocumentRef
+	if config.maxVersions > 0 && len(latest) >= config.maxVersions {
+		for _, s := range latest[config.maxVersions-1:] {
+			expired = append(expired, s.Ref)
+		}
+	}
+	if config.maxAge > 0 {
+		// versions have server timestamps: compare with the server time rather than the local clock
+		cutoff := docsnap.ReadTime.Add(-config.maxAge)
+		old, err := tx.Documents(versions.Where(historyTimeField, "<", cutoff)).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range old {
+			expired = append(expired, s.Ref)
+		}
+	}
+
+	var version int64 = 1
+	if len(latest) > 0 {
+		if v, err := latest[0].DataAt(historyVersionField); err == nil {
+			if n, ok := v.(int64); ok {
+				version = n + 1
+			}
+		}
+	}
+	data := docsnap.Data()
+	data[historyVersionField] = version
+	data[historyTimeField] = firestore.ServerTimestamp
+	data[historyOperationField] = string(kind)
+
+	return func() error {
+		deleted := make(map[string]bool, len(expired))
+		for _, ref := range expired {
+			if deleted[ref.Path] {
+				continue
+			}
+			deleted[ref.Path] = true
+			if err := tx.Delete(ref); err != nil {
+				return err
+			}
+		}
+		return tx.Create(versions.Doc(versionID(version)), data)
+	}, nil
+}
+
+// versionID returns the ID of the version document sorted by versions
+func versionID(version int64) string {
+	return fmt.Sprintf("%010d", version)
+}
+
+// History returns previous versions of the document from the latest
+// o must be a pointer to a struct.
+// Versions are kept for types configured with `EnableHistory`.
+func (c *Client) History(ctx context.Context, o any) ([]*HistoryVersion, error) {
+	doc, err := c.GetDocumentRefSafe(o)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, NewProgrammingError("object is nil")
+	}
+	query := doc.Collection(HistoryCollection).OrderBy(historyVersionField, firestore.Desc)
+	var iter *firestore.DocumentIterator
+	if c.FirestoreTransaction != nil {
+		iter = c.FirestoreTransaction.Documents(query)
+	} else {
+		iter = query.Documents(ctx)
+	}
+	defer iter.Stop()
+	var versions []*HistoryVersion
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		version, err := c.newHistoryVersion(ctx, o, docsnap)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// newHistoryVersion decodes the version document
+// The document is a copy of o with stored fields replaced, to keep ID and Parent.
+// Old schema versions are upgraded, but not written back as versions are kept as is.
+func (c *Client) newHistoryVersion(ctx context.Context, o any, docsnap *firestore.DocumentSnapshot) (*HistoryVersion, error) {
+	pv := reflect.New(reflect.TypeOf(o).Elem())
+	pv.Elem().Set(reflect.ValueOf(o).Elem())
+	t := pv.Elem().Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if firestoreFieldName(f) == "" || f.Name == ParentFieldName {
+			continue
+		}
+		pv.Elem().Field(i).SetZero()
+	}
+	if _, err := c.upgradedDataTo(ctx, docsnap, pv.Interface()); err != nil {
+		return nil, err
+	}
+	version := &HistoryVersion{
+		Document: pv.Interface(),
+	}
+	if v, err := docsnap.DataAt(historyVersionField); err == nil {
+		version.Version, _ = v.(int64)
+	}
+	if v, err := docsnap.DataAt(historyTimeField); err == nil {
+		version.Time, _ = v.(time.Time)
+	}
+	if v, err := docsnap.DataAt(historyOperationField); err == nil {
+		s, _ := v.(string)
+		version.Operation = OperationKind(s)
+	}
+	return version, nil
+}
+
+// Restore rolls back the document to the version
+// o must be a pointer to a struct, and is updated to the restored content.
+// The current content is kept as a new version, so restoring can be undone.
+// Returns an error with `codes.NotFound` if the version doesn't exist.
+func (c *Client) Restore(ctx context.Context, o any, version int64) error {
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+	doc, err := c.GetDocumentRefSafe(o)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return NewProgrammingError("object is nil")
+	}
+	if c.FirestoreTransaction == nil {
+		return c.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+			return tc.Restore(ctx, o, version)
+		})
+	}
+	docsnap, err := c.FirestoreTransaction.Get(doc.Collection(HistoryCollection).Doc(versionID(version)))
+	if err != nil {
+		return err
+	}
+	restored, err := c.newHistoryVersion(ctx, o, docsnap)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Set(ctx, restored.Document); err != nil {
+		return err
+	}
+	ov := reflect.ValueOf(o).Elem()
+	orig := reflect.New(ov.Type()).Elem()
+	orig.Set(ov)
+	ov.Set(reflect.ValueOf(restored.Document).Elem())
+	c.transactionFailureCallbacks = append(c.transactionFailureCallbacks, func() {
+		ov.Set(orig)
+	})
+	return nil
+}
@@ -0,0 +1,233 @@
+package simplestore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// clearHistory deletes versions left for deleted documents
+func clearHistory(t *testing.T, path string) {
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, getProjectID())
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, DeleteCollection(ctx, client, path+"/"+HistoryCollection, 100))
+}
+
+func TestEnableHistory(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	a, err := newAccessor(reflect.TypeOf(&MyDocument{}), client.tableMaps)
+	require.NoError(t, err)
+	assert.Nil(t, client.historyConfig(a))
+
+	require.NoError(t, client.EnableHistory(&MyDocument{}, WithHistoryMaxVersions(3), WithHistoryMaxAge(time.Hour)))
+	config := client.historyConfig(a)
+	require.NotNil(t, config)
+	assert.Equal(t, 3, config.maxVersions)
+	assert.Equal(t, time.Hour, config.maxAge)
+
+	// types with the same name in other packages don't have history
+	type MyDocument struct {
+		ID string
+	}
+	a, err = newAccessor(reflect.TypeOf(&MyDocument{}), client.tableMaps)
+	require.NoError(t, err)
+	assert.Nil(t, client.historyConfig(a))
+
+	assertProgrammingError(t, client.EnableHistory(packageMyDocument{}))
+	assertProgrammingError(t, client.EnableHistory(&packageMyDocument{}, WithHistoryMaxVersions(-1)))
+	assertProgrammingError(t, client.EnableHistory(&packageMyDocument{}, WithHistoryMaxAge(-time.Hour)))
+
+	_, err = client.History(ctx, &packageMyDocument{})
+	assertProgrammingError(t, err)
+	assertProgrammingError(t, client.Restore(ctx, &packageMyDocument{}, 1))
+}
+
+func TestVersionID(t *testing.T) {
+	assert.Equal(t, "0000000001", versionID(1))
+	assert.Less(t, versionID(9), versionID(10))
+}
+
+func TestHistory(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	clearHistory(t, "MyDocument/doc1")
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.EnableHistory(&MyDocument{}))
+
+	doc := &MyDocument{ID: "doc1", Name: "v1"}
+	_, err = client.Create(ctx, doc)
+	require.NoError(t, err)
+	doc.Name = "v2"
+	_, err = client.Set(ctx, doc)
+	require.NoError(t, err)
+	doc.Name = "v3"
+	_, err = client.Set(ctx, doc)
+	require.NoError(t, err)
+	_, err = client.Delete(ctx, doc)
+	require.NoError(t, err)
+
+	versions, err := client.History(ctx, &MyDocument{ID: "doc1"})
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	assert.Equal(t, int64(3), versions[0].Version)
+	assert.Equal(t, OperationDelete, versions[0].Operation)
+	assert.Equal(t, &MyDocument{ID: "doc1", Name: "v3"}, versions[0].Document)
+	assert.False(t, versions[0].Time.IsZero())
+	assert.Equal(t, int64(2), versions[1].Version)
+	assert.Equal(t, OperationSet, versions[1].Operation)
+	assert.Equal(t, &MyDocument{ID: "doc1", Name: "v2"}, versions[1].Document)
+	assert.Equal(t, int64(1), versions[2].Version)
+	assert.Equal(t, &MyDocument{ID: "doc1", Name: "v1"}, versions[2].Document)
+
+	// restore the deleted document
+	restored := &MyDocument{ID: "doc1"}
+	require.NoError(t, client.Restore(ctx, restored, 2))
+	assert.Equal(t, &MyDocument{ID: "doc1", Name: "v2"}, restored)
+	got := &MyDocument{ID: "doc1"}
+	require.NoError(t, client.Get(ctx, got))
+	assert.Equal(t, "v2", got.Name)
+
+	// restoring keeps the current version
+	require.NoError(t, client.Restore(ctx, restored, 1))
+	assert.Equal(t, "v1", restored.Name)
+	versions, err = client.History(ctx, restored)
+	require.NoError(t, err)
+	require.Len(t, versions, 4)
+	assert.Equal(t, &MyDocument{ID: "doc1", Name: "v2"}, versions[0].Document)
+
+	err = client.Restore(ctx, restored, 10)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "v1", restored.Name)
+}
+
+func TestHistoryWithParent(t *testing.T) {
+	clearAllDocuments(t, &ParentDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.EnableHistory(&ChildDocument{}))
+
+	parent := &ParentDocument{ID: "parent1"}
+	_, err = client.Set(ctx, parent)
+	require.NoError(t, err)
+	child := &ChildDocument{Parent: parent, ID: "child1", Name: "v1"}
+	_, err = client.Set(ctx, child)
+	require.NoError(t, err)
+	child.Name = "v2"
+	_, err = client.Set(ctx, child)
+	require.NoError(t, err)
+
+	versions, err := client.History(ctx, child)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Same(t, parent, versions[0].Document.(*ChildDocument).Parent)
+	assert.Equal(t, "v1", versions[0].Document.(*ChildDocument).Name)
+}
+
+func TestHistoryRetention(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	clearHistory(t, "MyDocument/doc1")
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.EnableHistory(&MyDocument{}, WithHistoryMaxVersions(2)))
+
+	doc := &MyDocument{ID: "doc1"}
+	for _, name := range []string{"v1", "v2", "v3", "v4"} {
+		doc.Name = name
+		_, err = client.Set(ctx, doc)
+		require.NoError(t, err)
+	}
+	versions, err := client.History(ctx, doc)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, int64(3), versions[0].Version)
+	assert.Equal(t, "v3", versions[0].Document.(*MyDocument).Name)
+	assert.Equal(t, int64(2), versions[1].Version)
+	assert.Equal(t, "v2", versions[1].Document.(*MyDocument).Name)
+}
+
+func TestHistoryInTransaction(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	clearHistory(t, "MyDocument/doc1")
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.EnableHistory(&MyDocument{}))
+
+	doc := &MyDocument{ID: "doc1", Name: "v1"}
+	_, err = client.Set(ctx, doc)
+	require.NoError(t, err)
+
+	errRollback := errors.New("rollback")
+	err = client.RunTransaction(ctx, func(ctx context.Context, client *Client) error {
+		if _, err := client.Set(ctx, &MyDocument{ID: "doc1", Name: "v2"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+	versions, err := client.History(ctx, doc)
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+}
+
+func TestHistoryMultipleWritesInTransaction(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	clearHistory(t, "MyDocument/doc1")
+	clearHistory(t, "MyDocument/doc2")
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.EnableHistory(&MyDocument{}))
+
+	doc1 := &MyDocument{ID: "doc1", Name: "v1"}
+	_, err = client.Set(ctx, doc1)
+	require.NoError(t, err)
+	doc2 := &MyDocument{ID: "doc2", Name: "v1"}
+	_, err = client.Set(ctx, doc2)
+	require.NoError(t, err)
+
+	// reads for the second write are before writes
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		if _, err := tc.Set(ctx, &MyDocument{ID: "doc1", Name: "v2"}); err != nil {
+			return err
+		}
+		_, err := tc.Delete(ctx, &MyDocument{ID: "doc2"})
+		return err
+	})
+	require.NoError(t, err)
+	versions, err := client.History(ctx, doc1)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, "v1", versions[0].Document.(*MyDocument).Name)
+	versions, err = client.History(ctx, doc2)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, OperationDelete, versions[0].Operation)
+
+	// the second write would reuse the version number of the first write
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		if _, err := tc.Set(ctx, &MyDocument{ID: "doc1", Name: "v3"}); err != nil {
+			return err
+		}
+		_, err := tc.Set(ctx, &MyDocument{ID: "doc1", Name: "v4"})
+		return err
+	})
+	assertProgrammingError(t, err)
+}
@@ -0,0 +1,252 @@
+/*
+Package lock provides distributed locks backed by firestore documents.
+
+	locker := lock.New(client, lock.WithTTL(30*time.Second))
+	l, err := locker.TryLock(ctx, "daily-job")
+	if errors.Is(err, lock.ErrLocked) {
+		// another instance holds the lock
+	}
+	defer l.Release(ctx)
+
+A lock is a lease stored as a `Lease` document with the owner, the expiry and the fencing token.
+Use `AddTableMaps` to change the collection.
+Leases are acquired, renewed and released in transactions.
+An expired lease can be acquired by another owner, so renew leases before they expire
+and pass `Token()` to resources to reject writes from stale owners.
+Expiries are decided with local clocks: keep TTLs long enough for clock skews.
+*/
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/ikedam/simplestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultTTL is the default duration of leases
+	DefaultTTL = 30 * time.Second
+	// DefaultRetryInterval is the default interval to retry acquiring locks in `Lock`
+	DefaultRetryInterval = 1 * time.Second
+)
+
+var (
+	// ErrLocked indicates the lock is held by another owner
+	ErrLocked = errors.New("lock: already locked")
+	// ErrLost indicates the lease is expired and acquired by another owner, or released
+	ErrLost = errors.New("lock: lease is lost")
+)
+
+// Lease is the document of a lock
+// ID is the name of the lock.
+type Lease struct {
+	ID string
+	// Owner is the owner holding the lease. Empty when released.
+	Owner string
+	// Token is the fencing token incremented for each acquisition.
+	Token int64
+	// Expires is the time the lease expires.
+	Expires time.Time
+}
+
+type config struct {
+	owner         string
+	ttl           time.Duration
+	retryInterval time.Duration
+	now           func() time.Time
+}
+
+// Option configures Locker
+type Option func(*config)
+
+// WithOwner specifies the owner of leases
+// Defaults to the host name with a random suffix.
+func WithOwner(owner string) Option {
+	return func(c *config) {
+		c.owner = owner
+	}
+}
+
+// WithTTL specifies the duration of leases
+// Defaults to DefaultTTL.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.ttl = ttl
+	}
+}
+
+// WithRetryInterval specifies the interval to retry acquiring locks in `Lock`
+// Defaults to DefaultRetryInterval.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.retryInterval = interval
+	}
+}
+
+// Locker acquires locks
+type Locker struct {
+	config
+	client *simplestore.Client
+}
+
+// Lock is an acquired lock
+type Lock struct {
+	locker  *Locker
+	name    string
+	token   int64
+	expires time.Time
+}
+
+// New returns a new Locker
+func New(client *simplestore.Client, opts ...Option) *Locker {
+	l := &Locker{
+		config: config{
+			ttl:           DefaultTTL,
+			retryInterval: DefaultRetryInterval,
+			now:           time.Now,
+		},
+		client: client,
+	}
+	for _, opt := range opts {
+		opt(&l.config)
+	}
+	if l.owner == "" {
+		l.owner = defaultOwner()
+	}
+	return l
+}
+
+func defaultOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// Owner returns the owner of leases
+func (l *Locker) Owner() string {
+	return l.owner
+}
+
+// TryLock acquires the lock without waiting
+// Returns ErrLocked if the lock is held by another owner and not expired.
+// Locks are not reentrant: ErrLocked is returned also for the same owner.
+func (l *Locker) TryLock(ctx context.Context, name string) (*Lock, error) {
+	lease := &Lease{ID: name}
+	err := l.client.RunTransaction(ctx, func(ctx context.Context, client *simplestore.Client) error {
+		current := &Lease{ID: name}
+		err := client.Get(ctx, current)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		now := l.now()
+		if err == nil && current.Owner != "" && now.Before(current.Expires) {
+			return ErrLocked
+		}
+		// steal expired leases
+		*lease = Lease{
+			ID:      name,
+			Owner:   l.owner,
+			Token:   current.Token + 1,
+			Expires: now.Add(l.ttl),
+		}
+		_, err = client.Set(ctx, lease)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{
+		locker:  l,
+		name:    name,
+		token:   lease.Token,
+		expires: lease.Expires,
+	}, nil
+}
+
+// Lock acquires the lock waiting until the lock is released or expired
+// Returns the error of ctx if ctx is done before acquiring.
+func (l *Locker) Lock(ctx context.Context, name string) (*Lock, error) {
+	for {
+		lock, err := l.TryLock(ctx, name)
+		if !errors.Is(err, ErrLocked) {
+			return lock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(l.retryInterval):
+		}
+	}
+}
+
+// Name returns the name of the lock
+func (lk *Lock) Name() string {
+	return lk.name
+}
+
+// Token returns the fencing token
+// Tokens increase for each acquisition of the lock.
+func (lk *Lock) Token() int64 {
+	return lk.token
+}
+
+// Expires returns the time the lease expires
+func (lk *Lock) Expires() time.Time {
+	return lk.expires
+}
+
+// Renew extends the lease by the TTL
+// Returns ErrLost if the lease is acquired by another owner or released.
+// An expired lease can be renewed if not acquired by another owner.
+func (lk *Lock) Renew(ctx context.Context) error {
+	var expires time.Time
+	err := lk.update(ctx, func(lease *Lease) {
+		expires = lk.locker.now().Add(lk.locker.ttl)
+		lease.Expires = expires
+	})
+	if err != nil {
+		return err
+	}
+	lk.expires = expires
+	return nil
+}
+
+// Release releases the lease
+// Returns ErrLost if the lease is acquired by another owner or already released.
+// The lease document is kept to keep fencing tokens increasing.
+func (lk *Lock) Release(ctx context.Context) error {
+	return lk.update(ctx, func(lease *Lease) {
+		lease.Owner = ""
+		lease.Expires = time.Time{}
+	})
+}
+
+func (lk *Lock) update(ctx context.Context, f func(lease *Lease)) error {
+	return lk.locker.client.RunTransaction(ctx, func(ctx context.Context, client *simplestore.Client) error {
+		lease := &Lease{ID: lk.name}
+		err := client.Get(ctx, lease)
+		if status.Code(err) == codes.NotFound {
+			return ErrLost
+		}
+		if err != nil {
+			return err
+		}
+		if lease.Owner != lk.locker.owner || lease.Token != lk.token {
+			return ErrLost
+		}
+		f(lease)
+		_, err = client.Set(ctx, lease)
+		return err
+	})
+}
@@ -0,0 +1,144 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ikedam/simplestore/simplestoretest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type LockTestSuite struct {
+	simplestoretest.FirestoreTestSuite
+}
+
+func TestLockTestSuite(t *testing.T) {
+	suite.Run(t, new(LockTestSuite))
+}
+
+func TestNew(t *testing.T) {
+	l := New(nil)
+	assert.NotEmpty(t, l.Owner())
+	assert.NotEqual(t, l.Owner(), New(nil).Owner())
+	assert.Equal(t, DefaultTTL, l.ttl)
+	assert.Equal(t, DefaultRetryInterval, l.retryInterval)
+
+	l = New(nil, WithOwner("owner"), WithTTL(time.Minute), WithRetryInterval(time.Millisecond))
+	assert.Equal(t, "owner", l.Owner())
+	assert.Equal(t, time.Minute, l.ttl)
+	assert.Equal(t, time.Millisecond, l.retryInterval)
+}
+
+func (s *LockTestSuite) TestTryLock() {
+	ctx := context.Background()
+	locker1 := New(s.SimplestoreClient, WithOwner("owner1"))
+	locker2 := New(s.SimplestoreClient, WithOwner("owner2"))
+
+	lock1, err := locker1.TryLock(ctx, "job")
+	s.Require().NoError(err)
+	s.Equal(int64(1), lock1.Token())
+
+	_, err = locker2.TryLock(ctx, "job")
+	s.ErrorIs(err, ErrLocked)
+	// not reentrant
+	_, err = locker1.TryLock(ctx, "job")
+	s.ErrorIs(err, ErrLocked)
+
+	// other locks are independent
+	_, err = locker2.TryLock(ctx, "other")
+	s.Require().NoError(err)
+
+	s.Require().NoError(lock1.Release(ctx))
+	s.ErrorIs(lock1.Release(ctx), ErrLost)
+
+	lock2, err := locker2.TryLock(ctx, "job")
+	s.Require().NoError(err)
+	// fencing tokens increase
+	s.Equal(int64(2), lock2.Token())
+}
+
+func (s *LockTestSuite) TestStealOnExpiry() {
+	ctx := context.Background()
+	now := time.Now()
+	locker1 := New(s.SimplestoreClient, WithOwner("owner1"), WithTTL(time.Minute))
+	locker1.now = func() time.Time { return now }
+	locker2 := New(s.SimplestoreClient, WithOwner("owner2"), WithTTL(time.Minute))
+	locker2.now = func() time.Time { return now.Add(30 * time.Second) }
+
+	lock1, err := locker1.TryLock(ctx, "job")
+	s.Require().NoError(err)
+	_, err = locker2.TryLock(ctx, "job")
+	s.ErrorIs(err, ErrLocked)
+
+	// renewed lease is still locked
+	locker1.now = func() time.Time { return now.Add(50 * time.Second) }
+	s.Require().NoError(lock1.Renew(ctx))
+	s.Equal(now.Add(110*time.Second), lock1.Expires())
+	locker2.now = func() time.Time { return now.Add(100 * time.Second) }
+	_, err = locker2.TryLock(ctx, "job")
+	s.ErrorIs(err, ErrLocked)
+
+	// expired lease is stolen
+	locker2.now = func() time.Time { return now.Add(120 * time.Second) }
+	lock2, err := locker2.TryLock(ctx, "job")
+	s.Require().NoError(err)
+	s.Greater(lock2.Token(), lock1.Token())
+
+	s.ErrorIs(lock1.Renew(ctx), ErrLost)
+	s.ErrorIs(lock1.Release(ctx), ErrLost)
+	s.NoError(lock2.Release(ctx))
+}
+
+func (s *LockTestSuite) TestLockWaits() {
+	ctx := context.Background()
+	locker1 := New(s.SimplestoreClient, WithOwner("owner1"))
+	locker2 := New(s.SimplestoreClient, WithOwner("owner2"), WithRetryInterval(10*time.Millisecond))
+
+	lock1, err := locker1.TryLock(ctx, "job")
+	s.Require().NoError(err)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = locker2.Lock(timeoutCtx, "job")
+	s.ErrorIs(err, context.DeadlineExceeded)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var lock2 *Lock
+	go func() {
+		defer wg.Done()
+		lock2, err = locker2.Lock(ctx, "job")
+	}()
+	time.Sleep(30 * time.Millisecond)
+	s.Require().NoError(lock1.Release(ctx))
+	wg.Wait()
+	s.Require().NoError(err)
+	s.Equal("job", lock2.Name())
+}
+
+func (s *LockTestSuite) TestMutualExclusion() {
+	ctx := context.Background()
+	acquired := 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := New(s.SimplestoreClient).TryLock(ctx, "job")
+			if err == nil {
+				mu.Lock()
+				acquired++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrLocked) {
+				s.Fail("unexpected error", err)
+			}
+		}()
+	}
+	wg.Wait()
+	s.Equal(1, acquired)
+}
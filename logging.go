@@ -0,0 +1,365 @@
+//go:build go1.21
+
+package simplestore
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// RedactedValue is logged in place of values of fields tagged with `simplestore:"redact"`
+const RedactedValue = "[REDACTED]"
+
+type logConfig struct {
+	level      slog.Level
+	errorLevel slog.Level
+	sampleRate float64
+	logData    bool
+}
+
+// LogOption configures logging of operations
+type LogOption func(*logConfig)
+
+// WithLogLevel specifies the level to log succeeded operations
+// Defaults to `slog.LevelDebug`.
+func WithLogLevel(level slog.Level) LogOption {
+	return func(c *logConfig) {
+		c.level = level
+	}
+}
+
+// WithLogErrorLevel specifies the level to log failed operations
+// Defaults to `slog.LevelError`.
+func WithLogErrorLevel(level slog.Level) LogOption {
+	return func(c *logConfig) {
+		c.errorLevel = level
+	}
+}
+
+// WithLogSampleRate specifies the ratio (0.0 - 1.0) of succeeded operations to log
+// Failed operations are always logged.
+// Defaults to 1.0 (logs all operations).
+func WithLogSampleRate(rate float64) LogOption {
+	return func(c *logConfig) {
+		c.sampleRate = rate
+	}
+}
+
+// WithLogDocumentData logs fields of documents for Get, Create and Set
+// Fields tagged with `simplestore:"redact"` are logged as `[REDACTED]`.
+func WithLogDocumentData() LogOption {
+	return func(c *logConfig) {
+		c.logData = true
+	}
+}
+
+// UseLogger logs operations of the client with logger
+// Logs the operation, the collection, the document path, query conditions, the duration and the error.
+// Values for fields tagged with `simplestore:"redact"` are logged as `[REDACTED]`.
+func (c *Client) UseLogger(logger *slog.Logger, opts ...LogOption) {
+	c.Use(LoggingMiddleware(logger, opts...))
+}
+
+// LoggingMiddleware returns a middleware to log operations
+// See `UseLogger` for details.
+func LoggingMiddleware(logger *slog.Logger, opts ...LogOption) Middleware {
+	cfg := &logConfig{
+		level:      slog.LevelDebug,
+		errorLevel: slog.LevelError,
+		sampleRate: 1.0,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return func(next Handler) Handler {
+		return func(ctx context.Context, op *Operation) (any, error) {
+			start := time.Now()
+			result, err := next(ctx, op)
+			duration := time.Since(start)
+
+			level := cfg.level
+			if err != nil {
+				level = cfg.errorLevel
+			} else if cfg.sampleRate < 1.0 && rand.Float64() >= cfg.sampleRate {
+				return result, err
+			}
+			if !logger.Enabled(ctx, level) {
+				return result, err
+			}
+			attrs := operationLogAttrs(op, cfg)
+			attrs = append(attrs, slog.Duration("duration", duration))
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+			logger.LogAttrs(ctx, level, "simplestore "+string(op.Kind), attrs...)
+			return result, err
+		}
+	}
+}
+
+func operationLogAttrs(op *Operation, cfg *logConfig) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("operation", string(op.Kind)),
+	}
+	if op.Collection != "" {
+		attrs = append(attrs, slog.String("collection", op.Collection))
+	}
+	if op.Path != "" {
+		attrs = append(attrs, slog.String("path", op.Path))
+	}
+	if op.Paths != nil {
+		attrs = append(attrs, slog.Any("paths", op.Paths))
+	}
+	if op.InTransaction || op.Kind == OperationTransaction {
+		attrs = append(attrs, slog.Int("attempt", op.Attempt))
+	}
+	if q, ok := op.Target.(*Query); ok {
+		attrs = append(attrs, slog.String("query", formatConditions(q.conditions, q.tb.elementType)))
+	}
+	switch op.Kind {
+	case OperationGet, OperationGetAll:
+		attrs = append(attrs, slog.Int("found", op.ResultCount), slog.Int("missing", op.MissingCount))
+	case OperationQuery, OperationCount:
+		attrs = append(attrs, slog.Int("count", op.ResultCount))
+	}
+	if cfg.logData {
+		switch op.Kind {
+		case OperationGet, OperationCreate, OperationSet:
+			attrs = append(attrs, slog.Attr{Key: "data", Value: documentLogValue(op.Target)})
+		}
+	}
+	return attrs
+}
+
+// isRedactedPath returns whether the field path of documents of t is tagged with `simplestore:"redact"`
+// `Profile.Email` is redacted when `Profile` or `Email` in `Profile` is tagged.
+func isRedactedPath(t reflect.Type, path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Map:
+			// the name is the key
+			t = t.Elem()
+			continue
+		case reflect.Struct:
+		default:
+			return false
+		}
+		f, ok := lookupStoredField(t, name)
+		if !ok {
+			return false
+		}
+		if hasTagOption(f, "redact") {
+			return true
+		}
+		t = f.Type
+	}
+	return false
+}
+
+// lookupStoredField returns the field stored as name including ones of embedded structs
+// Returns the embedded field itself if it's tagged with `simplestore:"redact"`.
+func lookupStoredField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if isEmbeddedStruct(f) {
+			if found, ok := lookupStoredField(indirectType(f.Type), name); ok {
+				if hasTagOption(f, "redact") {
+					return f, true
+				}
+				return found, true
+			}
+			continue
+		}
+		if firestoreFieldName(f) == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// isEmbeddedStruct returns whether fields of f are promoted to the parent in firestore documents
+func isEmbeddedStruct(f reflect.StructField) bool {
+	tagName, _, _ := strings.Cut(f.Tag.Get("firestore"), ",")
+	return f.Anonymous && tagName == "" && indirectType(f.Type).Kind() == reflect.Struct
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+func isCursorMethod(method string) bool {
+	switch method {
+	case "StartAt", "StartAfter", "EndAt", "EndBefore":
+		return true
+	}
+	return false
+}
+
+func formatConditions(conditions []QueryCondition, t reflect.Type) string {
+	// cursor values are values of fields for OrderBy
+	redactCursors := false
+	for _, cond := range conditions {
+		if cond.Method == "OrderBy" && isRedactedPath(t, cond.Path) {
+			redactCursors = true
+		}
+	}
+	formatted := make([]string, 0, len(conditions))
+	for _, cond := range conditions {
+		redacted := (cond.Path != "" && isRedactedPath(t, cond.Path)) ||
+			(redactCursors && isCursorMethod(cond.Method))
+		values := make([]string, 0, len(cond.Values))
+		for _, v := range cond.Values {
+			if docsnap, ok := v.(*firestore.DocumentSnapshot); ok {
+				// never log fields of snapshots
+				values = append(values, formatSnapshotCursor(docsnap))
+			} else if redacted {
+				values = append(values, RedactedValue)
+			} else {
+				values = append(values, fmt.Sprintf("%#v", v))
+			}
+		}
+		args := make([]string, 0, 2+len(values))
+		if cond.Path != "" {
+			args = append(args, cond.Path, cond.Op)
+		}
+		args = append(args, values...)
+		formatted = append(formatted, fmt.Sprintf("%s(%s)", cond.Method, strings.Join(args, " ")))
+	}
+	return strings.Join(formatted, " ")
+}
+
+func formatSnapshotCursor(docsnap *firestore.DocumentSnapshot) string {
+	if docsnap == nil || docsnap.Ref == nil {
+		return "DocumentSnapshot(nil)"
+	}
+	return fmt.Sprintf("DocumentSnapshot(%s)", relativePath(docsnap.Ref.Path))
+}
+
+func documentLogValue(o any) slog.Value {
+	pv := reflect.ValueOf(o)
+	if pv.Kind() != reflect.Pointer || pv.IsNil() || pv.Elem().Kind() != reflect.Struct {
+		return slog.AnyValue(nil)
+	}
+	fields := map[string]any{}
+	collectLogFields(pv.Elem(), fields)
+	attrs := make([]slog.Attr, 0, len(fields))
+	for name, value := range fields {
+		attrs = append(attrs, slog.Any(name, value))
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].Key < attrs[j].Key
+	})
+	return slog.GroupValue(attrs...)
+}
+
+// collectLogFields collects stored fields of the struct v with values redacted
+func collectLogFields(v reflect.Value, fields map[string]any) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if isEmbeddedStruct(f) {
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			embedded := map[string]any{}
+			collectLogFields(fv, embedded)
+			for name, value := range embedded {
+				if hasTagOption(f, "redact") {
+					value = RedactedValue
+				}
+				fields[name] = value
+			}
+			continue
+		}
+		name := firestoreFieldName(f)
+		if name == "" || f.Name == ParentFieldName {
+			continue
+		}
+		if hasTagOption(f, "redact") {
+			fields[name] = RedactedValue
+			continue
+		}
+		fields[name] = logValue(v.Field(i))
+	}
+}
+
+// logValue returns the value to log with fields of nested structs redacted
+func logValue(v reflect.Value) any {
+	if !v.CanInterface() {
+		return nil
+	}
+	if ref, ok := v.Interface().(*firestore.DocumentRef); ok {
+		if ref == nil {
+			return nil
+		}
+		return relativePath(ref.Path)
+	}
+	if _, ok := v.Interface().(slog.LogValuer); ok {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return logValue(v.Elem())
+	case reflect.Struct:
+		if !hasStoredFields(v.Type()) {
+			// e.g. time.Time
+			return v.Interface()
+		}
+		fields := map[string]any{}
+		collectLogFields(v, fields)
+		return fields
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		values := make([]any, v.Len())
+		for i := range values {
+			values[i] = logValue(v.Index(i))
+		}
+		return values
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		values := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			values[fmt.Sprint(iter.Key().Interface())] = logValue(iter.Value())
+		}
+		return values
+	}
+	return v.Interface()
+}
+
+func hasStoredFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if isEmbeddedStruct(t.Field(i)) || firestoreFieldName(t.Field(i)) != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+//go:build go1.21
+
+package simplestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type TestSensitiveDoc struct {
+	ID       string
+	Name     string
+	Email    string `firestore:"email" simplestore:"redact"`
+	Password string `simplestore:"redact"`
+}
+
+type TestSensitiveProfile struct {
+	Nickname string
+	Phone    string `simplestore:"redact"`
+}
+
+type TestSensitiveNestedDoc struct {
+	ID       string
+	Profile  TestSensitiveProfile
+	Contacts []*TestSensitiveProfile
+	Secret   *TestSensitiveProfile `simplestore:"redact"`
+}
+
+func newTestLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func parseLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var logs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		logs = append(logs, entry)
+	}
+	return logs
+}
+
+func TestUseLogger(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	client.UseLogger(newTestLogger(&buf), WithLogDocumentData())
+	errFailed := errors.New("failed")
+	client.Use(func(next Handler) Handler {
+		return func(ctx context.Context, op *Operation) (any, error) {
+			if op.Kind == OperationSet {
+				return nil, errFailed
+			}
+			return nil, nil
+		}
+	})
+
+	var docs []*TestSensitiveDoc
+	err = client.Query(&docs).Where("Name", "==", "Alice").Where("email", "==", "alice@example.com").Limit(1).GetAll(ctx)
+	require.NoError(t, err)
+	_, err = client.Set(ctx, &TestSensitiveDoc{ID: "docid", Name: "Alice", Email: "alice@example.com", Password: "secret"})
+	require.ErrorIs(t, err, errFailed)
+
+	logs := parseLogs(t, &buf)
+	require.Len(t, logs, 2)
+
+	assert.Equal(t, "DEBUG", logs[0]["level"])
+	assert.Equal(t, "simplestore Query", logs[0]["msg"])
+	assert.Equal(t, "TestSensitiveDoc", logs[0]["collection"])
+	assert.Equal(t, `Where(Name == "Alice") Where(email == [REDACTED]) Limit(1)`, logs[0]["query"])
+	assert.Contains(t, logs[0], "duration")
+
+	assert.Equal(t, "ERROR", logs[1]["level"])
+	assert.Equal(t, "TestSensitiveDoc/docid", logs[1]["path"])
+	assert.Equal(t, "failed", logs[1]["error"])
+	assert.Equal(t, map[string]any{
+		"ID":       "docid",
+		"Name":     "Alice",
+		"email":    RedactedValue,
+		"Password": RedactedValue,
+	}, logs[1]["data"])
+}
+
+func TestUseLoggerSampling(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	client.UseLogger(newTestLogger(&buf), WithLogSampleRate(0), WithLogErrorLevel(slog.LevelWarn))
+	errFailed := errors.New("failed")
+	client.Use(func(next Handler) Handler {
+		return func(ctx context.Context, op *Operation) (any, error) {
+			if op.Path == "MyDocument/fail" {
+				return nil, errFailed
+			}
+			return nil, nil
+		}
+	})
+
+	require.NoError(t, client.Get(ctx, &MyDocument{ID: "docid"}))
+	require.ErrorIs(t, client.Get(ctx, &MyDocument{ID: "fail"}), errFailed)
+
+	// only failures are logged
+	logs := parseLogs(t, &buf)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "WARN", logs[0]["level"])
+	assert.Equal(t, "MyDocument/fail", logs[0]["path"])
+}
+
+func TestUseLoggerRedactsNestedFieldsAndCursors(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	client.UseLogger(newTestLogger(&buf), WithLogDocumentData())
+	client.Use(func(next Handler) Handler {
+		return func(ctx context.Context, op *Operation) (any, error) {
+			return nil, nil
+		}
+	})
+
+	var docs []*TestSensitiveDoc
+	err = client.Query(&docs).OrderBy("email", firestore.Asc).StartAfter("alice@example.com").GetAll(ctx)
+	require.NoError(t, err)
+	err = client.Query(&docs).OrderBy("Name", firestore.Asc).StartAfter("Alice").GetAll(ctx)
+	require.NoError(t, err)
+	docsnap := &firestore.DocumentSnapshot{Ref: client.FirestoreClient.Doc("TestSensitiveDoc/docid")}
+	err = client.Query(&docs).StartAt(docsnap).GetAll(ctx)
+	require.NoError(t, err)
+
+	var nestedDocs []*TestSensitiveNestedDoc
+	err = client.Query(&nestedDocs).Where("Profile.Phone", "==", "000").Where("Profile.Nickname", "==", "ali").GetAll(ctx)
+	require.NoError(t, err)
+	_, err = client.Set(ctx, &TestSensitiveNestedDoc{
+		ID:       "docid",
+		Profile:  TestSensitiveProfile{Nickname: "ali", Phone: "000"},
+		Contacts: []*TestSensitiveProfile{{Nickname: "bob", Phone: "111"}},
+		Secret:   &TestSensitiveProfile{Nickname: "secret"},
+	})
+	require.NoError(t, err)
+
+	logs := parseLogs(t, &buf)
+	require.Len(t, logs, 5)
+	assert.Equal(t, `OrderBy(email asc) StartAfter([REDACTED])`, logs[0]["query"])
+	assert.Equal(t, `OrderBy(Name asc) StartAfter("Alice")`, logs[1]["query"])
+	assert.Equal(t, `StartAt(DocumentSnapshot(TestSensitiveDoc/docid))`, logs[2]["query"])
+	assert.Equal(t, `Where(Profile.Phone == [REDACTED]) Where(Profile.Nickname == "ali")`, logs[3]["query"])
+	assert.Equal(t, map[string]any{
+		"ID":       "docid",
+		"Profile":  map[string]any{"Nickname": "ali", "Phone": RedactedValue},
+		"Contacts": []any{map[string]any{"Nickname": "bob", "Phone": RedactedValue}},
+		"Secret":   RedactedValue,
+	}, logs[4]["data"])
+}
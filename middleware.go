@@ -0,0 +1,120 @@
+package simplestore
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// OperationKind represents the kind of an operation passed to middlewares
+type OperationKind string
+
+const (
+	OperationGet         OperationKind = "Get"
+	OperationGetAll      OperationKind = "GetAll"
+	OperationCreate      OperationKind = "Create"
+	OperationSet         OperationKind = "Set"
+	OperationDelete      OperationKind = "Delete"
+	OperationQuery       OperationKind = "Query"
+	OperationCount       OperationKind = "Count"
+	OperationTransaction OperationKind = "Transaction"
+)
+
+// Operation describes an operation processed by middlewares
+type Operation struct {
+	Kind OperationKind
+
+	// Collection is the collection name resolved with table maps.
+	// Empty for transactions.
+	Collection string
+
+	// Path is the path of the document relative to the database root.
+	// e.g. `ParentDocument/p/ChildDocument/c`
+	// For queries, this is the path of the collection (empty for collection groups).
+	// Empty for GetAll and transactions.
+	Path string
+
+	// Paths is the list of paths of documents for GetAll.
+	// Nil objects are not included.
+	Paths []string
+
+	// InTransaction is true when the operation is performed in a transaction.
+	InTransaction bool
+
+	// ReadTime is the time to read documents at with `AtReadTime`.
+	// Zero for reading the latest documents.
+	ReadTime time.Time
+
+	// Attempt is the attempt number of the transaction starting from 1.
+	// For operations in a transaction, the attempt in which the operation is performed.
+	// For Transaction, the number of attempts performed (available after the operation).
+	// 0 for operations outside transactions.
+	Attempt int
+
+	// ReadOnly is true when the collection is configured as readonly with table maps.
+	// Write operations to readonly collections are rejected.
+	ReadOnly bool
+
+	// Target is the object passed to the operation.
+	// A pointer to a struct for Get, Create, Set and Delete.
+	// A slice of pointers to structs for GetAll.
+	// *Query for Query and Count.
+	Target any
+
+	// ResultCount is the number of found documents (available after the operation).
+	// For Count, the counted number.
+	ResultCount int
+
+	// MissingCount is the number of documents not found in Get and GetAll (available after the operation).
+	MissingCount int
+
+	// CachedCount is the number of documents (found or missing) served without reading firestore
+	// in Get and GetAll (available after the operation).
+	CachedCount int
+}
+
+// Handler processes an operation
+// Returns the result of the operation:
+// * a slice of found objects for GetAll
+// * *firestore.WriteResult for Create, Set and Delete
+// * int64 for Count
+// * nil for others
+type Handler func(ctx context.Context, op *Operation) (any, error)
+
+// Middleware wraps a Handler
+// A middleware can observe, modify results of or short-circuit operations.
+type Middleware func(next Handler) Handler
+
+// Use registers middlewares to the client
+// Middlewares are applied in the order of registration: the first one is the outermost.
+// Clients passed in `RunTransaction` inherit middlewares.
+func (c *Client) Use(middlewares ...Middleware) {
+	// avoid sharing the backing array with copies of the client
+	c.middlewares = append(c.middlewares[:len(c.middlewares):len(c.middlewares)], middlewares...)
+}
+
+func (c *Client) invoke(ctx context.Context, op *Operation, h Handler) (any, error) {
+	op.InTransaction = c.FirestoreTransaction != nil
+	op.ReadTime = c.readTime
+	op.Attempt = c.transactionAttempt
+	do := h
+	h = func(ctx context.Context, op *Operation) (any, error) {
+		result, err := do(ctx, op)
+		recordUsage(ctx, op, err)
+		return result, err
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i](h)
+	}
+	return h(ctx, op)
+}
+
+// relativePath returns the path relative to the database root
+// `projects/p/databases/d/documents/Collection/id` results `Collection/id`.
+func relativePath(path string) string {
+	_, rel, found := strings.Cut(path, "/documents/")
+	if !found {
+		return path
+	}
+	return rel
+}
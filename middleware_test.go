@@ -0,0 +1,171 @@
+package simplestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recordOperations(ops *[]Operation) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, op *Operation) (any, error) {
+			*ops = append(*ops, *op)
+			return next(ctx, op)
+		}
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	client.AddTableMaps(map[string]string{
+		"ChildDocument": "children",
+	})
+
+	errDenied := errors.New("denied")
+	var ops []Operation
+	client.Use(
+		recordOperations(&ops),
+		func(next Handler) Handler {
+			return func(ctx context.Context, op *Operation) (any, error) {
+				return nil, errDenied
+			}
+		},
+	)
+
+	err = client.Get(ctx, &ChildDocument{
+		Parent: &ParentDocument{ID: "p"},
+		ID:     "c",
+	})
+	assert.ErrorIs(t, err, errDenied)
+
+	_, err = client.GetAll(ctx, []*MyDocument{{ID: "1"}, nil, {ID: "2"}})
+	assert.ErrorIs(t, err, errDenied)
+
+	newDoc := &MyDocument{Name: "Alice"}
+	_, err = client.Create(ctx, newDoc)
+	assert.ErrorIs(t, err, errDenied)
+	assert.Empty(t, newDoc.ID, "ID must not be assigned when the operation is short-circuited")
+
+	_, err = client.Delete(ctx, &MyDocument{ID: "1"})
+	assert.ErrorIs(t, err, errDenied)
+
+	var docs []*ChildDocument
+	_, err = client.QueryNested(&ParentDocument{ID: "p"}, &docs).Count(ctx)
+	assert.ErrorIs(t, err, errDenied)
+
+	require.Len(t, ops, 5)
+	assert.Equal(t, OperationGet, ops[0].Kind)
+	assert.Equal(t, "children", ops[0].Collection)
+	assert.Equal(t, "ParentDocument/p/children/c", ops[0].Path)
+	assert.False(t, ops[0].InTransaction)
+
+	assert.Equal(t, OperationGetAll, ops[1].Kind)
+	assert.Equal(t, "MyDocument", ops[1].Collection)
+	assert.Equal(t, []string{"MyDocument/1", "MyDocument/2"}, ops[1].Paths)
+
+	assert.Equal(t, OperationCreate, ops[2].Kind)
+	assert.Regexp(t, `^MyDocument/.+$`, ops[2].Path)
+
+	assert.Equal(t, OperationDelete, ops[3].Kind)
+	assert.Equal(t, "MyDocument/1", ops[3].Path)
+
+	assert.Equal(t, OperationCount, ops[4].Kind)
+	assert.Equal(t, "children", ops[4].Collection)
+	assert.Equal(t, "ParentDocument/p/children", ops[4].Path)
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, op *Operation) (any, error) {
+				order = append(order, name+":before")
+				result, err := next(ctx, op)
+				order = append(order, name+":after")
+				return result, err
+			}
+		}
+	}
+	client.Use(mw("outer"), mw("inner"))
+	client.Use(func(next Handler) Handler {
+		return func(ctx context.Context, op *Operation) (any, error) {
+			return int64(42), nil
+		}
+	})
+
+	var docs []*MyDocument
+	count, err := client.Query(&docs).Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), count)
+	assert.Equal(t, []string{"outer:before", "inner:before", "inner:after", "outer:after"}, order)
+}
+
+func TestMiddlewareReadOnlyRejection(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	client.AddReadonlyTableMaps(map[string]string{
+		"TestReadOnlyDocument": "readonly_collection",
+	})
+
+	var ops []Operation
+	client.Use(recordOperations(&ops))
+
+	_, err = client.Set(ctx, &TestReadOnlyDocument{ID: "1"})
+	assertProgrammingError(t, err)
+	require.Len(t, ops, 1)
+	assert.Equal(t, OperationSet, ops[0].Kind)
+	assert.Equal(t, "readonly_collection/1", ops[0].Path)
+}
+
+func TestMiddlewareInTransaction(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	var ops []Operation
+	client.Use(recordOperations(&ops))
+
+	err = client.RunTransaction(ctx, func(ctx context.Context, client *Client) error {
+		_, err := client.Set(ctx, &MyDocument{ID: "docid", Name: "Alice"})
+		return err
+	})
+	require.NoError(t, err)
+
+	require.Len(t, ops, 2)
+	assert.Equal(t, OperationTransaction, ops[0].Kind)
+	assert.False(t, ops[0].InTransaction)
+	assert.Equal(t, OperationSet, ops[1].Kind)
+	assert.Equal(t, "MyDocument/docid", ops[1].Path)
+	assert.True(t, ops[1].InTransaction)
+}
+
+func TestMiddlewareShortCircuitWithoutResult(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	var result any
+	client.Use(func(next Handler) Handler {
+		return func(ctx context.Context, op *Operation) (any, error) {
+			return result, nil
+		}
+	})
+
+	for _, r := range []any{nil, "unexpected"} {
+		result = r
+		wr, err := client.Set(ctx, &MyDocument{ID: "1"})
+		assert.NoError(t, err)
+		assert.Nil(t, wr)
+	}
+}
@@ -0,0 +1,550 @@
+package simplestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MigrationCollection is the collection to store states of migrations
+	// States are stored as `_migrations/<migration ID>`.
+	MigrationCollection = "_migrations"
+	// DefaultMigrationBatchSize is the default number of documents migrated in a transaction
+	DefaultMigrationBatchSize = 100
+	// maxMigrationBatchSize leaves a write for the state in a transaction
+	maxMigrationBatchSize = 499
+)
+
+// ErrMigrationConflict indicates the state of the migration is changed by another process
+var ErrMigrationConflict = errors.New("simplestore: migration state is changed by another process")
+
+// MigrationStatus is the status of a migration
+type MigrationStatus string
+
+const (
+	// MigrationPending means the migration is not applied
+	// Not stored in MigrationCollection.
+	MigrationPending MigrationStatus = "pending"
+	// MigrationRunning means the migration is partially applied
+	MigrationRunning MigrationStatus = "running"
+	// MigrationApplied means the migration is applied to all documents
+	MigrationApplied MigrationStatus = "applied"
+	// MigrationRollingBack means the migration is partially rolled back
+	MigrationRollingBack MigrationStatus = "rollingBack"
+)
+
+// MigrationState is the state of a migration stored in MigrationCollection
+type MigrationState struct {
+	ID     string
+	Status MigrationStatus
+	// Cursor is the path of the last processed document while running or rolling back.
+	Cursor string
+	// Processed is the number of processed documents.
+	Processed int64
+	// Changed is the number of written documents.
+	Changed   int64
+	StartedAt time.Time
+	UpdatedAt time.Time
+	AppliedAt time.Time
+	// LastError is the error stopped the migration last time.
+	LastError string
+}
+
+// MigrationChange is a change of a document made by a migration
+type MigrationChange struct {
+	MigrationID string
+	// Path is the path of the document relative to the database.
+	Path string
+	// Before is the document before the change: a raw map or a struct for typed migrations.
+	Before any
+	// After is the document after the change: a raw map or a struct for typed migrations.
+	After any
+}
+
+// migrationFunc returns the document before and after the change
+// after is nil to keep the document as is.
+type migrationFunc func(ctx context.Context, c *Client, docsnap *firestore.DocumentSnapshot) (before any, after any, err error)
+
+// Migration transforms documents in collections with an ID
+// Create with `NewMigration` or `NewTypedMigration`.
+type Migration struct {
+	ID         string
+	collection func(c *Client) (string, error)
+	up         migrationFunc
+	down       migrationFunc
+}
+
+// NewMigration returns a migration transforming raw data of documents
+// Documents in all collections with the collection ID, including subcollections, are transformed.
+// up and down return new data of the document, or nil to keep the document as is.
+// data can be modified and returned.
+// down can be nil if the migration can't be rolled back.
+func NewMigration(
+	id string,
+	collection string,
+	up func(ctx context.Context, data map[string]any) (map[string]any, error),
+	down func(ctx context.Context, data map[string]any) (map[string]any, error),
+) *Migration {
+	rawFunc := func(f func(ctx context.Context, data map[string]any) (map[string]any, error)) migrationFunc {
+		if f == nil {
+			return nil
+		}
+		return func(ctx context.Context, c *Client, docsnap *firestore.DocumentSnapshot) (any, any, error) {
+			after, err := f(ctx, docsnap.Data())
+			if after == nil || err != nil {
+				return nil, nil, err
+			}
+			return docsnap.Data(), after, nil
+		}
+	}
+	return &Migration{
+		ID: id,
+		collection: func(c *Client) (string, error) {
+			return collection, nil
+		},
+		up:   rawFunc(up),
+		down: rawFunc(down),
+	}
+}
+
+// NewTypedMigration returns a migration converting documents from From to To
+// The collection is resolved from To with table maps of the client.
+// up and down return the converted document, or nil to keep the document as is.
+// ID and the parent chain are set to both passed and returned documents.
+// Documents are written as is: unique indexes, audit logs and histories are not updated.
+// down can be nil if the migration can't be rolled back.
+func NewTypedMigration[From any, To any](
+	id string,
+	up func(ctx context.Context, o *From) (*To, error),
+	down func(ctx context.Context, o *To) (*From, error),
+) *Migration {
+	m := &Migration{
+		ID: id,
+		collection: func(c *Client) (string, error) {
+			accessor, err := newAccessor(reflect.TypeOf((*To)(nil)), c.tableMaps)
+			if err != nil {
+				return "", err
+			}
+			return accessor.collectionName, nil
+		},
+		up: typedMigrationFunc(up),
+	}
+	if down != nil {
+		m.down = typedMigrationFunc(down)
+	}
+	return m
+}
+
+func typedMigrationFunc[From any, To any](f func(ctx context.Context, o *From) (*To, error)) migrationFunc {
+	return func(ctx context.Context, c *Client, docsnap *firestore.DocumentSnapshot) (any, any, error) {
+		before := new(From)
+		// not written back as the migration writes
+		if _, err := c.upgradedDataTo(ctx, docsnap, before); err != nil {
+			return nil, nil, err
+		}
+		if err := setMigratedPath(c, before, docsnap.Ref); err != nil {
+			return nil, nil, err
+		}
+		// f may modify the passed object
+		o := deepCopy(reflect.ValueOf(before)).Interface().(*From)
+		after, err := f(ctx, o)
+		if after == nil || err != nil {
+			return nil, nil, err
+		}
+		if err := setMigratedPath(c, after, docsnap.Ref); err != nil {
+			return nil, nil, err
+		}
+		return before, after, nil
+	}
+}
+
+// setMigratedPath sets ID and the parent chain of o from the migrated document as `Get` and `Query` do
+// The collection of o may be mapped to another name than the document.
+// Only ID is set for types without Parent as documents may be in subcollections.
+func setMigratedPath(c *Client, o any, ref *firestore.DocumentRef) error {
+	accessor, err := newAccessor(reflect.TypeOf(o), c.tableMaps)
+	if err != nil {
+		return err
+	}
+	if accessor.parentAccessor == nil {
+		accessor.setID(reflect.ValueOf(o), ref.ID)
+		return nil
+	}
+	segments, err := splitDocumentPath(ref.Path)
+	if err != nil {
+		return err
+	}
+	segments[len(segments)-2] = accessor.collectionName
+	return accessor.setPath(reflect.ValueOf(o), segments)
+}
+
+// RegisterMigrations registers migrations to run with `Migrate`
+// Migrations run in the order of registrations. IDs must be unique.
+func (c *Client) RegisterMigrations(migrations ...*Migration) error {
+	ids := make(map[string]bool, len(c.migrations)+len(migrations))
+	for _, m := range c.migrations {
+		ids[m.ID] = true
+	}
+	for _, m := range migrations {
+		if m == nil || m.up == nil {
+			return NewProgrammingError("migration must be created with NewMigration or NewTypedMigration")
+		}
+		if m.ID == "" {
+			return NewProgrammingError("migration ID is empty")
+		}
+		if ids[m.ID] {
+			return NewProgrammingErrorf("migration is already registered: %s", m.ID)
+		}
+		ids[m.ID] = true
+	}
+	c.migrations = append(c.migrations, migrations...)
+	return nil
+}
+
+type migrateConfig struct {
+	batchSize int
+	dryRun    bool
+	onChange  func(change *MigrationChange)
+}
+
+// MigrateOption configures `Migrate` and `RollbackMigration`
+type MigrateOption func(*migrateConfig)
+
+// WithMigrationBatchSize specifies the number of documents migrated in a transaction
+// Must be less than 500. Defaults to DefaultMigrationBatchSize.
+func WithMigrationBatchSize(n int) MigrateOption {
+	return func(c *migrateConfig) {
+		c.batchSize = n
+	}
+}
+
+// WithMigrationDryRun runs migrations without writing documents and states
+// Changes are reported to f if not nil.
+// Migrations are tested from the first document, and later migrations see documents not migrated by earlier ones.
+func WithMigrationDryRun(f func(change *MigrationChange)) MigrateOption {
+	return func(c *migrateConfig) {
+		c.dryRun = true
+		c.onChange = f
+	}
+}
+
+func newMigrateConfig(opts []MigrateOption) (*migrateConfig, error) {
+	cfg := &migrateConfig{
+		batchSize: DefaultMigrationBatchSize,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.batchSize < 1 || cfg.batchSize > maxMigrationBatchSize {
+		return nil, NewProgrammingErrorf("batch size must be between 1 and %d: %d", maxMigrationBatchSize, cfg.batchSize)
+	}
+	return cfg, nil
+}
+
+// Migrate applies registered migrations not applied yet in order
+// Documents are migrated in batches, each in a transaction with the checkpoint in MigrationCollection,
+// so interrupted migrations resume from the checkpoint.
+// Returns states of migrations run in this call.
+func (c *Client) Migrate(ctx context.Context, opts ...MigrateOption) ([]*MigrationState, error) {
+	cfg, err := c.checkMigrate(opts)
+	if err != nil {
+		return nil, err
+	}
+	var results []*MigrationState
+	for _, m := range c.migrations {
+		stored, err := c.getMigrationState(ctx, m.ID)
+		if err != nil {
+			return results, err
+		}
+		switch stored.Status {
+		case MigrationApplied:
+			continue
+		case MigrationRollingBack:
+			return results, fmt.Errorf("migration %s is rolling back: resume with RollbackMigration", m.ID)
+		}
+		if cfg.dryRun {
+			stored = &MigrationState{ID: m.ID, Status: MigrationPending}
+		}
+		state, err := c.runMigration(ctx, m, m.up, stored, MigrationRunning, cfg)
+		results = append(results, state)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// RollbackMigration rolls back the migration with its down function
+// Migrations registered after it must not be applied.
+// Partially applied migrations can be rolled back: down is called also for documents not migrated.
+// The state is removed from MigrationCollection after rolled back.
+func (c *Client) RollbackMigration(ctx context.Context, id string, opts ...MigrateOption) (*MigrationState, error) {
+	cfg, err := c.checkMigrate(opts)
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	for i, m := range c.migrations {
+		if m.ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, NewProgrammingErrorf("migration is not registered: %s", id)
+	}
+	m := c.migrations[index]
+	if m.down == nil {
+		return nil, NewProgrammingErrorf("migration cannot be rolled back: %s", id)
+	}
+	for _, later := range c.migrations[index+1:] {
+		state, err := c.getMigrationState(ctx, later.ID)
+		if err != nil {
+			return nil, err
+		}
+		if state.Status != MigrationPending {
+			return nil, NewProgrammingErrorf("roll back %s before %s", later.ID, id)
+		}
+	}
+	stored, err := c.getMigrationState(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Status == MigrationPending {
+		return nil, NewProgrammingErrorf("migration is not applied: %s", id)
+	}
+	if cfg.dryRun {
+		stored = &MigrationState{ID: id, Status: MigrationPending}
+	}
+	return c.runMigration(ctx, m, m.down, stored, MigrationRollingBack, cfg)
+}
+
+// MigrationStates returns states of migrations stored in MigrationCollection
+// Includes states of migrations not registered to c.
+func (c *Client) MigrationStates(ctx context.Context) ([]*MigrationState, error) {
+	docsnaps, err := c.FirestoreClient.Collection(MigrationCollection).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	states := make([]*MigrationState, 0, len(docsnaps))
+	for _, docsnap := range docsnaps {
+		state := &MigrationState{}
+		if err := docsnap.DataTo(state); err != nil {
+			return nil, fmt.Errorf("%s: %w", docsnap.Ref.ID, err)
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (c *Client) checkMigrate(opts []MigrateOption) (*migrateConfig, error) {
+	cfg, err := newMigrateConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.FirestoreTransaction != nil {
+		return nil, NewProgrammingError("cannot migrate in a transaction")
+	}
+	if !cfg.dryRun {
+		if err := c.checkWritable(); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func (c *Client) migrationStateRef(id string) *firestore.DocumentRef {
+	return c.FirestoreClient.Collection(MigrationCollection).Doc(id)
+}
+
+// getMigrationState reads the state of the migration
+// Returns MigrationPending if not stored.
+func (c *Client) getMigrationState(ctx context.Context, id string) (*MigrationState, error) {
+	var docsnap *firestore.DocumentSnapshot
+	var err error
+	if c.FirestoreTransaction != nil {
+		docsnap, err = c.FirestoreTransaction.Get(c.migrationStateRef(id))
+	} else {
+		docsnap, err = c.migrationStateRef(id).Get(ctx)
+	}
+	if status.Code(err) == codes.NotFound {
+		return &MigrationState{ID: id, Status: MigrationPending}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &MigrationState{}
+	if err := docsnap.DataTo(state); err != nil {
+		return nil, err
+	}
+	if state.Status == "" {
+		state.Status = MigrationPending
+	}
+	return state, nil
+}
+
+// runMigration processes documents with f in batches from the checkpoint of stored
+// running is MigrationRunning or MigrationRollingBack.
+func (c *Client) runMigration(
+	ctx context.Context,
+	m *Migration,
+	f migrationFunc,
+	stored *MigrationState,
+	running MigrationStatus,
+	cfg *migrateConfig,
+) (*MigrationState, error) {
+	collection, err := m.collection(c)
+	if err != nil {
+		return stored, err
+	}
+	state := stored
+	for {
+		next := *state
+		if next.Status != running {
+			next = MigrationState{
+				ID:        m.ID,
+				Status:    running,
+				StartedAt: time.Now(),
+			}
+		}
+		var done bool
+		if cfg.dryRun {
+			done, err = c.dryRunMigrationBatch(ctx, m, f, collection, &next, cfg)
+		} else {
+			done, err = c.runMigrationBatch(ctx, f, collection, state, &next, cfg)
+		}
+		if err != nil {
+			if !cfg.dryRun && state.Status != MigrationPending && !errors.Is(err, ErrMigrationConflict) {
+				// best effort to tell why the migration stopped
+				_, _ = c.migrationStateRef(m.ID).Set(ctx, map[string]any{
+					"LastError": err.Error(),
+					"UpdatedAt": time.Now(),
+				}, firestore.MergeAll)
+			}
+			return state, fmt.Errorf("migration %s: %w", m.ID, err)
+		}
+		state = &next
+		if done {
+			return state, nil
+		}
+	}
+}
+
+// migrationQuery returns the query for the next batch after the cursor
+func (c *Client) migrationQuery(collection string, cursor string, cfg *migrateConfig) firestore.Query {
+	q := c.FirestoreClient.CollectionGroup(collection).OrderBy(firestore.DocumentID, firestore.Asc).Limit(cfg.batchSize)
+	if cursor != "" {
+		q = q.StartAfter(c.FirestoreClient.Doc(cursor))
+	}
+	return q
+}
+
+// runMigrationBatch processes a batch in a transaction
+// stored is the state expected to be stored, and next is updated to the state after the batch.
+// Returns true if all documents are processed.
+func (c *Client) runMigrationBatch(
+	ctx context.Context,
+	f migrationFunc,
+	collection string,
+	stored *MigrationState,
+	next *MigrationState,
+	cfg *migrateConfig,
+) (bool, error) {
+	start := *next
+	done := false
+	err := c.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		*next = start
+		current, err := tc.getMigrationState(ctx, next.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != stored.Status || current.Cursor != stored.Cursor || current.Processed != stored.Processed {
+			return ErrMigrationConflict
+		}
+		docsnaps, err := tc.FirestoreTransaction.Documents(c.migrationQuery(collection, next.Cursor, cfg)).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, docsnap := range docsnaps {
+			path := relativePath(docsnap.Ref.Path)
+			_, after, err := f(ctx, tc, docsnap)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if after != nil {
+				if err := tc.FirestoreTransaction.Set(docsnap.Ref, after); err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				next.Changed++
+			}
+			next.Processed++
+			next.Cursor = path
+		}
+		next.UpdatedAt = time.Now()
+		next.LastError = ""
+		done = len(docsnaps) < cfg.batchSize
+		ref := tc.migrationStateRef(next.ID)
+		if !done {
+			return tc.FirestoreTransaction.Set(ref, next)
+		}
+		next.Cursor = ""
+		if next.Status == MigrationRollingBack {
+			next.Status = MigrationPending
+			return tc.FirestoreTransaction.Delete(ref)
+		}
+		next.Status = MigrationApplied
+		next.AppliedAt = next.UpdatedAt
+		return tc.FirestoreTransaction.Set(ref, next)
+	})
+	return done, err
+}
+
+// dryRunMigrationBatch processes a batch without writing
+func (c *Client) dryRunMigrationBatch(
+	ctx context.Context,
+	m *Migration,
+	f migrationFunc,
+	collection string,
+	next *MigrationState,
+	cfg *migrateConfig,
+) (bool, error) {
+	docsnaps, err := c.migrationQuery(collection, next.Cursor, cfg).Documents(ctx).GetAll()
+	if err != nil {
+		return false, err
+	}
+	for _, docsnap := range docsnaps {
+		path := relativePath(docsnap.Ref.Path)
+		before, after, err := f(ctx, c, docsnap)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", path, err)
+		}
+		if after != nil {
+			if cfg.onChange != nil {
+				cfg.onChange(&MigrationChange{
+					MigrationID: m.ID,
+					Path:        path,
+					Before:      before,
+					After:       after,
+				})
+			}
+			next.Changed++
+		}
+		next.Processed++
+		next.Cursor = path
+	}
+	next.UpdatedAt = time.Now()
+	if len(docsnaps) < cfg.batchSize {
+		// nothing is applied
+		next.Status = MigrationPending
+		next.Cursor = ""
+		return true, nil
+	}
+	return false, nil
+}
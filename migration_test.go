@@ -0,0 +1,311 @@
+package simplestore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type MigrationUser struct {
+	ID   string
+	Name string
+}
+
+type MigrationUserV2 struct {
+	ID        string
+	FirstName string
+	LastName  string
+}
+
+// renameField returns migration functions renaming the field
+func renameField(from, to string) func(ctx context.Context, data map[string]any) (map[string]any, error) {
+	return func(ctx context.Context, data map[string]any) (map[string]any, error) {
+		v, ok := data[from]
+		if !ok {
+			return nil, nil
+		}
+		delete(data, from)
+		data[to] = v
+		return data, nil
+	}
+}
+
+// clearMigrations deletes documents and states of migrations
+func clearMigrations(t *testing.T) {
+	clearAllDocuments(t, &MigrationUser{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, DeleteCollection(ctx, client.FirestoreClient, MigrationCollection, 100))
+}
+
+func TestRegisterMigrations(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	rename := NewMigration("rename", "MigrationUser", renameField("Name", "FullName"), nil)
+	require.NoError(t, client.RegisterMigrations(rename))
+	assertProgrammingError(t, client.RegisterMigrations(NewMigration("rename", "MigrationUser", renameField("a", "b"), nil)))
+	assertProgrammingError(t, client.RegisterMigrations(NewMigration("", "MigrationUser", renameField("a", "b"), nil)))
+	assertProgrammingError(t, client.RegisterMigrations(NewMigration("nil", "MigrationUser", nil, nil)))
+	assertProgrammingError(t, client.RegisterMigrations(&Migration{ID: "empty"}))
+	assert.Len(t, client.migrations, 1)
+
+	_, err = client.Migrate(ctx, WithMigrationBatchSize(0))
+	assertProgrammingError(t, err)
+	_, err = client.Migrate(ctx, WithMigrationBatchSize(500))
+	assertProgrammingError(t, err)
+	_, err = client.RollbackMigration(ctx, "unknown")
+	assertProgrammingError(t, err)
+	// no down function
+	_, err = client.RollbackMigration(ctx, "rename")
+	assertProgrammingError(t, err)
+
+	view, err := client.AtReadTime(time.Now())
+	require.NoError(t, err)
+	defer view.Close()
+	_, err = view.Migrate(ctx)
+	assertProgrammingError(t, err)
+}
+
+func TestMigrate(t *testing.T) {
+	clearMigrations(t)
+	defer clearMigrations(t)
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+	for _, id := range []string{"u1", "u2", "u3", "u4", "u5"} {
+		_, err := client.Set(ctx, &MigrationUser{ID: id, Name: "Name " + id})
+		require.NoError(t, err)
+	}
+
+	failing := true
+	up := renameField("Name", "FullName")
+	require.NoError(t, client.RegisterMigrations(
+		NewMigration(
+			"001-rename",
+			"MigrationUser",
+			func(ctx context.Context, data map[string]any) (map[string]any, error) {
+				if failing && data["ID"] == "u4" {
+					return nil, errors.New("test error")
+				}
+				return up(ctx, data)
+			},
+			renameField("FullName", "Name"),
+		),
+	))
+
+	// dry run
+	var changes []*MigrationChange
+	states, err := client.Migrate(ctx, WithMigrationDryRun(func(change *MigrationChange) {
+		changes = append(changes, change)
+	}), WithMigrationBatchSize(10))
+	require.ErrorContains(t, err, "test error")
+	require.Len(t, states, 1)
+	require.Len(t, changes, 3)
+	assert.Equal(t, "MigrationUser/u1", changes[0].Path)
+	assert.Equal(t, "Name u1", changes[0].Before.(map[string]any)["Name"])
+	assert.Equal(t, "Name u1", changes[0].After.(map[string]any)["FullName"])
+	stored, err := client.MigrationStates(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+
+	// stops at the failing document after committing previous batches
+	_, err = client.Migrate(ctx, WithMigrationBatchSize(2))
+	require.ErrorContains(t, err, "MigrationUser/u4: test error")
+	stored, err = client.MigrationStates(ctx)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, MigrationRunning, stored[0].Status)
+	assert.Equal(t, "MigrationUser/u2", stored[0].Cursor)
+	assert.Equal(t, int64(2), stored[0].Processed)
+	assert.Contains(t, stored[0].LastError, "test error")
+
+	// resumes from the checkpoint
+	failing = false
+	states, err = client.Migrate(ctx, WithMigrationBatchSize(2))
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	assert.Equal(t, MigrationApplied, states[0].Status)
+	assert.Equal(t, int64(5), states[0].Processed)
+	assert.Equal(t, int64(5), states[0].Changed)
+	assert.Empty(t, states[0].LastError)
+	docsnap, err := client.FirestoreClient.Doc("MigrationUser/u5").Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"ID": "u5", "FullName": "Name u5"}, docsnap.Data())
+
+	// applied migrations are skipped
+	states, err = client.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, states)
+
+	// rollback
+	state, err := client.RollbackMigration(ctx, "001-rename", WithMigrationBatchSize(2))
+	require.NoError(t, err)
+	assert.Equal(t, MigrationPending, state.Status)
+	assert.Equal(t, int64(5), state.Changed)
+	user := &MigrationUser{ID: "u5"}
+	require.NoError(t, client.Get(ctx, user))
+	assert.Equal(t, "Name u5", user.Name)
+	stored, err = client.MigrationStates(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+	_, err = client.RollbackMigration(ctx, "001-rename")
+	assertProgrammingError(t, err)
+}
+
+func TestTypedMigration(t *testing.T) {
+	clearMigrations(t)
+	defer clearMigrations(t)
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+	client.AddTableMaps(map[string]string{"MigrationUserV2": "MigrationUser"})
+	_, err = client.Set(ctx, &MigrationUser{ID: "u1", Name: "Alice Smith"})
+	require.NoError(t, err)
+	_, err = client.Set(ctx, &MigrationUser{ID: "u2", Name: "Bob"})
+	require.NoError(t, err)
+
+	split := NewTypedMigration(
+		"002-split-name",
+		func(ctx context.Context, o *MigrationUser) (*MigrationUserV2, error) {
+			first, last, _ := strings.Cut(o.Name, " ")
+			return &MigrationUserV2{ID: o.ID, FirstName: first, LastName: last}, nil
+		},
+		func(ctx context.Context, o *MigrationUserV2) (*MigrationUser, error) {
+			return &MigrationUser{ID: o.ID, Name: strings.TrimSpace(o.FirstName + " " + o.LastName)}, nil
+		},
+	)
+	later := NewMigration("003-noop", "MigrationUser", func(ctx context.Context, data map[string]any) (map[string]any, error) {
+		return nil, nil
+	}, nil)
+	require.NoError(t, client.RegisterMigrations(split, later))
+
+	states, err := client.Migrate(ctx)
+	require.NoError(t, err)
+	require.Len(t, states, 2)
+	assert.Equal(t, int64(2), states[0].Changed)
+	assert.Equal(t, int64(0), states[1].Changed)
+	user := &MigrationUserV2{ID: "u1"}
+	require.NoError(t, client.Get(ctx, user))
+	assert.Equal(t, &MigrationUserV2{ID: "u1", FirstName: "Alice", LastName: "Smith"}, user)
+
+	// later migrations must be rolled back first
+	_, err = client.RollbackMigration(ctx, "002-split-name")
+	assertProgrammingError(t, err)
+}
+
+type MigrationTag struct {
+	Parent *MigrationUser
+	Key    string `firestore:"-"`
+	Tags   []string
+}
+
+func (o *MigrationTag) GetDocumentID() string {
+	return o.Key
+}
+
+func (o *MigrationTag) SetDocumentID(id string) {
+	o.Key = id
+}
+
+type MigrationTagV2 struct {
+	Parent *MigrationUser
+	ID     string
+	Tags   []string
+}
+
+func TestTypedMigrationIDAndParent(t *testing.T) {
+	clearMigrations(t)
+	defer clearMigrations(t)
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+	defer func() {
+		require.NoError(t, DeleteCollection(ctx, client.FirestoreClient, "MigrationUser/u1/MigrationTag", 100))
+	}()
+	client.AddTableMaps(map[string]string{"MigrationTagV2": "MigrationTag"})
+	parent := &MigrationUser{ID: "u1"}
+	_, err = client.Set(ctx, &MigrationTag{Parent: parent, Key: "t1", Tags: []string{"a"}})
+	require.NoError(t, err)
+
+	require.NoError(t, client.RegisterMigrations(NewTypedMigration(
+		"001-tags",
+		func(ctx context.Context, o *MigrationTag) (*MigrationTagV2, error) {
+			assert.Equal(t, "t1", o.Key)
+			assert.Equal(t, parent, o.Parent)
+			o.Tags[0] = "b"
+			// ID and Parent aren't copied
+			return &MigrationTagV2{Tags: o.Tags}, nil
+		},
+		nil,
+	)))
+
+	// Before is not modified by up
+	var changes []*MigrationChange
+	_, err = client.Migrate(ctx, WithMigrationDryRun(func(change *MigrationChange) {
+		changes = append(changes, change)
+	}))
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, &MigrationTag{Parent: parent, Key: "t1", Tags: []string{"a"}}, changes[0].Before)
+	assert.Equal(t, &MigrationTagV2{Parent: parent, ID: "t1", Tags: []string{"b"}}, changes[0].After)
+
+	_, err = client.Migrate(ctx)
+	require.NoError(t, err)
+	migrated := &MigrationTagV2{Parent: parent, ID: "t1"}
+	require.NoError(t, client.Get(ctx, migrated))
+	assert.Equal(t, &MigrationTagV2{Parent: parent, ID: "t1", Tags: []string{"b"}}, migrated)
+}
+
+func TestTypedMigrationCyclicParent(t *testing.T) {
+	clearMigrations(t)
+	defer clearMigrations(t)
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+	defer func() {
+		require.NoError(t, DeleteCollection(ctx, client.FirestoreClient, "Order/cyclic/OrderItem", 100))
+	}()
+	_, err = client.Set(ctx, &OrderItem{Parent: &Order{ID: "cyclic"}, ID: "item1", Price: 100})
+	require.NoError(t, err)
+
+	require.NoError(t, client.RegisterMigrations(NewTypedMigration(
+		"001-double-price",
+		func(ctx context.Context, o *OrderItem) (*OrderItem, error) {
+			if o.Parent == nil || o.Parent.ID != "cyclic" {
+				return nil, nil
+			}
+			// the parent points back to the child as loaded with Load
+			o.Parent.Items = append(o.Parent.Items, o)
+			return &OrderItem{Parent: o.Parent, Price: o.Price * 2}, nil
+		},
+		nil,
+	)))
+
+	var changes []*MigrationChange
+	_, err = client.Migrate(ctx, WithMigrationDryRun(func(change *MigrationChange) {
+		changes = append(changes, change)
+	}))
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, &OrderItem{Parent: &Order{ID: "cyclic"}, ID: "item1", Price: 100}, changes[0].Before)
+
+	_, err = client.Migrate(ctx)
+	require.NoError(t, err)
+	item := &OrderItem{Parent: &Order{ID: "cyclic"}, ID: "item1"}
+	require.NoError(t, client.Get(ctx, item))
+	assert.Equal(t, 200, item.Price)
+}
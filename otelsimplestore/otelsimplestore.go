@@ -0,0 +1,251 @@
+/*
+Package otelsimplestore provides OpenTelemetry instrumentation for simplestore.
+
+	client.Use(otelsimplestore.Middleware())
+
+Spans are created for each simplestore operation, and following metrics are recorded:
+
+* `simplestore.operation.duration`: latency of operations in seconds
+* `simplestore.documents.read`: number of documents read
+* `simplestore.documents.written`: number of documents written
+* `simplestore.transaction.retries`: number of retried transaction attempts
+*/
+package otelsimplestore
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/ikedam/simplestore"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope name
+const ScopeName = "github.com/ikedam/simplestore/otelsimplestore"
+
+// Attribute keys set to spans and metrics
+const (
+	AttributeOperation         = attribute.Key("simplestore.operation")
+	AttributeCollection        = attribute.Key("simplestore.collection")
+	AttributeDocumentID        = attribute.Key("simplestore.document.id")
+	AttributeDocumentPath      = attribute.Key("simplestore.document.path")
+	AttributeDocumentCount     = attribute.Key("simplestore.document.count")
+	AttributeQueryShape        = attribute.Key("simplestore.query.shape")
+	AttributeResultCount       = attribute.Key("simplestore.result.count")
+	AttributeMissingCount      = attribute.Key("simplestore.missing.count")
+	AttributeCachedCount       = attribute.Key("simplestore.cached.count")
+	AttributeInTransaction     = attribute.Key("simplestore.transaction")
+	AttributeAttempt           = attribute.Key("simplestore.transaction.attempt")
+	AttributeReadOnlyRejection = attribute.Key("simplestore.readonly_rejected")
+	AttributeError             = attribute.Key("simplestore.error")
+)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+}
+
+// Option configures the middleware
+type Option func(*config)
+
+// WithTracerProvider specifies the tracer provider
+// The global tracer provider is used by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider specifies the meter provider
+// The global meter provider is used by default.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = mp
+	}
+}
+
+type instrumentation struct {
+	tracer           trace.Tracer
+	duration         metric.Float64Histogram
+	documentsRead    metric.Int64Counter
+	documentsWritten metric.Int64Counter
+	retries          metric.Int64Counter
+}
+
+// Middleware returns a simplestore middleware recording traces and metrics
+func Middleware(opts ...Option) simplestore.Middleware {
+	cfg := &config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	inst := newInstrumentation(cfg)
+	return func(next simplestore.Handler) simplestore.Handler {
+		return func(ctx context.Context, op *simplestore.Operation) (any, error) {
+			return inst.handle(ctx, op, next)
+		}
+	}
+}
+
+func newInstrumentation(cfg *config) *instrumentation {
+	meter := cfg.meterProvider.Meter(ScopeName)
+	inst := &instrumentation{
+		tracer: cfg.tracerProvider.Tracer(ScopeName),
+	}
+	var err error
+	inst.duration, err = meter.Float64Histogram(
+		"simplestore.operation.duration",
+		metric.WithDescription("Duration of simplestore operations"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	inst.documentsRead, err = meter.Int64Counter(
+		"simplestore.documents.read",
+		metric.WithDescription("Number of documents read by simplestore operations"),
+		metric.WithUnit("{document}"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	inst.documentsWritten, err = meter.Int64Counter(
+		"simplestore.documents.written",
+		metric.WithDescription("Number of documents written by simplestore operations"),
+		metric.WithUnit("{document}"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	inst.retries, err = meter.Int64Counter(
+		"simplestore.transaction.retries",
+		metric.WithDescription("Number of retried transaction attempts"),
+		metric.WithUnit("{attempt}"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	return inst
+}
+
+func (inst *instrumentation) handle(ctx context.Context, op *simplestore.Operation, next simplestore.Handler) (any, error) {
+	ctx, span := inst.tracer.Start(
+		ctx,
+		"simplestore."+string(op.Kind),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(startAttributes(op)...),
+	)
+	defer span.End()
+
+	start := time.Now()
+	result, err := next(ctx, op)
+	elapsed := time.Since(start)
+
+	span.SetAttributes(endAttributes(op, err)...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	metricAttrs := metric.WithAttributes(
+		AttributeOperation.String(string(op.Kind)),
+		AttributeCollection.String(op.Collection),
+		AttributeError.Bool(err != nil),
+	)
+	if inst.duration != nil {
+		inst.duration.Record(ctx, elapsed.Seconds(), metricAttrs)
+	}
+	if read := documentsRead(op); read > 0 && inst.documentsRead != nil {
+		inst.documentsRead.Add(ctx, read, metricAttrs)
+	}
+	if written := documentsWritten(op, err); written > 0 && inst.documentsWritten != nil {
+		inst.documentsWritten.Add(ctx, written, metricAttrs)
+	}
+	if op.Kind == simplestore.OperationTransaction && op.Attempt > 1 && inst.retries != nil {
+		inst.retries.Add(ctx, int64(op.Attempt-1), metric.WithAttributes(AttributeError.Bool(err != nil)))
+	}
+	return result, err
+}
+
+func startAttributes(op *simplestore.Operation) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		AttributeOperation.String(string(op.Kind)),
+		AttributeInTransaction.Bool(op.InTransaction),
+	}
+	if op.Collection != "" {
+		attrs = append(attrs, AttributeCollection.String(op.Collection))
+	}
+	if op.Path != "" {
+		attrs = append(attrs, AttributeDocumentPath.String(op.Path))
+		if isDocumentOperation(op.Kind) {
+			attrs = append(attrs, AttributeDocumentID.String(path.Base(op.Path)))
+		}
+	}
+	if op.Kind == simplestore.OperationGetAll {
+		attrs = append(attrs, AttributeDocumentCount.Int(len(op.Paths)))
+	}
+	if q, ok := op.Target.(*simplestore.Query); ok {
+		attrs = append(attrs, AttributeQueryShape.String(q.Shape()))
+	}
+	if op.InTransaction {
+		attrs = append(attrs, AttributeAttempt.Int(op.Attempt))
+	}
+	return attrs
+}
+
+func endAttributes(op *simplestore.Operation, err error) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	switch op.Kind {
+	case simplestore.OperationGet, simplestore.OperationGetAll:
+		attrs = append(
+			attrs,
+			AttributeResultCount.Int(op.ResultCount),
+			AttributeMissingCount.Int(op.MissingCount),
+			AttributeCachedCount.Int(op.CachedCount),
+		)
+	case simplestore.OperationQuery, simplestore.OperationCount:
+		attrs = append(attrs, AttributeResultCount.Int(op.ResultCount))
+	case simplestore.OperationTransaction:
+		attrs = append(attrs, AttributeAttempt.Int(op.Attempt))
+	}
+	if isWriteOperation(op.Kind) && op.ReadOnly && err != nil {
+		attrs = append(attrs, AttributeReadOnlyRejection.Bool(true))
+	}
+	return attrs
+}
+
+func isDocumentOperation(kind simplestore.OperationKind) bool {
+	return kind == simplestore.OperationGet || isWriteOperation(kind)
+}
+
+func isWriteOperation(kind simplestore.OperationKind) bool {
+	switch kind {
+	case simplestore.OperationCreate, simplestore.OperationSet, simplestore.OperationDelete:
+		return true
+	}
+	return false
+}
+
+func documentsRead(op *simplestore.Operation) int64 {
+	switch op.Kind {
+	case simplestore.OperationGet, simplestore.OperationGetAll:
+		return int64(op.ResultCount + op.MissingCount - op.CachedCount)
+	case simplestore.OperationQuery:
+		return int64(op.ResultCount)
+	}
+	return 0
+}
+
+func documentsWritten(op *simplestore.Operation, err error) int64 {
+	if err != nil || !isWriteOperation(op.Kind) {
+		return 0
+	}
+	return 1
+}
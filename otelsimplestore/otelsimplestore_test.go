@@ -0,0 +1,181 @@
+package otelsimplestore
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/ikedam/simplestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Document struct {
+	ID   string
+	Name string
+}
+
+type Config struct {
+	ID    string
+	Value string
+}
+
+type testEnv struct {
+	client   *simplestore.Client
+	recorder *tracetest.SpanRecorder
+	reader   *sdkmetric.ManualReader
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	if _, ok := os.LookupEnv("FIRESTORE_EMULATOR_HOST"); !ok {
+		t.Setenv("FIRESTORE_EMULATOR_HOST", "localhost:8080")
+		t.Setenv("CLOUDSDK_CORE_PROJECT", "testproject")
+	}
+	client, err := simplestore.New(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+	})
+	recorder := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	client.Use(Middleware(
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+	))
+	return &testEnv{
+		client:   client,
+		recorder: recorder,
+		reader:   reader,
+	}
+}
+
+// stub returns a middleware that emulates results without accessing firestore
+func stub(resultCount, missingCount int) simplestore.Middleware {
+	return func(next simplestore.Handler) simplestore.Handler {
+		return func(ctx context.Context, op *simplestore.Operation) (any, error) {
+			op.ResultCount = resultCount
+			op.MissingCount = missingCount
+			return nil, nil
+		}
+	}
+}
+
+func spanAttributes(kvs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func collectSum(t *testing.T, reader *sdkmetric.ManualReader, name string) int64 {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	var total int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			require.True(t, ok, "%s must be a sum", name)
+			for _, dp := range sum.DataPoints {
+				total += dp.Value
+			}
+		}
+	}
+	return total
+}
+
+func TestGetSpan(t *testing.T) {
+	env := newTestEnv(t)
+	env.client.Use(stub(1, 0))
+
+	err := env.client.Get(context.Background(), &Document{ID: "docid"})
+	require.NoError(t, err)
+
+	spans := env.recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "simplestore.Get", spans[0].Name())
+	attrs := spanAttributes(spans[0].Attributes())
+	assert.Equal(t, "Document", attrs[AttributeCollection].AsString())
+	assert.Equal(t, "docid", attrs[AttributeDocumentID].AsString())
+	assert.Equal(t, "Document/docid", attrs[AttributeDocumentPath].AsString())
+	assert.Equal(t, int64(1), attrs[AttributeResultCount].AsInt64())
+
+	assert.Equal(t, int64(1), collectSum(t, env.reader, "simplestore.documents.read"))
+}
+
+func TestQuerySpan(t *testing.T) {
+	env := newTestEnv(t)
+	env.client.Use(stub(3, 0))
+
+	var docs []*Document
+	err := env.client.Query(&docs).Where("Name", "==", "Alice").Limit(10).GetAll(context.Background())
+	require.NoError(t, err)
+
+	spans := env.recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "simplestore.Query", spans[0].Name())
+	attrs := spanAttributes(spans[0].Attributes())
+	assert.Equal(t, "Where(Name ==) Limit", attrs[AttributeQueryShape].AsString())
+	assert.Equal(t, int64(3), attrs[AttributeResultCount].AsInt64())
+
+	assert.Equal(t, int64(3), collectSum(t, env.reader, "simplestore.documents.read"))
+}
+
+func TestReadOnlyRejection(t *testing.T) {
+	env := newTestEnv(t)
+	env.client.AddReadonlyTableMaps(map[string]string{
+		"Config": "configs",
+	})
+
+	_, err := env.client.Set(context.Background(), &Config{ID: "config1"})
+	require.Error(t, err)
+
+	spans := env.recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "simplestore.Set", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	attrs := spanAttributes(spans[0].Attributes())
+	assert.Equal(t, "configs", attrs[AttributeCollection].AsString())
+	assert.True(t, attrs[AttributeReadOnlyRejection].AsBool())
+
+	assert.Equal(t, int64(0), collectSum(t, env.reader, "simplestore.documents.written"))
+}
+
+func TestTransaction(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	err := env.client.RunTransaction(ctx, func(ctx context.Context, client *simplestore.Client) error {
+		doc := &Document{ID: "docid"}
+		err := client.Get(ctx, doc)
+		if err != nil && status.Code(err) != grpccodes.NotFound {
+			return err
+		}
+		doc.Name = "Alice"
+		_, err = client.Set(ctx, doc)
+		return err
+	})
+	require.NoError(t, err)
+
+	spans := env.recorder.Ended()
+	require.Len(t, spans, 3)
+	assert.Equal(t, "simplestore.Get", spans[0].Name())
+	assert.Equal(t, int64(1), spanAttributes(spans[0].Attributes())[AttributeAttempt].AsInt64())
+	assert.Equal(t, "simplestore.Set", spans[1].Name())
+	assert.Equal(t, "simplestore.Transaction", spans[2].Name())
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[0].Parent().SpanID())
+
+	assert.Equal(t, int64(1), collectSum(t, env.reader, "simplestore.documents.written"))
+	assert.Equal(t, int64(0), collectSum(t, env.reader, "simplestore.transaction.retries"))
+}
@@ -0,0 +1,243 @@
+package simplestore
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+const (
+	// DefaultRelayPollInterval is the default interval to poll the outbox
+	DefaultRelayPollInterval = 1 * time.Second
+	// DefaultRelayBatchSize is the default max number of events delivered in a poll
+	DefaultRelayBatchSize = 100
+	// DefaultRelayMaxAttempts is the default max number of deliveries before moving events to the dead letter collection
+	DefaultRelayMaxAttempts = 5
+)
+
+// OutboxEvent is an event in the outbox
+// Stored in the `OutboxEvent` collection. Use `AddTableMaps` to change the collection.
+type OutboxEvent struct {
+	ID    string
+	Topic string
+	// Payload is the content of the event.
+	// Values are stored as firestore values: use []byte to deliver encoded payloads as is.
+	Payload    any
+	Attributes map[string]string
+	// CreatedAt is set by the server and used to deliver events in order.
+	CreatedAt time.Time `firestore:"CreatedAt,serverTimestamp"`
+	// Attempts is the number of failed deliveries.
+	Attempts  int
+	LastError string
+}
+
+// OutboxDeadEvent is an event failed to deliver too many times
+// Stored in the `OutboxDeadEvent` collection. Use `AddTableMaps` to change the collection.
+// Attempts and LastError are kept for inspection. Emit the event again to retry.
+type OutboxDeadEvent struct {
+	ID         string
+	Topic      string
+	Payload    any
+	Attributes map[string]string
+	// CreatedAt is the time the event was written to the outbox.
+	CreatedAt time.Time
+	Attempts  int
+	LastError string
+}
+
+// Emit writes the event to the outbox
+// Call with the client in `RunTransaction` to write the event atomically with other writes.
+// ID is generated if not set.
+func (c *Client) Emit(ctx context.Context, event *OutboxEvent) error {
+	_, err := c.Create(ctx, event)
+	return err
+}
+
+// Publisher delivers events from the outbox
+type Publisher interface {
+	// Publish delivers the event
+	// Events are removed from the outbox when Publish succeeds.
+	Publish(ctx context.Context, event *OutboxEvent) error
+}
+
+// PublisherFunc is a function implementing Publisher
+type PublisherFunc func(ctx context.Context, event *OutboxEvent) error
+
+// Publish is an implementation for Publisher
+func (f PublisherFunc) Publish(ctx context.Context, event *OutboxEvent) error {
+	return f(ctx, event)
+}
+
+type relayConfig struct {
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	listen       bool
+	errorHandler func(err error)
+}
+
+// RelayOption configures OutboxRelay
+type RelayOption func(*relayConfig)
+
+// WithRelayPollInterval specifies the interval to poll the outbox
+// Defaults to DefaultRelayPollInterval.
+func WithRelayPollInterval(interval time.Duration) RelayOption {
+	return func(c *relayConfig) {
+		c.pollInterval = interval
+	}
+}
+
+// WithRelayBatchSize specifies the max number of events delivered in a poll
+// Defaults to DefaultRelayBatchSize.
+func WithRelayBatchSize(n int) RelayOption {
+	return func(c *relayConfig) {
+		c.batchSize = n
+	}
+}
+
+// WithRelayMaxAttempts specifies the max number of deliveries before moving events to the dead letter collection
+// Events failed n times are moved to OutboxDeadEvent, so they don't block following events.
+// Defaults to DefaultRelayMaxAttempts.
+func WithRelayMaxAttempts(n int) RelayOption {
+	return func(c *relayConfig) {
+		c.maxAttempts = n
+	}
+}
+
+// WithRelaySnapshotListener delivers events as soon as they are written with a snapshot listener
+// The outbox is still polled to retry failed deliveries.
+func WithRelaySnapshotListener() RelayOption {
+	return func(c *relayConfig) {
+		c.listen = true
+	}
+}
+
+// WithRelayErrorHandler specifies the function called with errors in `Run`
+// Errors are ignored by default.
+func WithRelayErrorHandler(f func(err error)) RelayOption {
+	return func(c *relayConfig) {
+		c.errorHandler = f
+	}
+}
+
+// OutboxRelay delivers events in the outbox to a publisher
+// Events are delivered at least once: an event can be delivered again
+// if the relay fails to remove the event, or multiple relays run.
+type OutboxRelay struct {
+	relayConfig
+	client    *Client
+	publisher Publisher
+}
+
+// NewOutboxRelay returns a new relay delivering events to publisher
+func (c *Client) NewOutboxRelay(publisher Publisher, opts ...RelayOption) *OutboxRelay {
+	r := &OutboxRelay{
+		relayConfig: relayConfig{
+			pollInterval: DefaultRelayPollInterval,
+			batchSize:    DefaultRelayBatchSize,
+			maxAttempts:  DefaultRelayMaxAttempts,
+		},
+		client:    c,
+		publisher: publisher,
+	}
+	for _, opt := range opts {
+		opt(&r.relayConfig)
+	}
+	return r
+}
+
+func (r *OutboxRelay) query(target *[]*OutboxEvent) *Query {
+	return r.client.Query(target).OrderBy("CreatedAt", firestore.Asc).Limit(r.batchSize)
+}
+
+// Deliver delivers events in the outbox in order
+// Stops at the first failure to keep the order, and records the failure to the event.
+// Events failed max attempts times are moved to the dead letter collection
+// and delivering continues with following events.
+// Returns the number of delivered events.
+func (r *OutboxRelay) Deliver(ctx context.Context) (int, error) {
+	var events []*OutboxEvent
+	if err := r.query(&events).GetAll(ctx); err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, event := range events {
+		if err := r.publisher.Publish(ctx, event); err != nil {
+			event.Attempts++
+			event.LastError = err.Error()
+			if event.Attempts >= r.maxAttempts {
+				if err := r.moveToDeadLetter(ctx, event); err != nil {
+					return delivered, err
+				}
+				continue
+			}
+			// updates only the delivery status without unique indexes, audits nor histories
+			if _, updateErr := r.client.GetDocumentRef(event).Update(ctx, []firestore.Update{
+				{Path: "Attempts", Value: event.Attempts},
+				{Path: "LastError", Value: event.LastError},
+			}); updateErr != nil {
+				return delivered, updateErr
+			}
+			return delivered, err
+		}
+		if _, err := r.client.Delete(ctx, event); err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+func (r *OutboxRelay) moveToDeadLetter(ctx context.Context, event *OutboxEvent) error {
+	return r.client.RunTransaction(ctx, func(ctx context.Context, client *Client) error {
+		// keeps CreatedAt of the event
+		dead := OutboxDeadEvent(*event)
+		if _, err := client.Set(ctx, &dead); err != nil {
+			return err
+		}
+		_, err := client.Delete(ctx, event)
+		return err
+	})
+}
+
+// Run delivers events until ctx is canceled
+// Returns nil when stopped by ctx.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	trigger := make(chan struct{}, 1)
+	if r.listen {
+		var events []*OutboxEvent
+		iter := r.query(&events).q.Snapshots(ctx)
+		defer iter.Stop()
+		go func() {
+			for {
+				if _, err := iter.Next(); err != nil {
+					return
+				}
+				select {
+				case trigger <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+	for {
+		n, err := r.Deliver(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && r.errorHandler != nil {
+			r.errorHandler(err)
+		}
+		if err == nil && n >= r.batchSize {
+			// more events may be waiting
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-trigger:
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package simplestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOutboxRelay(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	publisher := PublisherFunc(func(ctx context.Context, event *OutboxEvent) error {
+		return nil
+	})
+	relay := client.NewOutboxRelay(publisher)
+	assert.Equal(t, DefaultRelayPollInterval, relay.pollInterval)
+	assert.Equal(t, DefaultRelayBatchSize, relay.batchSize)
+	assert.Equal(t, DefaultRelayMaxAttempts, relay.maxAttempts)
+	assert.False(t, relay.listen)
+	assert.Nil(t, relay.errorHandler)
+
+	relay = client.NewOutboxRelay(
+		publisher,
+		WithRelayPollInterval(time.Minute),
+		WithRelayBatchSize(5),
+		WithRelayMaxAttempts(3),
+		WithRelaySnapshotListener(),
+		WithRelayErrorHandler(func(err error) {}),
+	)
+	assert.Equal(t, time.Minute, relay.pollInterval)
+	assert.Equal(t, 5, relay.batchSize)
+	assert.Equal(t, 3, relay.maxAttempts)
+	assert.True(t, relay.listen)
+	assert.NotNil(t, relay.errorHandler)
+}
+
+func TestOutboxEmitInTransaction(t *testing.T) {
+	clearAllDocuments(t, &OutboxEvent{})
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	// rolled back with business writes
+	errRollback := errors.New("rollback")
+	err = client.RunTransaction(ctx, func(ctx context.Context, client *Client) error {
+		if _, err := client.Set(ctx, &MyDocument{ID: "doc1", Name: "test"}); err != nil {
+			return err
+		}
+		if err := client.Emit(ctx, &OutboxEvent{Topic: "created"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+	var events []*OutboxEvent
+	require.NoError(t, client.Query(&events).GetAll(ctx))
+	assert.Empty(t, events)
+
+	event := &OutboxEvent{
+		Topic:      "created",
+		Payload:    "doc1",
+		Attributes: map[string]string{"key": "value"},
+	}
+	err = client.RunTransaction(ctx, func(ctx context.Context, client *Client) error {
+		if _, err := client.Set(ctx, &MyDocument{ID: "doc1", Name: "test"}); err != nil {
+			return err
+		}
+		return client.Emit(ctx, event)
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, event.ID)
+
+	got := &OutboxEvent{ID: event.ID}
+	require.NoError(t, client.Get(ctx, got))
+	assert.Equal(t, "created", got.Topic)
+	assert.Equal(t, "doc1", got.Payload)
+	assert.Equal(t, map[string]string{"key": "value"}, got.Attributes)
+	assert.False(t, got.CreatedAt.IsZero())
+}
+
+func TestOutboxRelayDeliver(t *testing.T) {
+	clearAllDocuments(t, &OutboxEvent{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	for _, topic := range []string{"first", "second", "third"} {
+		require.NoError(t, client.Emit(ctx, &OutboxEvent{Topic: topic}))
+	}
+
+	var delivered []string
+	errPublish := errors.New("publish failed")
+	fail := "second"
+	relay := client.NewOutboxRelay(PublisherFunc(func(ctx context.Context, event *OutboxEvent) error {
+		if event.Topic == fail {
+			return errPublish
+		}
+		delivered = append(delivered, event.Topic)
+		return nil
+	}))
+
+	// stops at the failure to keep the order
+	n, err := relay.Deliver(ctx)
+	require.ErrorIs(t, err, errPublish)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"first"}, delivered)
+
+	var events []*OutboxEvent
+	require.NoError(t, client.Query(&events).OrderBy("CreatedAt", firestore.Asc).GetAll(ctx))
+	require.Len(t, events, 2)
+	assert.Equal(t, "second", events[0].Topic)
+	assert.Equal(t, 1, events[0].Attempts)
+	assert.Equal(t, errPublish.Error(), events[0].LastError)
+
+	fail = ""
+	n, err = relay.Deliver(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"first", "second", "third"}, delivered)
+
+	// delivered events are removed
+	events = nil
+	require.NoError(t, client.Query(&events).GetAll(ctx))
+	assert.Empty(t, events)
+}
+
+func TestOutboxRelayDeadLetter(t *testing.T) {
+	clearAllDocuments(t, &OutboxEvent{})
+	clearAllDocuments(t, &OutboxDeadEvent{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	for _, topic := range []string{"poison", "second"} {
+		require.NoError(t, client.Emit(ctx, &OutboxEvent{Topic: topic}))
+	}
+
+	var delivered []string
+	errPublish := errors.New("publish failed")
+	relay := client.NewOutboxRelay(PublisherFunc(func(ctx context.Context, event *OutboxEvent) error {
+		if event.Topic == "poison" {
+			return errPublish
+		}
+		delivered = append(delivered, event.Topic)
+		return nil
+	}), WithRelayMaxAttempts(2))
+
+	n, err := relay.Deliver(ctx)
+	require.ErrorIs(t, err, errPublish)
+	assert.Equal(t, 0, n)
+	var events []*OutboxEvent
+	require.NoError(t, client.Query(&events).OrderBy("CreatedAt", firestore.Asc).GetAll(ctx))
+	require.Len(t, events, 2)
+	createdAt := events[0].CreatedAt
+
+	// moved to the dead letter collection and doesn't block following events
+	n, err = relay.Deliver(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"second"}, delivered)
+
+	events = nil
+	require.NoError(t, client.Query(&events).GetAll(ctx))
+	assert.Empty(t, events)
+	var dead []*OutboxDeadEvent
+	require.NoError(t, client.Query(&dead).GetAll(ctx))
+	require.Len(t, dead, 1)
+	assert.Equal(t, "poison", dead[0].Topic)
+	assert.Equal(t, 2, dead[0].Attempts)
+	assert.Equal(t, errPublish.Error(), dead[0].LastError)
+	assert.Equal(t, createdAt, dead[0].CreatedAt)
+}
+
+func TestOutboxRelayRun(t *testing.T) {
+	clearAllDocuments(t, &OutboxEvent{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	delivered := make(chan string, 10)
+	relay := client.NewOutboxRelay(
+		PublisherFunc(func(ctx context.Context, event *OutboxEvent) error {
+			delivered <- event.Topic
+			return nil
+		}),
+		WithRelayPollInterval(100*time.Millisecond),
+		WithRelaySnapshotListener(),
+	)
+	done := make(chan error, 1)
+	go func() {
+		done <- relay.Run(ctx)
+	}()
+
+	require.NoError(t, client.Emit(ctx, &OutboxEvent{Topic: "event"}))
+	select {
+	case topic := <-delivered:
+		assert.Equal(t, "event", topic)
+	case <-ctx.Done():
+		require.Fail(t, "event is not delivered")
+	}
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -3,6 +3,9 @@ package simplestore
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/firestore/apiv1/firestorepb"
@@ -10,9 +13,24 @@ import (
 )
 
 type Query struct {
-	q           firestore.Query
-	tb          *targetBuilder
-	transaction *firestore.Transaction
+	q          firestore.Query
+	tb         *targetBuilder
+	client     *Client
+	path       string
+	conditions []QueryCondition
+	withs      []childrenLoad
+}
+
+// QueryCondition describes a condition applied to a query
+type QueryCondition struct {
+	// Method is the name of the method applied: `Where`, `OrderBy`, `Limit` and so on.
+	Method string
+	// Path is the field path for `Where` and `OrderBy`.
+	Path string
+	// Op is the operator for `Where`, or the direction (`asc` or `desc`) for `OrderBy`.
+	Op string
+	// Values are values passed to the method.
+	Values []any
 }
 
 // QuerySafe starts a new query for target
@@ -24,9 +42,10 @@ func (c *Client) QuerySafe(target any) (*Query, error) {
 		return nil, err
 	}
 	return &Query{
-		q:           collection.Query,
-		tb:          tb,
-		transaction: c.FirestoreTransaction,
+		q:      collection.Query,
+		tb:     tb,
+		client: c,
+		path:   relativePath(collection.Path),
 	}, nil
 }
 
@@ -51,9 +70,9 @@ func (c *Client) QueryGroupSafe(target any) (*Query, error) {
 		return nil, err
 	}
 	return &Query{
-		q:           cgroup.Query,
-		tb:          tb,
-		transaction: c.FirestoreTransaction,
+		q:      cgroup.Query,
+		tb:     tb,
+		client: c,
 	}, nil
 }
 
@@ -74,14 +93,15 @@ func (c *Client) QueryGroup(target any) *Query {
 // target must be a pointer to slice of pointers to structs.
 // target is also used as destination of `GetAll()`.
 func (c *Client) QueryNestedSafe(parent any, target any) (*Query, error) {
-	cgroup, tb, err := c.getNestedCollectionRef(parent, target)
+	collection, tb, err := c.getNestedCollectionRef(parent, target)
 	if err != nil {
 		return nil, err
 	}
 	return &Query{
-		q:           cgroup.Query,
-		tb:          tb,
-		transaction: c.FirestoreTransaction,
+		q:      collection.Query,
+		tb:     tb,
+		client: c,
+		path:   relativePath(collection.Path),
 	}, nil
 }
 
@@ -99,35 +119,65 @@ func (c *Client) QueryNested(parent any, target any) *Query {
 
 // Iter runs query and calls callback for each document
 // A pointer to a struct is passed.
+// With `With`, results are buffered until children are loaded.
 func (q *Query) Iter(ctx context.Context, f func(o any) error) error {
-	var iter *firestore.DocumentIterator
-	if q.transaction == nil {
-		iter = q.q.Documents(ctx)
-	} else {
-		iter = q.transaction.Documents(q.q)
+	if len(q.withs) == 0 {
+		return q.iter(ctx, f)
 	}
-	defer iter.Stop()
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		dst := q.tb.createElement()
-		err = doc.DataTo(dst)
-		if err != nil {
+	results := reflect.MakeSlice(reflect.SliceOf(reflect.PointerTo(q.tb.elementType)), 0, 0)
+	err := q.iter(ctx, func(o any) error {
+		results = reflect.Append(results, reflect.ValueOf(o))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, w := range q.withs {
+		if err := q.client.Load(ctx, results.Interface(), w.field, w.opts...); err != nil {
 			return err
 		}
-		err = f(dst)
-		if err != nil {
+	}
+	for i := 0; i < results.Len(); i++ {
+		if err := f(results.Index(i).Interface()); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func (q *Query) iter(ctx context.Context, f func(o any) error) error {
+	_, err := q.client.invoke(ctx, q.newOperation(OperationQuery), func(ctx context.Context, op *Operation) (any, error) {
+		var iter *firestore.DocumentIterator
+		if q.client.FirestoreTransaction == nil {
+			iter = q.q.Documents(ctx)
+		} else {
+			iter = q.client.FirestoreTransaction.Documents(q.q)
+		}
+		defer iter.Stop()
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			dst := q.tb.createElement()
+			err = q.client.dataTo(ctx, doc, dst)
+			if err != nil {
+				return nil, err
+			}
+			op.ResultCount++
+			err = f(dst)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
 // GetAll runs query and retrieve all results
 // results are stored to `target` passed in `Query()`, `QueryGroup()` or `QueryNested()`
 func (q *Query) GetAll(ctx context.Context) error {
@@ -139,76 +189,138 @@ func (q *Query) GetAll(ctx context.Context) error {
 
 // Where sets the condition for the query
 func (q *Query) Where(path, op string, value interface{}) *Query {
-	newQ := *q
-	newQ.q = q.q.Where(path, op, value)
-	return &newQ
+	return q.with(q.q.Where(path, op, value), QueryCondition{Method: "Where", Path: path, Op: op, Values: []any{value}})
 }
 
 // OrderBy sets the order of the query result
 func (q *Query) OrderBy(path string, dir firestore.Direction) *Query {
-	newQ := *q
-	newQ.q = q.q.OrderBy(path, dir)
-	return &newQ
+	return q.with(q.q.OrderBy(path, dir), QueryCondition{Method: "OrderBy", Path: path, Op: directionName(dir)})
 }
 
 // Offset sets the offset of the query
 func (q *Query) Offset(n int) *Query {
-	newQ := *q
-	newQ.q = q.q.Offset(n)
-	return &newQ
+	return q.with(q.q.Offset(n), QueryCondition{Method: "Offset", Values: []any{n}})
 }
 
 // Limit sets the max count of documents of the query
 func (q *Query) Limit(n int) *Query {
-	newQ := *q
-	newQ.q = q.q.Limit(n)
-	return &newQ
+	return q.with(q.q.Limit(n), QueryCondition{Method: "Limit", Values: []any{n}})
 }
 
 // LimitToLast sets the max count of documents of the query
 func (q *Query) LimitToLast(n int) *Query {
-	newQ := *q
-	newQ.q = q.q.LimitToLast(n)
-	return &newQ
+	return q.with(q.q.LimitToLast(n), QueryCondition{Method: "LimitToLast", Values: []any{n}})
 }
 
 // StartAt sets the start position of the query
 func (q *Query) StartAt(docSnapshotOrFieldValues ...interface{}) *Query {
-	newQ := *q
-	newQ.q = q.q.StartAt(docSnapshotOrFieldValues...)
-	return &newQ
+	return q.with(q.q.StartAt(docSnapshotOrFieldValues...), QueryCondition{Method: "StartAt", Values: docSnapshotOrFieldValues})
 }
 
 // StartAt sets the start position of the query
 func (q *Query) StartAfter(docSnapshotOrFieldValues ...interface{}) *Query {
-	newQ := *q
-	newQ.q = q.q.StartAfter(docSnapshotOrFieldValues...)
-	return &newQ
+	return q.with(q.q.StartAfter(docSnapshotOrFieldValues...), QueryCondition{Method: "StartAfter", Values: docSnapshotOrFieldValues})
 }
 
 // EndAt sets the end position of the query
 func (q *Query) EndAt(docSnapshotOrFieldValues ...interface{}) *Query {
-	newQ := *q
-	newQ.q = q.q.EndAt(docSnapshotOrFieldValues...)
-	return &newQ
+	return q.with(q.q.EndAt(docSnapshotOrFieldValues...), QueryCondition{Method: "EndAt", Values: docSnapshotOrFieldValues})
 }
 
 // EndBefore set the end position of the query
 func (q *Query) EndBefore(docSnapshotOrFieldValues ...interface{}) *Query {
+	return q.with(q.q.EndBefore(docSnapshotOrFieldValues...), QueryCondition{Method: "EndBefore", Values: docSnapshotOrFieldValues})
+}
+
+func (q *Query) with(fq firestore.Query, cond QueryCondition) *Query {
 	newQ := *q
-	newQ.q = q.q.EndBefore(docSnapshotOrFieldValues...)
+	newQ.q = fq
+	// avoid sharing the backing array with other queries
+	newQ.conditions = append(q.conditions[:len(q.conditions):len(q.conditions)], cond)
 	return &newQ
 }
 
+func directionName(dir firestore.Direction) string {
+	if dir == firestore.Desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// Conditions returns conditions applied to the query in order
+func (q *Query) Conditions() []QueryCondition {
+	return q.conditions
+}
+
+// Shape returns the structure of the query without values
+// e.g. `Where(Name ==) OrderBy(Name desc) Limit`
+// Useful to group queries in logs and metrics.
+func (q *Query) Shape() string {
+	shapes := make([]string, 0, len(q.conditions))
+	for _, cond := range q.conditions {
+		if cond.Path != "" {
+			shapes = append(shapes, fmt.Sprintf("%s(%s %s)", cond.Method, cond.Path, cond.Op))
+		} else {
+			shapes = append(shapes, cond.Method)
+		}
+	}
+	return strings.Join(shapes, " ")
+}
+
+// Count returns the number of documents matching the query
+// Counted with an aggregation query, billed one read per up to 1000 matching documents.
+// With clients from `AtReadTime`, aggregations don't support read times
+// and matching documents are listed without fields, billed one read per matching document.
 func (q *Query) Count(ctx context.Context) (int64, error) {
-	results, err := q.q.NewAggregationQuery().WithCount("all").Get(ctx)
+	result, err := q.client.invoke(ctx, q.newOperation(OperationCount), func(ctx context.Context, op *Operation) (any, error) {
+		if !q.client.readTime.IsZero() {
+			// aggregations don't support read times
+			count, err := q.countDocuments(ctx)
+			op.ResultCount = int(count)
+			return count, err
+		}
+		results, err := q.q.NewAggregationQuery().WithCount("all").Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		count, ok := results["all"]
+		if !ok {
+			return nil, errors.New("firestore: couldn't get alias for COUNT from results")
+		}
+		countValue := count.(*firestorepb.Value).GetIntegerValue()
+		op.ResultCount = int(countValue)
+		return countValue, nil
+	})
 	if err != nil {
 		return 0, err
 	}
-	count, ok := results["all"]
-	if !ok {
-		return 0, errors.New("firestore: couldn't get alias for COUNT from results")
+	count, _ := result.(int64)
+	return count, nil
+}
+
+// countDocuments counts documents by reading only their names
+func (q *Query) countDocuments(ctx context.Context) (int64, error) {
+	iter := q.q.Select().Documents(ctx)
+	defer iter.Stop()
+	var count int64
+	for {
+		_, err := iter.Next()
+		if err == iterator.Done {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+}
+
+func (q *Query) newOperation(kind OperationKind) *Operation {
+	return &Operation{
+		Kind:       kind,
+		Collection: q.tb.collectionName,
+		Path:       q.path,
+		ReadOnly:   q.tb.readOnly,
+		Target:     q,
 	}
-	countValue := count.(*firestorepb.Value)
-	return countValue.GetIntegerValue(), nil
 }
@@ -94,3 +94,23 @@ func TestCount(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, int64(1), count)
 }
+
+func TestQueryShape(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	assert.NoError(t, err)
+
+	var docs []*MyDocument
+	q := client.Query(&docs)
+	q2 := q.Where("Name", "==", "Alice").OrderBy("Name", firestore.Desc)
+	q3 := q2.Limit(10)
+
+	assert.Equal(t, "", q.Shape())
+	assert.Equal(t, "Where(Name ==) OrderBy(Name desc)", q2.Shape())
+	assert.Equal(t, "Where(Name ==) OrderBy(Name desc) Limit", q3.Shape())
+	assert.Equal(t, []QueryCondition{
+		{Method: "Where", Path: "Name", Op: "==", Values: []any{"Alice"}},
+		{Method: "OrderBy", Path: "Name", Op: "desc"},
+		{Method: "Limit", Values: []any{10}},
+	}, q3.Conditions())
+}
@@ -0,0 +1,480 @@
+/*
+Package queue provides a durable job queue backed by firestore documents.
+
+	q := queue.New[Email](client, "emails")
+	_, err := q.Enqueue(ctx, Email{To: "alice@example.com"}, queue.WithDelay(time.Minute))
+
+	// runs until ctx is canceled
+	err := q.Run(ctx, func(ctx context.Context, job *queue.Job[Email]) error {
+		return send(job.Payload)
+	})
+
+Jobs are stored in the `_jobs` collection, and jobs failed too many times are moved to the `_jobs_dead` collection.
+Workers claim jobs in transactions, and claimed jobs are hidden from other workers for the visibility timeout.
+Jobs not completed in the visibility timeout are claimed again, so handlers should be idempotent.
+Claiming jobs requires a composite index on `Queue` and `RunAt`.
+*/
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ikedam/simplestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultCollection is the default collection for jobs
+	DefaultCollection = "_jobs"
+	// DefaultDeadLetterCollection is the default collection for jobs failed too many times
+	DefaultDeadLetterCollection = "_jobs_dead"
+	// DefaultVisibilityTimeout is the default duration to hide claimed jobs from other workers
+	DefaultVisibilityTimeout = 30 * time.Second
+	// DefaultMaxAttempts is the default max number of attempts for a job
+	DefaultMaxAttempts = 5
+	// DefaultMinBackoff is the default delay before the first retry
+	DefaultMinBackoff = 1 * time.Second
+	// DefaultMaxBackoff is the default max delay before retries
+	DefaultMaxBackoff = 5 * time.Minute
+	// DefaultPollInterval is the default interval to poll jobs when the queue is empty
+	DefaultPollInterval = 1 * time.Second
+)
+
+// ErrDuplicate indicates a job with the same dedup key exists
+var ErrDuplicate = errors.New("queue: duplicate job")
+
+// Job is a job in a queue
+type Job[T any] struct {
+	ID    string
+	Queue string
+	// Payload is the typed content of the job.
+	Payload T
+	// RunAt is the time the job can be claimed.
+	// Delayed for enqueuing with a delay, retries with backoff and visibility timeouts of claimed jobs.
+	RunAt time.Time
+	// Attempts is the number of claims.
+	Attempts int
+	// Owner is the worker claiming the job.
+	Owner string
+	// LastError is the error of the last attempt.
+	LastError string
+	// DedupKey is the key specified with `WithDedupKey`.
+	DedupKey  string
+	CreatedAt time.Time
+}
+
+// DeadJob is a job failed too many times
+type DeadJob[T any] Job[T]
+
+type config struct {
+	collection           string
+	deadLetterCollection string
+	visibilityTimeout    time.Duration
+	maxAttempts          int
+	minBackoff           time.Duration
+	maxBackoff           time.Duration
+	pollInterval         time.Duration
+	concurrency          int
+	owner                string
+	errorHandler         func(err error)
+	now                  func() time.Time
+}
+
+// Option configures Queue
+type Option func(*config)
+
+// WithCollection specifies the collection for jobs
+// Defaults to DefaultCollection.
+func WithCollection(collection string) Option {
+	return func(c *config) {
+		c.collection = collection
+	}
+}
+
+// WithDeadLetterCollection specifies the collection for jobs failed too many times
+// Defaults to DefaultDeadLetterCollection.
+func WithDeadLetterCollection(collection string) Option {
+	return func(c *config) {
+		c.deadLetterCollection = collection
+	}
+}
+
+// WithVisibilityTimeout specifies the duration to hide claimed jobs from other workers
+// Defaults to DefaultVisibilityTimeout.
+func WithVisibilityTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.visibilityTimeout = timeout
+	}
+}
+
+// WithMaxAttempts specifies the max number of attempts before moving jobs to the dead letter collection
+// Defaults to DefaultMaxAttempts.
+func WithMaxAttempts(n int) Option {
+	return func(c *config) {
+		c.maxAttempts = n
+	}
+}
+
+// WithBackoff specifies delays before retries
+// Delays start with min and double for each attempt up to max.
+// Defaults to DefaultMinBackoff and DefaultMaxBackoff.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *config) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// WithPollInterval specifies the interval to poll jobs when the queue is empty
+// Defaults to DefaultPollInterval.
+func WithPollInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.pollInterval = interval
+	}
+}
+
+// WithConcurrency specifies the number of jobs handled concurrently in `Run`
+// Defaults to 1.
+func WithConcurrency(n int) Option {
+	return func(c *config) {
+		c.concurrency = n
+	}
+}
+
+// WithOwner specifies the name of the worker recorded to claimed jobs
+// Must be unique among workers. Defaults to the host name with a random suffix.
+func WithOwner(owner string) Option {
+	return func(c *config) {
+		c.owner = owner
+	}
+}
+
+// WithErrorHandler specifies the function called with errors to complete or fail jobs in `Run`
+// `Run` keeps processing jobs after calling it: the jobs are claimed again after the visibility timeout.
+// Defaults to logging with the standard logger.
+func WithErrorHandler(f func(err error)) Option {
+	return func(c *config) {
+		c.errorHandler = f
+	}
+}
+
+// Queue is a job queue with payloads of T
+type Queue[T any] struct {
+	config
+	client *simplestore.Client
+	name   string
+}
+
+// New returns a queue named name
+// The queue resolves collections of `Job[T]` and `DeadJob[T]` on its own, and client is not changed.
+func New[T any](client *simplestore.Client, name string, opts ...Option) *Queue[T] {
+	q := &Queue[T]{
+		config: config{
+			collection:           DefaultCollection,
+			deadLetterCollection: DefaultDeadLetterCollection,
+			visibilityTimeout:    DefaultVisibilityTimeout,
+			maxAttempts:          DefaultMaxAttempts,
+			minBackoff:           DefaultMinBackoff,
+			maxBackoff:           DefaultMaxBackoff,
+			pollInterval:         DefaultPollInterval,
+			concurrency:          1,
+			now:                  time.Now,
+		},
+		name: name,
+	}
+	for _, opt := range opts {
+		opt(&q.config)
+	}
+	if q.owner == "" {
+		q.owner = defaultOwner()
+	}
+	if q.errorHandler == nil {
+		q.errorHandler = func(err error) {
+			log.Print(err)
+		}
+	}
+	if q.concurrency < 1 {
+		q.concurrency = 1
+	}
+	q.client = q.withCollections(client)
+	return q
+}
+
+// defaultOwner returns the host name with a random suffix to distinguish workers on the host
+func defaultOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// withCollections returns a client resolving collections of the queue
+func (q *Queue[T]) withCollections(client *simplestore.Client) *simplestore.Client {
+	return client.WithTableMaps(map[string]string{
+		reflect.TypeOf(Job[T]{}).Name():     q.collection,
+		reflect.TypeOf(DeadJob[T]{}).Name(): q.deadLetterCollection,
+	})
+}
+
+type enqueueConfig struct {
+	delay    time.Duration
+	dedupKey string
+}
+
+// EnqueueOption configures `Enqueue`
+type EnqueueOption func(*enqueueConfig)
+
+// WithDelay delays the job
+func WithDelay(delay time.Duration) EnqueueOption {
+	return func(c *enqueueConfig) {
+		c.delay = delay
+	}
+}
+
+// WithDedupKey specifies the key to deduplicate jobs
+// `Enqueue` returns ErrDuplicate while a job with the same key is in the queue.
+func WithDedupKey(key string) EnqueueOption {
+	return func(c *enqueueConfig) {
+		c.dedupKey = key
+	}
+}
+
+// In returns a queue using client
+// Pass the client in `RunTransaction` to enqueue jobs in the transaction.
+func (q *Queue[T]) In(client *simplestore.Client) *Queue[T] {
+	newQ := *q
+	newQ.client = q.withCollections(client)
+	return &newQ
+}
+
+// Enqueue adds a job to the queue
+// Use `In` to enqueue in a transaction.
+// In a transaction, jobs with dedup keys are read to return ErrDuplicate as creates fail only on commit,
+// so enqueue them before writes in the transaction.
+func (q *Queue[T]) Enqueue(ctx context.Context, payload T, opts ...EnqueueOption) (*Job[T], error) {
+	cfg := &enqueueConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	now := q.now()
+	job := &Job[T]{
+		Queue:     q.name,
+		Payload:   payload,
+		RunAt:     now.Add(cfg.delay),
+		DedupKey:  cfg.dedupKey,
+		CreatedAt: now,
+	}
+	if cfg.dedupKey != "" {
+		sum := sha256.Sum256([]byte(q.name + "\x00" + cfg.dedupKey))
+		job.ID = hex.EncodeToString(sum[:])
+		if q.client.FirestoreTransaction != nil {
+			err := q.client.Get(ctx, &Job[T]{ID: job.ID})
+			if err == nil {
+				return nil, ErrDuplicate
+			}
+			if status.Code(err) != codes.NotFound {
+				return nil, err
+			}
+		}
+	}
+	_, err := q.client.Create(ctx, job)
+	if status.Code(err) == codes.AlreadyExists {
+		return nil, ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Claim claims a job ready to run
+// Returns nil if no jobs are ready.
+// The job is hidden from other workers for the visibility timeout.
+func (q *Queue[T]) Claim(ctx context.Context) (*Job[T], error) {
+	var claimed *Job[T]
+	err := q.client.RunTransaction(ctx, func(ctx context.Context, client *simplestore.Client) error {
+		claimed = nil
+		now := q.now()
+		var jobs []*Job[T]
+		err := client.Query(&jobs).
+			Where("Queue", "==", q.name).
+			Where("RunAt", "<=", now).
+			OrderBy("RunAt", firestore.Asc).
+			Limit(1).
+			GetAll(ctx)
+		if err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+		job := jobs[0]
+		job.Attempts++
+		job.Owner = q.owner
+		job.RunAt = now.Add(q.visibilityTimeout)
+		if _, err := client.Set(ctx, job); err != nil {
+			return err
+		}
+		claimed = job
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// Complete removes the claimed job from the queue
+// Does nothing if the job is claimed again by another worker.
+func (q *Queue[T]) Complete(ctx context.Context, job *Job[T]) error {
+	return q.updateClaimed(ctx, job, func(client *simplestore.Client, current *Job[T]) error {
+		_, err := client.Delete(ctx, current)
+		return err
+	})
+}
+
+// Fail records the failure of the claimed job
+// The job is retried with backoff, or moved to the dead letter collection after max attempts.
+// Does nothing if the job is claimed again by another worker.
+func (q *Queue[T]) Fail(ctx context.Context, job *Job[T], cause error) error {
+	return q.updateClaimed(ctx, job, func(client *simplestore.Client, current *Job[T]) error {
+		current.LastError = cause.Error()
+		current.Owner = ""
+		if current.Attempts >= q.maxAttempts {
+			dead := DeadJob[T](*current)
+			if _, err := client.Set(ctx, &dead); err != nil {
+				return err
+			}
+			_, err := client.Delete(ctx, current)
+			return err
+		}
+		current.RunAt = q.now().Add(q.backoff(current.Attempts))
+		_, err := client.Set(ctx, current)
+		return err
+	})
+}
+
+func (q *Queue[T]) backoff(attempts int) time.Duration {
+	d := q.minBackoff
+	for i := 1; i < attempts && d < q.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > q.maxBackoff {
+		d = q.maxBackoff
+	}
+	return d
+}
+
+func (q *Queue[T]) updateClaimed(ctx context.Context, job *Job[T], f func(client *simplestore.Client, current *Job[T]) error) error {
+	return q.client.RunTransaction(ctx, func(ctx context.Context, client *simplestore.Client) error {
+		current := &Job[T]{ID: job.ID}
+		err := client.Get(ctx, current)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if current.Attempts != job.Attempts || current.Owner != job.Owner {
+			// claimed again after the visibility timeout
+			return nil
+		}
+		return f(client, current)
+	})
+}
+
+// Handler handles a job
+// Returning an error retries the job.
+type Handler[T any] func(ctx context.Context, job *Job[T]) error
+
+// Run claims and handles jobs until ctx is canceled
+// Jobs being handled when ctx is canceled are completed with a context without cancel.
+// Returns nil when stopped by ctx, or the first error to claim jobs.
+// Errors to complete or fail jobs are passed to the error handler (see `WithErrorHandler`).
+func (q *Queue[T]) Run(ctx context.Context, h Handler[T]) error {
+	// stop other workers on errors
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	errCh := make(chan error, q.concurrency)
+	for i := 0; i < q.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := q.work(ctx, h); err != nil {
+				errCh <- err
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	return <-errCh
+}
+
+func (q *Queue[T]) work(ctx context.Context, h Handler[T]) error {
+	for ctx.Err() == nil {
+		job, err := q.Claim(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(q.pollInterval):
+			}
+			continue
+		}
+		// let the job finish on shutdown
+		if err := q.handle(detachedContext{parent: ctx}, job, h); err != nil {
+			q.errorHandler(fmt.Errorf("queue %s: job %s: %w", q.name, job.ID, err))
+		}
+	}
+	return nil
+}
+
+func (q *Queue[T]) handle(ctx context.Context, job *Job[T], h Handler[T]) error {
+	if err := h(ctx, job); err != nil {
+		return q.Fail(ctx, job, err)
+	}
+	return q.Complete(ctx, job)
+}
+
+// detachedContext carries values of the parent without its cancellation and deadline
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key any) any {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,301 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ikedam/simplestore"
+	"github.com/ikedam/simplestore/simplestoretest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Email struct {
+	To      string
+	Subject string
+}
+
+type QueueTestSuite struct {
+	simplestoretest.FirestoreTestSuite
+}
+
+func TestQueueTestSuite(t *testing.T) {
+	suite.Run(t, new(QueueTestSuite))
+}
+
+func TestBackoff(t *testing.T) {
+	q := &Queue[Email]{
+		config: config{
+			minBackoff: time.Second,
+			maxBackoff: 10 * time.Second,
+		},
+	}
+	assert.Equal(t, time.Second, q.backoff(1))
+	assert.Equal(t, 2*time.Second, q.backoff(2))
+	assert.Equal(t, 8*time.Second, q.backoff(4))
+	assert.Equal(t, 10*time.Second, q.backoff(5))
+	assert.Equal(t, 10*time.Second, q.backoff(100))
+}
+
+func TestNewKeepsClient(t *testing.T) {
+	ctx := context.Background()
+	client, err := simplestore.NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	q := New[Email](client, "emails", WithCollection("jobs"))
+	other := New[Email](client, "others", WithCollection("other_jobs"))
+	job := &Job[Email]{ID: "a"}
+	assert.Equal(t, "jobs", q.client.GetDocumentRef(job).Parent.ID)
+	assert.Equal(t, "other_jobs", other.client.GetDocumentRef(job).Parent.ID)
+	assert.Equal(t, "other_jobs", other.In(client).client.GetDocumentRef(job).Parent.ID)
+	// the shared client is not changed: the type name with the package path isn't a valid collection
+	assert.Nil(t, client.GetDocumentRef(job))
+}
+
+func TestNewOwner(t *testing.T) {
+	ctx := context.Background()
+	client, err := simplestore.NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	// workers on the same host are distinguished
+	host, err := os.Hostname()
+	require.NoError(t, err)
+	q := New[Email](client, "emails")
+	other := New[Email](client, "emails")
+	assert.True(t, strings.HasPrefix(q.owner, host+"-"), q.owner)
+	assert.NotEqual(t, q.owner, other.owner)
+	assert.Equal(t, "worker1", New[Email](client, "emails", WithOwner("worker1")).owner)
+}
+
+func (s *QueueTestSuite) TestEnqueueAndClaim() {
+	ctx := context.Background()
+	now := time.Now()
+	q := New[Email](s.SimplestoreClient, "emails", WithVisibilityTimeout(time.Minute))
+	q.now = func() time.Time { return now }
+
+	job, err := q.Enqueue(ctx, Email{To: "alice@example.com"})
+	s.Require().NoError(err)
+	s.NotEmpty(job.ID)
+	_, err = q.Enqueue(ctx, Email{To: "bob@example.com"}, WithDelay(time.Hour))
+	s.Require().NoError(err)
+	// other queues are independent
+	_, err = New[Email](s.SimplestoreClient, "others").Enqueue(ctx, Email{To: "carol@example.com"})
+	s.Require().NoError(err)
+
+	claimed, err := q.Claim(ctx)
+	s.Require().NoError(err)
+	s.Require().NotNil(claimed)
+	s.Equal("alice@example.com", claimed.Payload.To)
+	s.Equal(1, claimed.Attempts)
+
+	// hidden for the visibility timeout, and bob is delayed
+	claimed2, err := q.Claim(ctx)
+	s.Require().NoError(err)
+	s.Nil(claimed2)
+
+	// claimed again after the visibility timeout
+	q.now = func() time.Time { return now.Add(2 * time.Minute) }
+	claimed2, err = q.Claim(ctx)
+	s.Require().NoError(err)
+	s.Require().NotNil(claimed2)
+	s.Equal(claimed.ID, claimed2.ID)
+	s.Equal(2, claimed2.Attempts)
+
+	// the stale claim is ignored
+	s.Require().NoError(q.Complete(ctx, claimed))
+	s.Require().NoError(s.SimplestoreClient.Get(ctx, &Job[Email]{ID: claimed.ID}))
+	s.Require().NoError(q.Complete(ctx, claimed2))
+	err = s.SimplestoreClient.Get(ctx, &Job[Email]{ID: claimed.ID})
+	s.Equal(codes.NotFound, status.Code(err))
+}
+
+func (s *QueueTestSuite) TestDedupKey() {
+	ctx := context.Background()
+	q := New[Email](s.SimplestoreClient, "emails")
+
+	_, err := q.Enqueue(ctx, Email{To: "alice@example.com"}, WithDedupKey("welcome/alice"))
+	s.Require().NoError(err)
+	_, err = q.Enqueue(ctx, Email{To: "alice@example.com"}, WithDedupKey("welcome/alice"))
+	s.ErrorIs(err, ErrDuplicate)
+	// keys are per queue
+	_, err = New[Email](s.SimplestoreClient, "others").Enqueue(ctx, Email{}, WithDedupKey("welcome/alice"))
+	s.Require().NoError(err)
+
+	// in a transaction
+	err = s.SimplestoreClient.RunTransaction(ctx, func(ctx context.Context, client *simplestore.Client) error {
+		_, err := q.In(client).Enqueue(ctx, Email{To: "alice@example.com"}, WithDedupKey("welcome/alice"))
+		return err
+	})
+	s.ErrorIs(err, ErrDuplicate)
+
+	// can be enqueued again after completed
+	job, err := q.Claim(ctx)
+	s.Require().NoError(err)
+	s.Require().NoError(q.Complete(ctx, job))
+	_, err = q.Enqueue(ctx, Email{To: "alice@example.com"}, WithDedupKey("welcome/alice"))
+	s.Require().NoError(err)
+}
+
+func (s *QueueTestSuite) TestRetryAndDeadLetter() {
+	ctx := context.Background()
+	now := time.Now()
+	q := New[Email](s.SimplestoreClient, "emails", WithMaxAttempts(2), WithBackoff(time.Minute, time.Hour))
+	q.now = func() time.Time { return now }
+
+	_, err := q.Enqueue(ctx, Email{To: "alice@example.com"})
+	s.Require().NoError(err)
+
+	job, err := q.Claim(ctx)
+	s.Require().NoError(err)
+	s.Require().NoError(q.Fail(ctx, job, errors.New("temporary")))
+
+	// retried after the backoff
+	job, err = q.Claim(ctx)
+	s.Require().NoError(err)
+	s.Nil(job)
+	q.now = func() time.Time { return now.Add(2 * time.Minute) }
+	job, err = q.Claim(ctx)
+	s.Require().NoError(err)
+	s.Require().NotNil(job)
+	s.Equal("temporary", job.LastError)
+	s.Require().NoError(q.Fail(ctx, job, errors.New("permanent")))
+
+	// moved to the dead letter collection
+	q.now = func() time.Time { return now.Add(24 * time.Hour) }
+	job2, err := q.Claim(ctx)
+	s.Require().NoError(err)
+	s.Nil(job2)
+	dead := &DeadJob[Email]{ID: job.ID}
+	s.Require().NoError(s.SimplestoreClient.Get(ctx, dead))
+	s.Equal("permanent", dead.LastError)
+	s.Equal(2, dead.Attempts)
+	s.Equal("alice@example.com", dead.Payload.To)
+}
+
+func (s *QueueTestSuite) TestEnqueueInTransaction() {
+	ctx := context.Background()
+	q := New[Email](s.SimplestoreClient, "emails")
+
+	err := s.SimplestoreClient.RunTransaction(ctx, func(ctx context.Context, client *simplestore.Client) error {
+		if _, err := q.In(client).Enqueue(ctx, Email{To: "alice@example.com"}); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	s.Require().Error(err)
+	job, err := q.Claim(ctx)
+	s.Require().NoError(err)
+	s.Nil(job)
+}
+
+func (s *QueueTestSuite) TestRun() {
+	ctx := context.Background()
+	q := New[Email](s.SimplestoreClient, "emails", WithPollInterval(10*time.Millisecond), WithConcurrency(2), WithBackoff(0, 0))
+
+	for _, to := range []string{"alice@example.com", "bob@example.com", "carol@example.com"} {
+		_, err := q.Enqueue(ctx, Email{To: to})
+		s.Require().NoError(err)
+	}
+
+	var mu sync.Mutex
+	handled := map[string]int{}
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- q.Run(runCtx, func(ctx context.Context, job *Job[Email]) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handled[job.Payload.To]++
+			if job.Payload.To == "bob@example.com" && job.Attempts == 1 {
+				return errors.New("retry")
+			}
+			if len(handled) == 3 && handled["bob@example.com"] == 2 {
+				// graceful shutdown: the job is completed after cancel
+				cancel()
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		s.Require().NoError(err)
+	case <-time.After(10 * time.Second):
+		s.FailNow("timeout")
+	}
+	s.Equal(map[string]int{"alice@example.com": 1, "bob@example.com": 2, "carol@example.com": 1}, handled)
+	job, err := q.Claim(ctx)
+	s.Require().NoError(err)
+	s.Nil(job)
+}
+
+func (s *QueueTestSuite) TestRunErrorHandler() {
+	ctx := context.Background()
+	client := s.SimplestoreClient.WithTableMaps(nil)
+	var failed sync.Once
+	client.Use(func(next simplestore.Handler) simplestore.Handler {
+		return func(ctx context.Context, op *simplestore.Operation) (any, error) {
+			var err error
+			if op.Kind == simplestore.OperationDelete {
+				// the first completion fails
+				failed.Do(func() {
+					err = errors.New("test error")
+				})
+			}
+			if err != nil {
+				return nil, err
+			}
+			return next(ctx, op)
+		}
+	})
+	var mu sync.Mutex
+	var reported []error
+	q := New[Email](
+		client,
+		"emails",
+		WithPollInterval(10*time.Millisecond),
+		WithErrorHandler(func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, err)
+		}),
+	)
+	for _, to := range []string{"alice@example.com", "bob@example.com"} {
+		_, err := q.Enqueue(ctx, Email{To: to})
+		s.Require().NoError(err)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	handled := map[string]int{}
+	go func() {
+		done <- q.Run(runCtx, func(ctx context.Context, job *Job[Email]) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handled[job.Payload.To]++
+			if len(handled) == 2 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		s.Require().NoError(err)
+	case <-time.After(10 * time.Second):
+		s.FailNow("timeout")
+	}
+	// the error doesn't stop Run
+	s.Len(handled, 2)
+	s.Require().Len(reported, 1)
+	s.ErrorContains(reported[0], "test error")
+}
@@ -0,0 +1,67 @@
+package simplestore
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// AtReadTime returns a read-only client reading documents as of t
+// Reads of past data require point-in-time recovery (PITR) enabled in the database.
+// Read times are truncated to seconds.
+// The returned client has its own connection to firestore, as firestore applies read times per client:
+// close it with `Close` when it's no longer used.
+// Writes with the returned client fail with ProgrammingError,
+// and `RunTransaction` calls f without a transaction as reads at the time are consistent.
+func (c *Client) AtReadTime(t time.Time) (*Client, error) {
+	if t.IsZero() {
+		return nil, NewProgrammingError("read time is zero")
+	}
+	if c.FirestoreTransaction != nil {
+		return nil, NewProgrammingError("cannot read at a time in a transaction")
+	}
+	databaseID := c.DatabaseID
+	if databaseID == "" {
+		databaseID = firestore.DefaultDatabaseID
+	}
+	// the project detected for c
+	projectID := strings.SplitN(c.FirestoreClient.Collection("_").Path, "/", 3)[1]
+	// the context is used only to dial, which doesn't block
+	client, err := firestore.NewClientWithDatabase(context.Background(), projectID, databaseID, c.clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+	client.WithReadOptions(firestore.ReadTime(t))
+	view := *c
+	view.FirestoreClient = client
+	view.readTime = t
+	// the cache holds the latest documents
+	view.cache = nil
+	return &view, nil
+}
+
+// ReadTime returns the time the client reads documents at
+// Returns the zero time if the client reads the latest documents.
+func (c *Client) ReadTime() time.Time {
+	return c.readTime
+}
+
+// RunReadOnlyTransaction runs f in a read-only transaction
+// Same as `RunTransaction` with `firestore.ReadOnly`.
+// Reads in f are consistent, and writes fail with ProgrammingError.
+func (c *Client) RunReadOnlyTransaction(ctx context.Context, f func(ctx context.Context, client *Client) error) error {
+	return c.RunTransaction(ctx, f, firestore.ReadOnly)
+}
+
+// checkWritable returns an error if the client is read-only
+func (c *Client) checkWritable() error {
+	if !c.readTime.IsZero() {
+		return NewProgrammingErrorf("cannot write with a client reading at %v", c.readTime)
+	}
+	if c.readOnlyTransaction {
+		return NewProgrammingError("cannot write in a read-only transaction")
+	}
+	return nil
+}
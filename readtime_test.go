@@ -0,0 +1,115 @@
+package simplestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+)
+
+func TestAtReadTime(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.AtReadTime(time.Time{})
+	assertProgrammingError(t, err)
+
+	readTime := time.Now().Add(-time.Minute)
+	view, err := client.AtReadTime(readTime)
+	require.NoError(t, err)
+	defer view.Close()
+	assert.True(t, client.ReadTime().IsZero())
+	assert.Equal(t, readTime, view.ReadTime())
+	assert.NotSame(t, client.FirestoreClient, view.FirestoreClient)
+	assert.Equal(t, "testproject", view.ProjectID)
+
+	// writes are rejected
+	_, err = view.Create(ctx, &MyDocument{Name: "test"})
+	assertProgrammingError(t, err)
+	_, err = view.Set(ctx, &MyDocument{ID: "doc1"})
+	assertProgrammingError(t, err)
+	_, err = view.Delete(ctx, &MyDocument{ID: "doc1"})
+	assertProgrammingError(t, err)
+	assertProgrammingError(t, view.Counter(&MyDocument{ID: "doc1"}, "likes").Increment(ctx, 1))
+	assertProgrammingError(t, view.Restore(ctx, &MyDocument{ID: "doc1"}, 1))
+
+	// writes with unique fields are rejected without transactions
+	_, err = view.Create(ctx, &UniqueUser{Email: "test@example.com"})
+	assertProgrammingError(t, err)
+}
+
+func TestAtReadTimeClose(t *testing.T) {
+	t.Setenv("FIRESTORE_EMULATOR_HOST", "")
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject", option.WithoutAuthentication(), option.WithEndpoint("localhost:1"))
+	require.NoError(t, err)
+
+	view, err := client.AtReadTime(time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "testproject", view.ProjectID)
+	assert.Equal(t, "projects/testproject/databases/(default)/documents/MyDocument", view.FirestoreClient.Collection("MyDocument").Path)
+
+	// views have their own connections
+	require.NoError(t, view.Close())
+	require.NoError(t, client.Close())
+}
+
+func TestAtReadTimeRunTransaction(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	view, err := client.AtReadTime(time.Now())
+	require.NoError(t, err)
+	defer view.Close()
+	var ops []Operation
+	view.Use(recordOperations(&ops))
+
+	called := 0
+	err = view.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		called++
+		assert.Same(t, view, tc)
+		assert.Nil(t, tc.FirestoreTransaction)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, called)
+	require.Len(t, ops, 1)
+	assert.Equal(t, OperationTransaction, ops[0].Kind)
+	assert.Equal(t, view.ReadTime(), ops[0].ReadTime)
+}
+
+func TestRunReadOnlyTransaction(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	_, err = client.Set(ctx, &MyDocument{ID: "doc1", Name: "test"})
+	require.NoError(t, err)
+
+	err = client.RunReadOnlyTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		doc := &MyDocument{ID: "doc1"}
+		if err := tc.Get(ctx, doc); err != nil {
+			return err
+		}
+		assert.Equal(t, "test", doc.Name)
+		_, err := tc.Set(ctx, doc)
+		assertProgrammingError(t, err)
+		// no views in transactions
+		_, err = tc.AtReadTime(time.Now())
+		assertProgrammingError(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// read-only transactions don't affect the client
+	_, err = client.Set(ctx, &MyDocument{ID: "doc1", Name: "updated"})
+	require.NoError(t, err)
+}
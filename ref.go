@@ -0,0 +1,199 @@
+package simplestore
+
+import (
+	"context"
+	"reflect"
+
+	"cloud.google.com/go/firestore"
+)
+
+// RefTagOption is the tag option for fields to load referenced documents with `Preload`
+// The value is the name of the `*firestore.DocumentRef` field storing the reference.
+// Fields must be pointers to structs and also tagged with `firestore:"-"` not to be stored.
+// e.g. `firestore:"-" simplestore:"ref=Author"`
+const RefTagOption = "ref"
+
+// Ref is a typed reference to a document
+// Ref isn't stored itself: store `Ref.Ref` in `*firestore.DocumentRef` fields,
+// and wrap them with `NewRef` for typed access.
+type Ref[T any] struct {
+	Ref   *firestore.DocumentRef
+	value *T
+}
+
+// NewRef returns a Ref for the document ref
+func NewRef[T any](ref *firestore.DocumentRef) Ref[T] {
+	return Ref[T]{
+		Ref: ref,
+	}
+}
+
+// RefTo returns a Ref for the object
+// o is also used as the loaded value.
+func RefTo[T any](c *Client, o *T) (Ref[T], error) {
+	doc, err := c.GetDocumentRefSafe(o)
+	if err != nil {
+		return Ref[T]{}, err
+	}
+	return Ref[T]{
+		Ref:   doc,
+		value: o,
+	}, nil
+}
+
+// IsNil returns whether the reference is not set
+func (r Ref[T]) IsNil() bool {
+	return r.Ref == nil
+}
+
+// ID returns the ID of the referenced document
+// Returns an empty string if the reference is not set.
+func (r Ref[T]) ID() string {
+	if r.Ref == nil {
+		return ""
+	}
+	return r.Ref.ID
+}
+
+// Value returns the loaded value
+// Returns nil if not loaded with `Get`.
+func (r Ref[T]) Value() *T {
+	return r.value
+}
+
+// Get returns the referenced object
+// Retrieves the document from firestore if not loaded yet.
+// Returns nil if the reference is not set.
+func (r *Ref[T]) Get(ctx context.Context, c *Client) (*T, error) {
+	if r.Ref == nil {
+		return nil, nil
+	}
+	if r.value != nil {
+		return r.value, nil
+	}
+	o := new(T)
+	if err := c.FromRef(r.Ref, o); err != nil {
+		return nil, err
+	}
+	if err := c.Get(ctx, o); err != nil {
+		return nil, err
+	}
+	r.value = o
+	return o, nil
+}
+
+// Preload loads referenced documents of fields in docs
+// docs must be a slice of pointers to structs, and fields must be names of `*firestore.DocumentRef` fields.
+// Documents are filled to fields tagged with `simplestore:"ref=FIELD"`.
+// Documents are retrieved with a `GetAll` call for each type of referenced documents.
+// Fields of missing documents are left nil.
+func (c *Client) Preload(ctx context.Context, docs any, fields ...string) error {
+	docsV := reflect.ValueOf(docs)
+	if docsV.Kind() != reflect.Slice {
+		return NewProgrammingError("docs must be a slice of pointers to structs")
+	}
+	t := docsV.Type().Elem()
+	if t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct {
+		return NewProgrammingError("docs must be a slice of pointers to structs")
+	}
+	type preloadField struct {
+		ref   reflect.StructField
+		value reflect.StructField
+	}
+	preloadFields := make([]preloadField, 0, len(fields))
+	for _, field := range fields {
+		f, ok := t.Elem().FieldByName(field)
+		if !ok {
+			return NewProgrammingErrorf("field doesn't exist: %s.%s.%s", t.Elem().PkgPath(), t.Elem().Name(), field)
+		}
+		if f.Type != reflect.TypeOf((*firestore.DocumentRef)(nil)) {
+			return NewProgrammingErrorf("field must be *firestore.DocumentRef: %s.%s.%s", t.Elem().PkgPath(), t.Elem().Name(), field)
+		}
+		valueField, err := refValueField(t.Elem(), field)
+		if err != nil {
+			return err
+		}
+		preloadFields = append(preloadFields, preloadField{ref: f, value: valueField})
+	}
+
+	type refGroup struct {
+		objects reflect.Value
+		byPath  map[string]int
+		targets [][]reflect.Value
+	}
+	// group refs by the type: GetAll accepts documents in different collections
+	var order []reflect.Type
+	groups := map[reflect.Type]*refGroup{}
+	for i := 0; i < docsV.Len(); i++ {
+		v := docsV.Index(i)
+		if v.IsNil() {
+			continue
+		}
+		for _, field := range preloadFields {
+			ref, _ := v.Elem().FieldByIndex(field.ref.Index).Interface().(*firestore.DocumentRef)
+			if ref == nil {
+				continue
+			}
+			g, ok := groups[field.value.Type]
+			if !ok {
+				g = &refGroup{
+					objects: reflect.MakeSlice(reflect.SliceOf(field.value.Type), 0, 1),
+					byPath:  map[string]int{},
+				}
+				groups[field.value.Type] = g
+				order = append(order, field.value.Type)
+			}
+			idx, ok := g.byPath[ref.Path]
+			if !ok {
+				o := reflect.New(field.value.Type.Elem())
+				if err := c.FromRef(ref, o.Interface()); err != nil {
+					return err
+				}
+				idx = g.objects.Len()
+				g.byPath[ref.Path] = idx
+				g.objects = reflect.Append(g.objects, o)
+				g.targets = append(g.targets, nil)
+			}
+			g.targets[idx] = append(g.targets[idx], v.Elem().FieldByIndex(field.value.Index))
+		}
+	}
+
+	for _, key := range order {
+		g := groups[key]
+		result, err := c.GetAllDetailed(ctx, g.objects.Interface())
+		if err != nil {
+			return err
+		}
+		missing := make(map[int]bool, len(result.MissingIndexes))
+		for _, idx := range result.MissingIndexes {
+			missing[idx] = true
+		}
+		for idx, targets := range g.targets {
+			if missing[idx] {
+				continue
+			}
+			for _, target := range targets {
+				target.Set(g.objects.Index(idx))
+			}
+		}
+	}
+	return nil
+}
+
+// refValueField returns the field to fill with the document referenced by the field
+func refValueField(t reflect.Type, field string) (reflect.StructField, error) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if name, ok := tagOptionValue(f, RefTagOption); !ok || name != field {
+			continue
+		}
+		if f.Type.Kind() != reflect.Pointer || f.Type.Elem().Kind() != reflect.Struct {
+			return f, NewProgrammingErrorf("ref field must be a pointer to a struct: %s.%s.%s", t.PkgPath(), t.Name(), f.Name)
+		}
+		if firestoreFieldName(f) != "" {
+			return f, NewProgrammingErrorf("ref field must be tagged with firestore:\"-\": %s.%s.%s", t.PkgPath(), t.Name(), f.Name)
+		}
+		return f, nil
+	}
+	return reflect.StructField{}, NewProgrammingErrorf("no field is tagged with %s:\"%s=%s\": %s.%s", TagName, RefTagOption, field, t.PkgPath(), t.Name())
+}
@@ -0,0 +1,159 @@
+package simplestore
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Book struct {
+	ID          string
+	Title       string
+	Author      *firestore.DocumentRef
+	Editor      *firestore.DocumentRef
+	Owner       *firestore.DocumentRef
+	AuthorValue *MyDocument     `firestore:"-" simplestore:"ref=Author"`
+	EditorValue *MyDocument     `firestore:"-" simplestore:"ref=Editor"`
+	OwnerValue  *ParentDocument `firestore:"-" simplestore:"ref=Owner"`
+}
+
+func TestRef(t *testing.T) {
+	var r Ref[MyDocument]
+	assert.True(t, r.IsNil())
+	assert.Equal(t, "", r.ID())
+	assert.Nil(t, r.Value())
+	o, err := r.Get(context.Background(), &Client{})
+	assert.NoError(t, err)
+	assert.Nil(t, o)
+
+	r = NewRef[MyDocument](&firestore.DocumentRef{ID: "docid", Path: "MyDocument/docid"})
+	assert.False(t, r.IsNil())
+	assert.Equal(t, "docid", r.ID())
+}
+
+func TestPreloadInvalid(t *testing.T) {
+	ctx := context.Background()
+	client := &Client{}
+	assert.IsType(t, &ProgrammingError{}, client.Preload(ctx, &Book{}, "Author"))
+	assert.IsType(t, &ProgrammingError{}, client.Preload(ctx, []Book{}, "Author"))
+	assert.IsType(t, &ProgrammingError{}, client.Preload(ctx, []*Book{}, "Unknown"))
+	assert.IsType(t, &ProgrammingError{}, client.Preload(ctx, []*Book{}, "Title"))
+	type NoValueField struct {
+		ID     string
+		Author *firestore.DocumentRef
+	}
+	assert.IsType(t, &ProgrammingError{}, client.Preload(ctx, []*NoValueField{}, "Author"))
+	type StoredValueField struct {
+		ID          string
+		Author      *firestore.DocumentRef
+		AuthorValue *MyDocument `simplestore:"ref=Author"`
+	}
+	assert.IsType(t, &ProgrammingError{}, client.Preload(ctx, []*StoredValueField{}, "Author"))
+	assert.NoError(t, client.Preload(ctx, []*Book{nil, {}}, "Author", "Editor"))
+}
+
+func TestPreload(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	clearAllDocuments(t, &ParentDocument{})
+	clearAllDocuments(t, &Book{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	alice := &MyDocument{ID: "alice", Name: "Alice"}
+	bob := &MyDocument{ID: "bob", Name: "Bob"}
+	parent := &ParentDocument{ID: "parent1", Name: "Parent"}
+	for _, o := range []any{alice, bob, parent} {
+		_, err = client.Set(ctx, o)
+		require.NoError(t, err)
+	}
+
+	aliceRef, err := RefTo(client, alice)
+	require.NoError(t, err)
+	bobRef, err := RefTo(client, bob)
+	require.NoError(t, err)
+	parentRef, err := RefTo(client, parent)
+	require.NoError(t, err)
+	_, err = client.Set(ctx, &Book{ID: "book1", Author: aliceRef.Ref, Editor: bobRef.Ref, Owner: parentRef.Ref})
+	require.NoError(t, err)
+	_, err = client.Set(ctx, &Book{ID: "book2", Author: bobRef.Ref, Editor: client.FirestoreClient.Doc("MyDocument/nobody")})
+	require.NoError(t, err)
+
+	// stored as a bare reference
+	docsnap, err := client.FirestoreClient.Doc("Book/book1").Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, aliceRef.Ref.Path, docsnap.Data()["Author"].(*firestore.DocumentRef).Path)
+	assert.NotContains(t, docsnap.Data(), "AuthorValue")
+	var books []*Book
+	require.NoError(t, client.Query(&books).Where("Author", "==", aliceRef.Ref).GetAll(ctx))
+	require.Len(t, books, 1)
+	assert.Equal(t, "book1", books[0].ID)
+
+	books = []*Book{{ID: "book1"}, {ID: "book2"}}
+	_, err = client.GetAll(ctx, books)
+	require.NoError(t, err)
+
+	var ops []Operation
+	client.Use(recordOperations(&ops))
+	require.NoError(t, client.Preload(ctx, books, "Author", "Editor", "Owner"))
+	// one GetAll for each type
+	require.Len(t, ops, 2)
+	assert.Equal(t, OperationGetAll, ops[0].Kind)
+	assert.Len(t, ops[0].Paths, 3)
+	assert.Equal(t, OperationGetAll, ops[1].Kind)
+	assert.Len(t, ops[1].Paths, 1)
+
+	assert.Equal(t, "Alice", books[0].AuthorValue.Name)
+	assert.Equal(t, "Bob", books[0].EditorValue.Name)
+	assert.Equal(t, "Parent", books[0].OwnerValue.Name)
+	assert.Same(t, books[0].EditorValue, books[1].AuthorValue)
+	assert.Nil(t, books[1].EditorValue)
+	assert.Nil(t, books[1].OwnerValue)
+
+	// Get loads a reference
+	book := &Book{ID: "book1"}
+	require.NoError(t, client.Get(ctx, book))
+	author := NewRef[MyDocument](book.Author)
+	o, err := author.Get(ctx, client)
+	require.NoError(t, err)
+	assert.Equal(t, alice, o)
+	assert.Same(t, o, author.Value())
+}
+
+type ChildBook struct {
+	ID          string
+	Author      *firestore.DocumentRef
+	AuthorValue *ChildDocument `firestore:"-" simplestore:"ref=Author"`
+}
+
+func TestPreloadAcrossParents(t *testing.T) {
+	clearAllDocuments(t, &ParentDocument{})
+	clearAllDocuments(t, &ChildBook{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	var books []*ChildBook
+	for _, parentID := range []string{"p1", "p2", "p3"} {
+		child := &ChildDocument{Parent: &ParentDocument{ID: parentID}, ID: "c", Name: parentID}
+		_, err = client.Set(ctx, child)
+		require.NoError(t, err)
+		ref, err := client.GetDocumentRefSafe(child)
+		require.NoError(t, err)
+		books = append(books, &ChildBook{ID: parentID, Author: ref})
+	}
+
+	var ops []Operation
+	client.Use(recordOperations(&ops))
+	require.NoError(t, client.Preload(ctx, books, "Author"))
+	// a GetAll for refs under different parents
+	require.Len(t, ops, 1)
+	assert.Len(t, ops[0].Paths, 3)
+	for _, book := range books {
+		assert.Equal(t, book.ID, book.AuthorValue.Name)
+		assert.Equal(t, book.ID, book.AuthorValue.Parent.ID)
+	}
+}
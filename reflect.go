@@ -3,8 +3,13 @@ package simplestore
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
@@ -12,12 +17,141 @@ const (
 	ParentFieldName = "Parent"
 )
 
+// TagName is the name of struct tags to configure fields for simplestore
+// Multiple options can be specified separated by commas: `simplestore:"unique,redact"`
+const TagName = "simplestore"
+
+// hasTagOption returns whether the field has the option in the simplestore tag
+func hasTagOption(f reflect.StructField, option string) bool {
+	for _, o := range strings.Split(f.Tag.Get(TagName), ",") {
+		if strings.TrimSpace(o) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// tagOptionValue returns the value of the option like `option=value` in the simplestore tag
+func tagOptionValue(f reflect.StructField, option string) (string, bool) {
+	for _, o := range strings.Split(f.Tag.Get(TagName), ",") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(o), option+"="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// firestoreFieldName returns the field name in firestore documents
+// Returns an empty string if the field isn't stored.
+func firestoreFieldName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("firestore"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// storedField is a field of a struct stored in documents
+type storedField struct {
+	name   string
+	index  []int
+	tagged bool
+}
+
+// storedFields returns stored fields of t in the order of the index including ones of embedded structs
+// Conflicting names are resolved like encoding/json: the shallowest, then the tagged one wins.
+func storedFields(t reflect.Type) []*storedField {
+	byName := make(map[string]*storedField)
+	ambiguous := make(map[string]bool)
+	var collect func(t reflect.Type, index []int, visited map[reflect.Type]bool)
+	collect = func(t reflect.Type, index []int, visited map[reflect.Type]bool) {
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+		defer delete(visited, t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fieldIndex := append(append([]int{}, index...), i)
+			tagName, _, _ := strings.Cut(f.Tag.Get("firestore"), ",")
+			if tagName == "-" {
+				continue
+			}
+			if f.Anonymous && tagName == "" {
+				ft := f.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct && !isLeafType(f.Type) {
+					collect(ft, fieldIndex, visited)
+					continue
+				}
+			}
+			name := firestoreFieldName(f)
+			if name == "" {
+				continue
+			}
+			field := &storedField{name: name, index: fieldIndex, tagged: tagName != ""}
+			existing, ok := byName[name]
+			switch {
+			case !ok:
+				byName[name] = field
+			case len(field.index) < len(existing.index):
+				byName[name] = field
+				delete(ambiguous, name)
+			case len(field.index) > len(existing.index):
+			case field.tagged && !existing.tagged:
+				byName[name] = field
+				delete(ambiguous, name)
+			case field.tagged == existing.tagged:
+				ambiguous[name] = true
+			}
+		}
+	}
+	collect(t, nil, map[reflect.Type]bool{})
+	fields := make([]*storedField, 0, len(byName))
+	for name, f := range byName {
+		if !ambiguous[name] {
+			fields = append(fields, f)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i].index, fields[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return fields
+}
+
+var (
+	typeOfTime           = reflect.TypeOf(time.Time{})
+	typeOfLatLng         = reflect.TypeOf((*latlng.LatLng)(nil))
+	typeOfProtoTimestamp = reflect.TypeOf((*timestamppb.Timestamp)(nil))
+)
+
+// isLeafType returns whether t is stored as a value rather than a map even if it's a struct
+func isLeafType(t reflect.Type) bool {
+	return t == typeOfTime || t == typeOfLatLng || t == typeOfProtoTimestamp
+}
+
 type accessor struct {
 	parentAccessor *accessor
 	supportsIDer   bool
 	t              reflect.Type
 	collectionName string
 	readOnly       bool
+	audited        bool
+	uniqueFields   []uniqueField
 }
 
 func newAccessor(pt reflect.Type, tableMaps map[string]TableMapEntry) (*accessor, error) {
@@ -41,6 +175,14 @@ func newAccessor(pt reflect.Type, tableMaps map[string]TableMapEntry) (*accessor
 			return nil, NewProgrammingErrorf(IDFieldName+" field must be a string: %s.%s", t.PkgPath(), t.Name())
 		}
 	}
+	if err := validateChildrenFields(t); err != nil {
+		return nil, err
+	}
+	var err error
+	a.uniqueFields, err = newUniqueFields(t)
+	if err != nil {
+		return nil, err
+	}
 	a.collectionName = t.Name()
 
 	// Apply table mapping if available
@@ -48,6 +190,7 @@ func newAccessor(pt reflect.Type, tableMaps map[string]TableMapEntry) (*accessor
 		if entry, exists := tableMaps[t.Name()]; exists {
 			a.collectionName = entry.CollectionName
 			a.readOnly = entry.ReadOnly
+			a.audited = entry.Audit
 		}
 	}
 
@@ -57,7 +200,6 @@ func newAccessor(pt reflect.Type, tableMaps map[string]TableMapEntry) (*accessor
 		if parentT.Kind() != reflect.Pointer {
 			return nil, NewProgrammingError("Parent must be a pointer of a struct")
 		}
-		var err error
 		a.parentAccessor, err = newAccessor(parentT, tableMaps)
 		if err != nil {
 			return nil, NewProgrammingErrorf("invalid parent in %s.%s: %v", t.PkgPath(), t.Name(), err.Error())
@@ -115,44 +257,110 @@ func (a *accessor) setID(pv reflect.Value, id string) {
 	v.FieldByName(IDFieldName).SetString(id)
 }
 
-// GetDocumentRefSafe returns document ref of the object
-// o must be a pointer to a struct.
-// Returns nil if object is a nil.
-// Error if ID is not set.
-func (c *Client) GetDocumentRefSafe(o any) (*firestore.DocumentRef, error) {
-	accessor, err := newAccessor(reflect.TypeOf(o), c.tableMaps)
+// newDocument returns a new object with ID and the parent
+// pt must be a pointer to a struct.
+// parent is not set if nil.
+func (c *Client) newDocument(pt reflect.Type, parent any, id string) (any, error) {
+	accessor, err := newAccessor(pt, c.tableMaps)
 	if err != nil {
 		return nil, err
 	}
-	doc, _, err := accessor.getDocumentRef(c, reflect.ValueOf(o), false)
-	return doc, err
+	pv := reflect.New(pt.Elem())
+	if parent != nil {
+		parentF := pv.Elem().FieldByName(ParentFieldName)
+		if !parentF.IsValid() {
+			return nil, NewProgrammingErrorf("value must have "+ParentFieldName+" field: %s.%s", accessor.t.PkgPath(), accessor.t.Name())
+		}
+		parentV := reflect.ValueOf(parent)
+		if !parentV.Type().AssignableTo(parentF.Type()) {
+			return nil, NewProgrammingErrorf(ParentFieldName+" field must be %s: %s.%s", parentV.Type(), accessor.t.PkgPath(), accessor.t.Name())
+		}
+		parentF.Set(parentV)
+	}
+	accessor.setID(pv, id)
+	return pv.Interface(), nil
 }
 
-func nop() {}
+// setPath sets ID and the parent chain from segments of the path
+// Parent is set to nil if the path has no more segments.
+func (a *accessor) setPath(pv reflect.Value, segments []string) error {
+	path := strings.Join(segments, "/")
+	if len(segments) < 2 || a.collectionName != segments[len(segments)-2] {
+		return NewProgrammingErrorf("%s is not a path of %s.%s", path, a.t.PkgPath(), a.t.Name())
+	}
+	a.setID(pv, segments[len(segments)-1])
+	parentSegments := segments[:len(segments)-2]
+	if a.parentAccessor == nil {
+		if len(parentSegments) > 0 {
+			return NewProgrammingErrorf("%s is not a path of %s.%s", path, a.t.PkgPath(), a.t.Name())
+		}
+		return nil
+	}
+	parentF := pv.Elem().FieldByName(ParentFieldName)
+	if len(parentSegments) == 0 {
+		parentF.Set(reflect.Zero(parentF.Type()))
+		return nil
+	}
+	parentV := reflect.New(a.parentAccessor.t)
+	if err := a.parentAccessor.setPath(parentV, parentSegments); err != nil {
+		return NewProgrammingErrorf("invalid parent in %s.%s: %v", a.t.PkgPath(), a.t.Name(), err.Error())
+	}
+	parentF.Set(parentV)
+	return nil
+}
 
-func (c *Client) prepareSetDocument(o any) (*firestore.DocumentRef, func(), error) {
-	accessor, err := newAccessor(reflect.TypeOf(o), c.tableMaps)
+// FromRef fills ID and the parent chain of dst from ref
+// dst must be a pointer to a struct.
+// Parents are created as new objects.
+func (c *Client) FromRef(ref *firestore.DocumentRef, dst any) error {
+	if ref == nil {
+		return NewProgrammingError("ref is nil")
+	}
+	accessor, err := newAccessor(reflect.TypeOf(dst), c.tableMaps)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
-	pv := reflect.ValueOf(o)
-	doc, isNew, err := accessor.getDocumentRef(c, pv, true)
+	pv := reflect.ValueOf(dst)
+	if pv.IsNil() {
+		return NewProgrammingError("object is nil")
+	}
+	segments, err := splitDocumentPath(ref.Path)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	return accessor.setPath(pv, segments)
+}
+
+// PathOf returns the path of the document relative to the database
+// o must be a pointer to a struct.
+// The path is like `ParentDocument/parentid/ChildDocument/childid`, and can be passed to `GetByPath`.
+// Error if ID is not set.
+func (c *Client) PathOf(o any) (string, error) {
+	doc, err := c.GetDocumentRefSafe(o)
+	if err != nil {
+		return "", err
 	}
 	if doc == nil {
-		return nil, nil, NewProgrammingError("object is nil")
+		return "", NewProgrammingError("object is nil")
 	}
-	if !isNew {
-		return doc, nop, nil
+	return relativePath(doc.Path), nil
+}
+
+// GetDocumentRefSafe returns document ref of the object
+// o must be a pointer to a struct.
+// Returns nil if object is a nil.
+// Error if ID is not set.
+func (c *Client) GetDocumentRefSafe(o any) (*firestore.DocumentRef, error) {
+	accessor, err := newAccessor(reflect.TypeOf(o), c.tableMaps)
+	if err != nil {
+		return nil, err
 	}
-	accessor.setID(pv, doc.ID)
-	return doc, func() {
-		// reset
-		accessor.setID(pv, "")
-	}, nil
+	doc, _, err := accessor.getDocumentRef(c, reflect.ValueOf(o), false)
+	return doc, err
 }
 
+func nop() {}
+
 // GetDocumentRef returns document ref of the object
 // o must be a pointer to a struct.
 // Returns nil if object is a nil.
@@ -216,6 +424,7 @@ type targetBuilder struct {
 	target         any
 	elementType    reflect.Type
 	collectionName string
+	readOnly       bool
 }
 
 func newTargetBuilder(pos any, tableMaps map[string]TableMapEntry) (*targetBuilder, error) {
@@ -237,10 +446,12 @@ func newTargetBuilder(pos any, tableMaps map[string]TableMapEntry) (*targetBuild
 	}
 
 	collectionName := t.Name()
+	readOnly := false
 	// Apply table mapping if available
 	if tableMaps != nil {
 		if entry, exists := tableMaps[t.Name()]; exists {
 			collectionName = entry.CollectionName
+			readOnly = entry.ReadOnly
 		}
 	}
 
@@ -248,6 +459,7 @@ func newTargetBuilder(pos any, tableMaps map[string]TableMapEntry) (*targetBuild
 		target:         pos,
 		elementType:    t,
 		collectionName: collectionName,
+		readOnly:       readOnly,
 	}, nil
 }
 
@@ -278,10 +490,12 @@ func newTargetBuilderWithParent(parent any, pos any, tableMaps map[string]TableM
 	}
 
 	collectionName := t.Name()
+	readOnly := false
 	// Apply table mapping if available
 	if tableMaps != nil {
 		if entry, exists := tableMaps[t.Name()]; exists {
 			collectionName = entry.CollectionName
+			readOnly = entry.ReadOnly
 		}
 	}
 
@@ -290,6 +504,7 @@ func newTargetBuilderWithParent(parent any, pos any, tableMaps map[string]TableM
 		parent:         parent,
 		elementType:    t,
 		collectionName: collectionName,
+		readOnly:       readOnly,
 	}, nil
 }
 
@@ -337,3 +552,92 @@ func (c *Client) getNestedCollectionRef(parent any, pos any) (*firestore.Collect
 	}
 	return doc.Collection(tb.collectionName), tb, nil
 }
+
+// deepCopy returns a copy of v not sharing pointers, slices and maps of stored fields with v
+// Document references are shared as they're not modified, and so are fields not stored.
+// Pointers to the same value are copied once, so cyclic values like parents with children are copied as is.
+func deepCopy(v reflect.Value) reflect.Value {
+	c := &copier{copied: make(map[copiedKey]reflect.Value)}
+	return c.copy(v)
+}
+
+// copiedKey identifies a pointed value or a map
+type copiedKey struct {
+	t reflect.Type
+	p uintptr
+}
+
+type copier struct {
+	copied map[copiedKey]reflect.Value
+}
+
+func (c *copier) copy(v reflect.Value) reflect.Value {
+	if v.Type() == reflect.TypeOf((*firestore.DocumentRef)(nil)) {
+		return v
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		key := copiedKey{t: v.Type(), p: v.Pointer()}
+		if cp, ok := c.copied[key]; ok {
+			return cp
+		}
+		cp := reflect.New(v.Type().Elem())
+		c.copied[key] = cp
+		cp.Elem().Set(c.copy(v.Elem()))
+		return cp
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(c.copy(v.Elem()))
+		return cp
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(c.copy(v.Index(i)))
+		}
+		return cp
+	case reflect.Array:
+		cp := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(c.copy(v.Index(i)))
+		}
+		return cp
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		key := copiedKey{t: v.Type(), p: v.Pointer()}
+		if cp, ok := c.copied[key]; ok {
+			return cp
+		}
+		cp := reflect.MakeMapWithSize(v.Type(), v.Len())
+		c.copied[key] = cp
+		iter := v.MapRange()
+		for iter.Next() {
+			cp.SetMapIndex(iter.Key(), c.copy(iter.Value()))
+		}
+		return cp
+	case reflect.Struct:
+		// unexported fields and fields not stored are copied as is
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() || firestoreFieldName(f) == "" {
+				continue
+			}
+			cp.Field(i).Set(c.copy(v.Field(i)))
+		}
+		return cp
+	default:
+		return v
+	}
+}
@@ -149,3 +149,167 @@ func (s *ReflectTestSuite) TestGetDocumentRefWithoutParent() {
 	})
 	require.NoError(t, err)
 }
+
+func TestNewDocument(t *testing.T) {
+	client := &Client{}
+
+	o, err := client.newDocument(reflect.TypeOf(&MyDocument{}), nil, "docid")
+	require.NoError(t, err)
+	assert.Equal(t, &MyDocument{ID: "docid"}, o)
+
+	parent := &ParentDocument{ID: "parent1"}
+	o, err = client.newDocument(reflect.TypeOf(&ChildDocument{}), parent, "child1")
+	require.NoError(t, err)
+	assert.Equal(t, &ChildDocument{Parent: parent, ID: "child1"}, o)
+
+	o, err = client.newDocument(reflect.TypeOf(&CustomIDDocument{}), nil, "hash_test123")
+	require.NoError(t, err)
+	assert.Equal(t, &CustomIDDocument{MyID: "test123"}, o)
+
+	_, err = client.newDocument(reflect.TypeOf(&MyDocument{}), parent, "docid")
+	assert.IsType(t, &ProgrammingError{}, err)
+
+	_, err = client.newDocument(reflect.TypeOf(&ChildDocument{}), &MyDocument{}, "child1")
+	assert.IsType(t, &ProgrammingError{}, err)
+}
+
+func (s *ReflectTestSuite) TestPathOf() {
+	t := s.T()
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+	client.AddTableMaps(map[string]string{
+		"TestChildDoc": "children",
+	})
+
+	path, err := client.PathOf(&TestGrandChildDoc{
+		ID: "c",
+		Parent: &TestChildDoc{
+			ID:     "b",
+			Parent: &TestSimpleDoc{ID: "a"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "TestSimpleDoc/a/children/b/TestGrandChildDoc/c", path)
+
+	path, err = client.PathOf(&CustomIDDocument{MyID: "test123"})
+	require.NoError(t, err)
+	assert.Equal(t, "CustomIDDocument/hash_test123", path)
+
+	_, err = client.PathOf(&TestSimpleDoc{})
+	assertProgrammingError(t, err)
+
+	_, err = client.PathOf((*TestSimpleDoc)(nil))
+	assertProgrammingError(t, err)
+}
+
+func (s *ReflectTestSuite) TestFromRef() {
+	t := s.T()
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+	client.AddTableMaps(map[string]string{
+		"TestChildDoc": "children",
+	})
+
+	doc := &TestGrandChildDoc{}
+	err = client.FromRef(
+		client.FirestoreClient.Doc("TestSimpleDoc/a/children/b/TestGrandChildDoc/c"),
+		doc,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, &TestGrandChildDoc{
+		ID: "c",
+		Parent: &TestChildDoc{
+			ID:     "b",
+			Parent: &TestSimpleDoc{ID: "a"},
+		},
+	}, doc)
+
+	// round trip
+	path, err := client.PathOf(doc)
+	require.NoError(t, err)
+	assert.Equal(t, "TestSimpleDoc/a/children/b/TestGrandChildDoc/c", path)
+
+	custom := &CustomIDDocument{}
+	err = client.FromRef(client.FirestoreClient.Doc("CustomIDDocument/hash_test123"), custom)
+	require.NoError(t, err)
+	assert.Equal(t, "test123", custom.MyID)
+
+	// Parent is cleared for top level documents
+	child := &TestChildDoc{Parent: &TestSimpleDoc{ID: "a"}}
+	err = client.FromRef(client.FirestoreClient.Doc("children/b"), child)
+	require.NoError(t, err)
+	assert.Equal(t, &TestChildDoc{ID: "b"}, child)
+
+	err = client.FromRef(client.FirestoreClient.Doc("TestChildDoc/b"), &TestChildDoc{})
+	assertProgrammingError(t, err)
+
+	err = client.FromRef(client.FirestoreClient.Doc("MyDocument/a/TestSimpleDoc/b"), &TestSimpleDoc{})
+	assertProgrammingError(t, err)
+
+	err = client.FromRef(nil, &TestSimpleDoc{})
+	assertProgrammingError(t, err)
+}
+
+type deepCopyTestDoc struct {
+	ID      string
+	Tags    []string
+	Attrs   map[string]any
+	Profile *deepCopyTestProfile
+	hidden  []int
+}
+
+type deepCopyTestProfile struct {
+	Names [2]string
+}
+
+func TestDeepCopy(t *testing.T) {
+	hidden := []int{1}
+	o := &deepCopyTestDoc{
+		ID:      "a",
+		Tags:    []string{"x"},
+		Attrs:   map[string]any{"list": []any{"y"}},
+		Profile: &deepCopyTestProfile{Names: [2]string{"p", "q"}},
+		hidden:  hidden,
+	}
+	cp := deepCopy(reflect.ValueOf(o)).Interface().(*deepCopyTestDoc)
+	assert.Equal(t, o, cp)
+
+	o.Tags[0] = "changed"
+	o.Attrs["list"].([]any)[0] = "changed"
+	o.Profile.Names[0] = "changed"
+	assert.Equal(t, []string{"x"}, cp.Tags)
+	assert.Equal(t, []any{"y"}, cp.Attrs["list"])
+	assert.Equal(t, "p", cp.Profile.Names[0])
+	// unexported fields are shared
+	o.hidden[0] = 2
+	assert.Equal(t, []int{2}, cp.hidden)
+}
+
+type deepCopyTestNode struct {
+	Name string
+	Next *deepCopyTestNode
+}
+
+func TestDeepCopyCyclic(t *testing.T) {
+	// children loaded with the parent point back to it
+	order := &Order{ID: "order1"}
+	item := &OrderItem{Parent: order, ID: "item1", Price: 100}
+	order.Items = []*OrderItem{item}
+	cp := deepCopy(reflect.ValueOf(item)).Interface().(*OrderItem)
+	assert.NotSame(t, order, cp.Parent)
+	assert.Equal(t, "order1", cp.Parent.ID)
+	// fields not stored are shared
+	assert.Same(t, item, cp.Parent.Items[0])
+
+	// cycles of stored fields are kept
+	node := &deepCopyTestNode{Name: "a"}
+	node.Next = &deepCopyTestNode{Name: "b", Next: node}
+	cpNode := deepCopy(reflect.ValueOf(node)).Interface().(*deepCopyTestNode)
+	assert.NotSame(t, node, cpNode)
+	assert.Equal(t, "b", cpNode.Next.Name)
+	assert.Same(t, cpNode, cpNode.Next.Next)
+}
@@ -0,0 +1,110 @@
+package simplestore
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+// Register registers types of documents to resolve types from paths
+// os must be pointers to structs.
+// Types of parents are resolved from `Parent` fields and needn't be registered.
+func (c *Client) Register(os ...any) error {
+	for _, o := range os {
+		pt := reflect.TypeOf(o)
+		if _, err := newAccessor(pt, c.tableMaps); err != nil {
+			return err
+		}
+		if c.types == nil {
+			c.types = make(map[reflect.Type]bool, len(os))
+		}
+		// keyed by types as types in different packages may have the same name
+		c.types[pt] = true
+	}
+	return nil
+}
+
+// GetByPath retrieves a document specified with the path from firestore
+// path is like `ParentDocument/parentid/ChildDocument/childid`.
+// Full paths like `projects/PROJECT/databases/DATABASE/documents/...` are also accepted.
+// Returns a pointer to the registered type with `Parent` filled.
+func (c *Client) GetByPath(ctx context.Context, path string) (any, error) {
+	o, err := c.newDocumentFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Get(ctx, o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// newDocumentFromPath returns a new object for the path with IDs and the parent chain
+func (c *Client) newDocumentFromPath(path string) (any, error) {
+	segments, err := splitDocumentPath(path)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := c.resolveAccessorChain(segments)
+	if err != nil {
+		return nil, err
+	}
+	pv := reflect.New(chain[0].t)
+	if err := chain[0].setPath(pv, segments); err != nil {
+		return nil, err
+	}
+	return pv.Interface(), nil
+}
+
+// resolveAccessorChain resolves accessors for the document and its parents
+// Returns accessors from the document to the root.
+func (c *Client) resolveAccessorChain(segments []string) ([]*accessor, error) {
+	depth := len(segments) / 2
+	var found []*accessor
+	for pt := range c.types {
+		a, err := newAccessor(pt, c.tableMaps)
+		if err != nil {
+			return nil, err
+		}
+		chain := make([]*accessor, 0, depth)
+		// walk the path in reverse
+		for i := depth - 1; i >= 0 && a != nil; i-- {
+			if a.collectionName != segments[i*2] {
+				break
+			}
+			chain = append(chain, a)
+			a = a.parentAccessor
+		}
+		if len(chain) == depth {
+			if len(found) > 0 {
+				return nil, NewProgrammingErrorf("multiple types are registered for %s: %s, %s", strings.Join(segments, "/"), found[0].t.String(), chain[0].t.String())
+			}
+			found = chain
+		}
+	}
+	if found == nil {
+		return nil, NewProgrammingErrorf("no type is registered for %s", strings.Join(segments, "/"))
+	}
+	return found, nil
+}
+
+// splitDocumentPath splits the path of a document into collection names and IDs
+func splitDocumentPath(path string) ([]string, error) {
+	if strings.HasPrefix(path, "projects/") {
+		path = relativePath(path)
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, NewProgrammingError("path is empty")
+	}
+	segments := strings.Split(path, "/")
+	if len(segments)%2 != 0 {
+		return nil, NewProgrammingErrorf("not a document path: %s", path)
+	}
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, NewProgrammingErrorf("invalid path: %s", path)
+		}
+	}
+	return segments, nil
+}
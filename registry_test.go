@@ -0,0 +1,105 @@
+package simplestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDocumentFromPath(t *testing.T) {
+	client := &Client{}
+	require.NoError(t, client.Register(&MyDocument{}, &TestGrandChildDoc{}))
+
+	o, err := client.newDocumentFromPath("MyDocument/docid")
+	require.NoError(t, err)
+	assert.Equal(t, &MyDocument{ID: "docid"}, o)
+
+	o, err = client.newDocumentFromPath("projects/testproject/databases/(default)/documents/TestSimpleDoc/a/TestChildDoc/b/TestGrandChildDoc/c")
+	require.NoError(t, err)
+	assert.Equal(t, &TestGrandChildDoc{
+		ID: "c",
+		Parent: &TestChildDoc{
+			ID: "b",
+			Parent: &TestSimpleDoc{
+				ID: "a",
+			},
+		},
+	}, o)
+
+	// parents are optional
+	o, err = client.newDocumentFromPath("TestGrandChildDoc/c")
+	require.NoError(t, err)
+	assert.Equal(t, &TestGrandChildDoc{ID: "c"}, o)
+
+	for _, path := range []string{
+		"",
+		"MyDocument",
+		"MyDocument//",
+		"UnknownDocument/docid",
+		"TestSimpleDoc/a",
+		"MyDocument/a/TestGrandChildDoc/c",
+	} {
+		_, err = client.newDocumentFromPath(path)
+		assert.IsType(t, &ProgrammingError{}, err, path)
+	}
+}
+
+func TestNewDocumentFromPathWithTableMaps(t *testing.T) {
+	client := &Client{}
+	client.AddTableMaps(map[string]string{
+		"ParentDocument": "parents",
+		"ChildDocument":  "children",
+	})
+	require.NoError(t, client.Register(&ChildDocument{}))
+
+	o, err := client.newDocumentFromPath("parents/p/children/c")
+	require.NoError(t, err)
+	assert.Equal(t, &ChildDocument{ID: "c", Parent: &ParentDocument{ID: "p"}}, o)
+
+	_, err = client.newDocumentFromPath("ParentDocument/p/ChildDocument/c")
+	assert.IsType(t, &ProgrammingError{}, err)
+}
+
+func TestRegisterSameNameTypes(t *testing.T) {
+	// a type with the same name as one in another package
+	type MyDocument struct {
+		ID string
+	}
+	client := &Client{}
+	require.NoError(t, client.Register(&MyDocument{}, &TestSimpleDoc{}))
+	o, err := client.newDocumentFromPath("MyDocument/docid")
+	require.NoError(t, err)
+	assert.Equal(t, &MyDocument{ID: "docid"}, o)
+
+	// registered types aren't overwritten
+	require.NoError(t, client.Register(&packageMyDocument{}))
+	assert.Len(t, client.types, 3)
+	_, err = client.newDocumentFromPath("MyDocument/docid")
+	assert.ErrorContains(t, err, "multiple types are registered")
+}
+
+// packageMyDocument refers to MyDocument shadowed in tests
+type packageMyDocument = MyDocument
+
+func TestRegisterInvalid(t *testing.T) {
+	client := &Client{}
+	assert.IsType(t, &ProgrammingError{}, client.Register(MyDocument{}))
+}
+
+func TestGetByPath(t *testing.T) {
+	clearAllDocuments(t, &ParentDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.Register(&ChildDocument{}))
+
+	parent := &ParentDocument{ID: "parent1"}
+	_, err = client.Set(ctx, &ChildDocument{Parent: parent, ID: "child1", Name: "Child"})
+	require.NoError(t, err)
+
+	o, err := client.GetByPath(ctx, "ParentDocument/parent1/ChildDocument/child1")
+	require.NoError(t, err)
+	assert.Equal(t, &ChildDocument{Parent: parent, ID: "child1", Name: "Child"}, o)
+}
@@ -0,0 +1,377 @@
+package simplestore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// SchemaVersionFieldName is the field for schema versions of documents
+// Documents without the field are version 0.
+const SchemaVersionFieldName = "SchemaVersion"
+
+// SchemaUpgrader upgrades raw data of a document to the next version
+// data can be modified and returned.
+type SchemaUpgrader func(ctx context.Context, data map[string]any) (map[string]any, error)
+
+type schemaConfig struct {
+	// fieldName is the name of SchemaVersion in documents
+	fieldName string
+	// oldest is the oldest version upgradable
+	oldest int64
+	// current is the version of the struct
+	current   int64
+	upgraders map[int64]SchemaUpgrader
+	// writeBacks is nil unless configured with `WithSchemaWriteBack`
+	writeBacks *schemaWriteBacks
+}
+
+// SchemaOption configures schema upgrades of a type
+type SchemaOption func(*schemaConfig)
+
+// WithSchemaWriteBack writes upgraded documents back on reads
+// Documents are written only if not updated after read,
+// and not written in transactions, in readonly collections nor with read-only clients.
+// Writes are sent in the background in batches, so reads don't wait for them,
+// and failures of writes are ignored as documents are upgraded again on next reads.
+// Like migrations, they don't go through middlewares, so not metered,
+// and don't update unique indexes, audit logs and histories.
+func WithSchemaWriteBack() SchemaOption {
+	return func(c *schemaConfig) {
+		c.writeBacks = &schemaWriteBacks{}
+	}
+}
+
+// EnableSchemaUpgrade upgrades documents of the type with old schema versions on reads
+// o must be a pointer to a struct with SchemaVersion field of an integer.
+// upgraders maps version N to the upgrader from N to N+1, and must cover continuous versions.
+// The struct is the next version of the latest upgrader, and `Create` and `Set` write the version to SchemaVersion.
+// `Get`, `GetAll` and `Query` run upgraders on documents with older versions before filling structs.
+// Documents with newer versions are read as is.
+func (c *Client) EnableSchemaUpgrade(o any, upgraders map[int64]SchemaUpgrader, opts ...SchemaOption) error {
+	pt := reflect.TypeOf(o)
+	if _, err := newAccessor(pt, c.tableMaps); err != nil {
+		return err
+	}
+	f, ok := pt.Elem().FieldByName(SchemaVersionFieldName)
+	if !ok {
+		return NewProgrammingErrorf(SchemaVersionFieldName+" field doesn't exist: %s.%s", pt.Elem().PkgPath(), pt.Elem().Name())
+	}
+	switch f.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+	default:
+		return NewProgrammingErrorf(SchemaVersionFieldName+" field must be an integer: %s.%s", pt.Elem().PkgPath(), pt.Elem().Name())
+	}
+	if len(f.Index) != 1 || firestoreFieldName(f) == "" {
+		return NewProgrammingErrorf(SchemaVersionFieldName+" field must be stored: %s.%s", pt.Elem().PkgPath(), pt.Elem().Name())
+	}
+	if len(upgraders) == 0 {
+		return NewProgrammingError("no upgraders")
+	}
+	versions := make([]int64, 0, len(upgraders))
+	for version, upgrader := range upgraders {
+		if upgrader == nil {
+			return NewProgrammingErrorf("upgrader from version %d is nil", version)
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+	if versions[0] < 0 {
+		return NewProgrammingErrorf("version must not be negative: %d", versions[0])
+	}
+	for i, version := range versions {
+		if version != versions[0]+int64(i) {
+			return NewProgrammingErrorf("upgrader from version %d is missing", versions[0]+int64(i))
+		}
+	}
+	config := &schemaConfig{
+		fieldName: firestoreFieldName(f),
+		oldest:    versions[0],
+		current:   versions[len(versions)-1] + 1,
+		upgraders: upgraders,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if c.schemaTypes == nil {
+		c.schemaTypes = make(map[reflect.Type]*schemaConfig)
+	}
+	c.schemaTypes[pt.Elem()] = config
+	return nil
+}
+
+func (c *Client) schemaConfig(t reflect.Type) *schemaConfig {
+	if c.schemaTypes == nil || t.Kind() != reflect.Pointer {
+		return nil
+	}
+	return c.schemaTypes[t.Elem()]
+}
+
+// setSchemaVersion writes the current version to SchemaVersion of o
+func (c *Client) setSchemaVersion(pv reflect.Value) {
+	config := c.schemaConfig(pv.Type())
+	if config == nil || pv.IsNil() {
+		return
+	}
+	pv.Elem().FieldByName(SchemaVersionFieldName).SetInt(config.current)
+}
+
+// dataTo fills o with the document upgrading the schema
+// Upgraded documents are written back if configured with `WithSchemaWriteBack`.
+func (c *Client) dataTo(ctx context.Context, docsnap *firestore.DocumentSnapshot, o any) error {
+	upgraded, err := c.upgradedDataTo(ctx, docsnap, o)
+	if upgraded == nil || err != nil {
+		return err
+	}
+	if writeBacks := c.schemaConfig(reflect.TypeOf(o)).writeBacks; writeBacks != nil {
+		c.writeBackUpgraded(ctx, writeBacks, docsnap, upgraded)
+	}
+	return nil
+}
+
+// upgradedDataTo fills o with the document upgrading the schema without writing back
+// Returns the snapshot of the upgraded document, or nil if the document is not upgraded.
+func (c *Client) upgradedDataTo(ctx context.Context, docsnap *firestore.DocumentSnapshot, o any) (*firestore.DocumentSnapshot, error) {
+	config := c.schemaConfig(reflect.TypeOf(o))
+	if config == nil || !docsnap.Exists() {
+		return nil, docsnap.DataTo(o)
+	}
+	data := docsnap.Data()
+	var version int64
+	switch v := data[config.fieldName].(type) {
+	case nil:
+	case int64:
+		version = v
+	default:
+		return nil, fmt.Errorf("%s: invalid %s: %v", relativePath(docsnap.Ref.Path), config.fieldName, v)
+	}
+	if version >= config.current {
+		return nil, docsnap.DataTo(o)
+	}
+	if version < config.oldest {
+		return nil, fmt.Errorf("%s: no upgrader from version %d", relativePath(docsnap.Ref.Path), version)
+	}
+	for ; version < config.current; version++ {
+		var err error
+		data, err = config.upgraders[version](ctx, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: upgrading from version %d: %w", relativePath(docsnap.Ref.Path), version, err)
+		}
+		if data == nil {
+			return nil, fmt.Errorf("%s: upgrader from version %d returned nil", relativePath(docsnap.Ref.Path), version)
+		}
+	}
+	data[config.fieldName] = config.current
+	upgraded, err := upgradedSnapshot(c.FirestoreClient, docsnap, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", relativePath(docsnap.Ref.Path), err)
+	}
+	if err := upgraded.DataTo(o); err != nil {
+		return nil, fmt.Errorf("%s: %w", relativePath(docsnap.Ref.Path), err)
+	}
+	return upgraded, nil
+}
+
+// upgradedSnapshot returns a copy of docsnap holding data
+// so that data is decoded by `DataTo` as if it were stored.
+// firestore doesn't export ways to decode raw data, so the document of the snapshot is replaced.
+func upgradedSnapshot(fc *firestore.Client, docsnap *firestore.DocumentSnapshot, data map[string]any) (*firestore.DocumentSnapshot, error) {
+	fields := make(map[string]*firestorepb.Value, len(data))
+	for name, value := range data {
+		pv, err := protoValue(reflect.ValueOf(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		fields[name] = pv
+	}
+	upgraded := *docsnap
+	v := reflect.ValueOf(&upgraded).Elem()
+	for name, value := range map[string]any{
+		"c":     fc,
+		"proto": &firestorepb.Document{Name: docsnap.Ref.Path, Fields: fields},
+	} {
+		f := v.FieldByName(name)
+		if !f.IsValid() || f.Type() != reflect.TypeOf(value) {
+			return nil, fmt.Errorf("unsupported firestore.DocumentSnapshot: %s", name)
+		}
+		reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem().Set(reflect.ValueOf(value))
+	}
+	return &upgraded, nil
+}
+
+var nullValue = &firestorepb.Value{ValueType: &firestorepb.Value_NullValue{}}
+
+// protoValue converts a value set by upgraders to the firestore value as it would be stored
+// Structs can't be converted.
+func protoValue(v reflect.Value) (*firestorepb.Value, error) {
+	if !v.IsValid() {
+		return nullValue, nil
+	}
+	switch x := v.Interface().(type) {
+	case []byte:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_BytesValue{BytesValue: x}}, nil
+	case time.Time:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_TimestampValue{TimestampValue: timestamppb.New(x)}}, nil
+	case *timestamppb.Timestamp:
+		if x == nil {
+			return nullValue, nil
+		}
+		return &firestorepb.Value{ValueType: &firestorepb.Value_TimestampValue{TimestampValue: x}}, nil
+	case *latlng.LatLng:
+		if x == nil {
+			return nullValue, nil
+		}
+		return &firestorepb.Value{ValueType: &firestorepb.Value_GeoPointValue{GeoPointValue: x}}, nil
+	case *firestore.DocumentRef:
+		if x == nil {
+			return nullValue, nil
+		}
+		return &firestorepb.Value{ValueType: &firestorepb.Value_ReferenceValue{ReferenceValue: x.Path}}, nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_BooleanValue{BooleanValue: v.Bool()}}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_IntegerValue{IntegerValue: v.Int()}}, nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_IntegerValue{IntegerValue: int64(v.Uint())}}, nil
+	case reflect.Float32, reflect.Float64:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_DoubleValue{DoubleValue: v.Float()}}, nil
+	case reflect.String:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_StringValue{StringValue: v.String()}}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nullValue, nil
+		}
+		values := make([]*firestorepb.Value, v.Len())
+		for i := range values {
+			pv, err := protoValue(v.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			values[i] = pv
+		}
+		return &firestorepb.Value{ValueType: &firestorepb.Value_ArrayValue{ArrayValue: &firestorepb.ArrayValue{Values: values}}}, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map key type is not string: %s", v.Type())
+		}
+		if v.IsNil() {
+			return nullValue, nil
+		}
+		fields := make(map[string]*firestorepb.Value, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			pv, err := protoValue(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", iter.Key().String(), err)
+			}
+			fields[iter.Key().String()] = pv
+		}
+		return &firestorepb.Value{ValueType: &firestorepb.Value_MapValue{MapValue: &firestorepb.MapValue{Fields: fields}}}, nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nullValue, nil
+		}
+		return protoValue(v.Elem())
+	case reflect.Interface:
+		return protoValue(v.Elem())
+	}
+	return nil, fmt.Errorf("cannot convert %s", v.Type())
+}
+
+// writeBackUpgraded queues the write of the upgraded document if the document is writable
+func (c *Client) writeBackUpgraded(ctx context.Context, writeBacks *schemaWriteBacks, docsnap, upgraded *firestore.DocumentSnapshot) {
+	if c.FirestoreTransaction != nil || c.checkWritableDocument(docsnap.Ref) != nil {
+		return
+	}
+	data := upgraded.Data()
+	updates := make([]firestore.Update, 0, len(data))
+	for name, v := range data {
+		updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{name}, Value: v})
+	}
+	for name := range docsnap.Data() {
+		if _, ok := data[name]; !ok {
+			updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{name}, Value: firestore.Delete})
+		}
+	}
+	writeBacks.add(ctx, c.FirestoreClient, &schemaWriteBack{
+		ref:        docsnap.Ref,
+		updates:    updates,
+		updateTime: docsnap.UpdateTime,
+	})
+}
+
+// checkWritableDocument returns an error if any collection in the path of ref is readonly
+func (c *Client) checkWritableDocument(ref *firestore.DocumentRef) error {
+	for ; ref != nil; ref = ref.Parent.Parent {
+		if err := c.CheckWritableCollection(ref.Parent.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaWriteBacks writes upgraded documents back in the background
+// Writes queued in the batch window are sent together with a `BulkWriter`,
+// so reads don't wait for them.
+type schemaWriteBacks struct {
+	mu      sync.Mutex
+	pending map[*firestore.Client]map[string]*schemaWriteBack
+	// wg is to wait for sent writes in tests
+	wg sync.WaitGroup
+}
+
+type schemaWriteBack struct {
+	ref        *firestore.DocumentRef
+	updates    []firestore.Update
+	updateTime time.Time
+}
+
+// add queues w, sending queued writes after the batch window
+// Writes are sent with values of the context of the first write in the window.
+func (b *schemaWriteBacks) add(ctx context.Context, fc *firestore.Client, w *schemaWriteBack) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending == nil {
+		b.pending = make(map[*firestore.Client]map[string]*schemaWriteBack)
+	}
+	pending := b.pending[fc]
+	if pending == nil {
+		pending = make(map[string]*schemaWriteBack)
+		b.pending[fc] = pending
+		b.wg.Add(1)
+		// writes outlive the read
+		ctx = detachedContext{parent: ctx}
+		time.AfterFunc(DefaultBatchWindow, func() {
+			defer b.wg.Done()
+			b.send(ctx, fc)
+		})
+	}
+	// the latest read wins for the same document
+	pending[w.ref.Path] = w
+}
+
+func (b *schemaWriteBacks) send(ctx context.Context, fc *firestore.Client) {
+	b.mu.Lock()
+	pending := b.pending[fc]
+	delete(b.pending, fc)
+	b.mu.Unlock()
+	bw := fc.BulkWriter(ctx)
+	for _, w := range pending {
+		// ignore failures: upgraded again on next reads
+		_, _ = bw.Update(w.ref, w.updates, firestore.LastUpdateTime(w.updateTime))
+	}
+	bw.End()
+}
@@ -0,0 +1,317 @@
+package simplestore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+type SchemaUser struct {
+	ID            string
+	SchemaVersion int
+	FirstName     string
+	LastName      string
+	Email         string
+}
+
+// schemaUserUpgraders upgrade `Name` (version 0) to `FirstName` and `LastName` (version 2)
+var schemaUserUpgraders = map[int64]SchemaUpgrader{
+	0: func(ctx context.Context, data map[string]any) (map[string]any, error) {
+		data["FullName"] = data["Name"]
+		delete(data, "Name")
+		return data, nil
+	},
+	1: func(ctx context.Context, data map[string]any) (map[string]any, error) {
+		name, _ := data["FullName"].(string)
+		if name == "" {
+			return nil, errors.New("no name")
+		}
+		data["FirstName"], data["LastName"], _ = strings.Cut(name, " ")
+		delete(data, "FullName")
+		return data, nil
+	},
+}
+
+func TestEnableSchemaUpgrade(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.EnableSchemaUpgrade(&SchemaUser{}, schemaUserUpgraders))
+	config := client.schemaConfig(reflect.TypeOf(&SchemaUser{}))
+	require.NotNil(t, config)
+	assert.Equal(t, int64(0), config.oldest)
+	assert.Equal(t, int64(2), config.current)
+	assert.Nil(t, config.writeBacks)
+
+	noop := func(ctx context.Context, data map[string]any) (map[string]any, error) {
+		return data, nil
+	}
+	assertProgrammingError(t, client.EnableSchemaUpgrade(SchemaUser{}, schemaUserUpgraders))
+	assertProgrammingError(t, client.EnableSchemaUpgrade(&MyDocument{}, schemaUserUpgraders))
+	assertProgrammingError(t, client.EnableSchemaUpgrade(&SchemaUser{}, nil))
+	assertProgrammingError(t, client.EnableSchemaUpgrade(&SchemaUser{}, map[int64]SchemaUpgrader{0: nil}))
+	assertProgrammingError(t, client.EnableSchemaUpgrade(&SchemaUser{}, map[int64]SchemaUpgrader{-1: noop}))
+	assertProgrammingError(t, client.EnableSchemaUpgrade(&SchemaUser{}, map[int64]SchemaUpgrader{1: noop, 3: noop}))
+	type StringVersion struct {
+		ID            string
+		SchemaVersion string
+	}
+	assertProgrammingError(t, client.EnableSchemaUpgrade(&StringVersion{}, map[int64]SchemaUpgrader{0: noop}))
+	type IgnoredVersion struct {
+		ID            string
+		SchemaVersion int `firestore:"-"`
+	}
+	assertProgrammingError(t, client.EnableSchemaUpgrade(&IgnoredVersion{}, map[int64]SchemaUpgrader{0: noop}))
+
+	// writes set the current version
+	user := &SchemaUser{}
+	client.setSchemaVersion(reflect.ValueOf(user))
+	assert.Equal(t, 2, user.SchemaVersion)
+
+	// a type with the same name as one in another package
+	type MyDocument struct {
+		ID            string
+		SchemaVersion int
+	}
+	require.NoError(t, client.EnableSchemaUpgrade(&MyDocument{}, map[int64]SchemaUpgrader{0: noop}))
+	assert.NotNil(t, client.schemaConfig(reflect.TypeOf(&MyDocument{})))
+	assert.Nil(t, client.schemaConfig(reflect.TypeOf(&packageMyDocument{})))
+}
+
+type schemaEmbedded struct {
+	Note string
+}
+
+type schemaTarget struct {
+	schemaEmbedded
+	Name     string `firestore:"name"`
+	Count    int8
+	Size     uint16
+	Ratio    float32
+	Time     time.Time
+	Bytes    []byte
+	Tags     []string
+	Pair     [2]int
+	Scores   map[string]float64
+	Nested   *schemaEmbedded
+	Location *latlng.LatLng
+	Ref      *firestore.DocumentRef
+	Any      any
+	Kept     string
+	Nullable *string
+}
+
+func TestUpgradedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+
+	now := time.Now()
+	ref := client.FirestoreClient.Doc("SchemaUser/ref")
+	loc := &latlng.LatLng{Latitude: 1, Longitude: 2}
+	docsnap := &firestore.DocumentSnapshot{Ref: client.FirestoreClient.Doc("SchemaUser/doc1")}
+	upgraded, err := upgradedSnapshot(client.FirestoreClient, docsnap, map[string]any{
+		"Note":     "note",
+		"name":     "name",
+		"Count":    3,
+		"Size":     float64(4),
+		"Ratio":    int8(5),
+		"Time":     now,
+		"Bytes":    []byte("bytes"),
+		"Tags":     []string{"a", "b"},
+		"Pair":     [2]int64{1, 2},
+		"Scores":   map[string]float32{"math": 1.5},
+		"Nested":   map[string]any{"Note": "nested"},
+		"Location": loc,
+		"Ref":      ref,
+		"Any":      map[string]any{"key": []string{"value"}},
+		"Nullable": (*string)(nil),
+	})
+	require.NoError(t, err)
+	assert.Nil(t, docsnap.Data(), "the original snapshot is not changed")
+	assert.Equal(t, []any{"a", "b"}, upgraded.Data()["Tags"])
+
+	// decoded by DataTo
+	nullable := "nullable"
+	target := &schemaTarget{Kept: "kept", Nullable: &nullable}
+	require.NoError(t, upgraded.DataTo(target))
+	assert.Equal(
+		t,
+		&schemaTarget{
+			schemaEmbedded: schemaEmbedded{Note: "note"},
+			Name:           "name",
+			Count:          3,
+			Size:           4,
+			Ratio:          5,
+			Time:           now.UTC(),
+			Bytes:          []byte("bytes"),
+			Tags:           []string{"a", "b"},
+			Pair:           [2]int{1, 2},
+			Scores:         map[string]float64{"math": 1.5},
+			Nested:         &schemaEmbedded{Note: "nested"},
+			Location:       loc,
+			Ref:            ref,
+			Any:            map[string]any{"key": []any{"value"}},
+			Kept:           "kept",
+		},
+		target,
+	)
+
+	for _, data := range []map[string]any{
+		{"Nested": schemaEmbedded{Note: "nested"}},
+		{"Map": map[int]any{1: "one"}},
+		{"Size": uint(1)},
+	} {
+		_, err := upgradedSnapshot(client.FirestoreClient, docsnap, data)
+		assert.Error(t, err, "%v", data)
+	}
+}
+
+func TestCheckWritableDocument(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+	client.AddReadonlyTableMaps(map[string]string{
+		"TestReadOnlyDocument": "readonly_collection",
+	})
+
+	assert.NoError(t, client.checkWritableDocument(client.FirestoreClient.Doc("SchemaUser/doc1/Sub/doc2")))
+	// parents are checked too
+	assertProgrammingError(t, client.checkWritableDocument(client.FirestoreClient.Doc("readonly_collection/doc1")))
+	assertProgrammingError(t, client.checkWritableDocument(client.FirestoreClient.Doc("readonly_collection/doc1/Sub/doc2")))
+}
+
+// TestUpgradedSnapshotParity compares snapshots holding stored data with stored ones
+func TestUpgradedSnapshotParity(t *testing.T) {
+	clearAllDocuments(t, &SchemaUser{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+
+	values := map[string]any{
+		"Null":     nil,
+		"Bool":     true,
+		"Int":      int64(3),
+		"Float":    1.5,
+		"String":   "string",
+		"Bytes":    []byte("bytes"),
+		"Time":     time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC),
+		"GeoPoint": &latlng.LatLng{Latitude: 1, Longitude: 2},
+		"Ref":      client.FirestoreClient.Doc("SchemaUser/ref"),
+		"Array":    []any{"a", int64(1)},
+		"Map":      map[string]any{"Note": "note", "Count": int64(1)},
+		"Nested":   map[string]any{"Values": []any{map[string]any{"key": 1.5}}},
+	}
+	doc := client.FirestoreClient.Doc("SchemaUser/parity")
+	_, err = doc.Set(ctx, values)
+	require.NoError(t, err)
+	docsnap, err := doc.Get(ctx)
+	require.NoError(t, err)
+
+	upgraded, err := upgradedSnapshot(client.FirestoreClient, docsnap, docsnap.Data())
+	require.NoError(t, err)
+	assert.Equal(t, docsnap.Data(), upgraded.Data())
+	expected := map[string]any{}
+	require.NoError(t, docsnap.DataTo(&expected))
+	actual := map[string]any{}
+	require.NoError(t, upgraded.DataTo(&actual))
+	assert.Equal(t, expected, actual)
+}
+
+func TestSchemaUpgrade(t *testing.T) {
+	clearAllDocuments(t, &SchemaUser{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.EnableSchemaUpgrade(&SchemaUser{}, schemaUserUpgraders))
+
+	for path, data := range map[string]map[string]any{
+		"SchemaUser/v0":    {"ID": "v0", "Name": "Alice Smith", "Email": "alice@example.com"},
+		"SchemaUser/v1":    {"ID": "v1", "SchemaVersion": 1, "FullName": "Bob Jones"},
+		"SchemaUser/v3":    {"ID": "v3", "SchemaVersion": 3, "FirstName": "Carol"},
+		"SchemaUser/error": {"ID": "error"},
+	} {
+		_, err := client.FirestoreClient.Doc(path).Set(ctx, data)
+		require.NoError(t, err)
+	}
+
+	user := &SchemaUser{ID: "v0"}
+	require.NoError(t, client.Get(ctx, user))
+	assert.Equal(t, &SchemaUser{ID: "v0", SchemaVersion: 2, FirstName: "Alice", LastName: "Smith", Email: "alice@example.com"}, user)
+	// not written back by default
+	docsnap, err := client.FirestoreClient.Doc("SchemaUser/v0").Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice Smith", docsnap.Data()["Name"])
+
+	users, err := client.GetAll(ctx, []*SchemaUser{{ID: "v1"}, {ID: "v3"}})
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		[]*SchemaUser{
+			{ID: "v1", SchemaVersion: 2, FirstName: "Bob", LastName: "Jones"},
+			// newer versions are read as is
+			{ID: "v3", SchemaVersion: 3, FirstName: "Carol"},
+		},
+		users,
+	)
+
+	err = client.Get(ctx, &SchemaUser{ID: "error"})
+	assert.ErrorContains(t, err, "SchemaUser/error: upgrading from version 1: no name")
+
+	var found []*SchemaUser
+	require.NoError(t, client.Query(&found).Where("ID", "in", []string{"v0", "v1"}).OrderBy("ID", firestore.Asc).GetAll(ctx))
+	require.Len(t, found, 2)
+	assert.Equal(t, "Alice", found[0].FirstName)
+	assert.Equal(t, "Bob", found[1].FirstName)
+
+	// writes set the current version
+	_, err = client.Set(ctx, &SchemaUser{ID: "new", FirstName: "Dave"})
+	require.NoError(t, err)
+	docsnap, err = client.FirestoreClient.Doc("SchemaUser/new").Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), docsnap.Data()["SchemaVersion"])
+}
+
+func TestSchemaUpgradeWriteBack(t *testing.T) {
+	clearAllDocuments(t, &SchemaUser{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.EnableSchemaUpgrade(&SchemaUser{}, schemaUserUpgraders, WithSchemaWriteBack()))
+	_, err = client.FirestoreClient.Doc("SchemaUser/v0").Set(ctx, map[string]any{"ID": "v0", "Name": "Alice Smith"})
+	require.NoError(t, err)
+
+	// not written back in transactions
+	require.NoError(t, client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		return tc.Get(ctx, &SchemaUser{ID: "v0"})
+	}))
+	docsnap, err := client.FirestoreClient.Doc("SchemaUser/v0").Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"ID": "v0", "Name": "Alice Smith"}, docsnap.Data())
+
+	require.NoError(t, client.Get(ctx, &SchemaUser{ID: "v0"}))
+	// written in the background
+	client.schemaConfig(reflect.TypeOf(&SchemaUser{})).writeBacks.wg.Wait()
+	docsnap, err = client.FirestoreClient.Doc("SchemaUser/v0").Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]any{"ID": "v0", "SchemaVersion": int64(2), "FirstName": "Alice", "LastName": "Smith"},
+		docsnap.Data(),
+	)
+}
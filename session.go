@@ -0,0 +1,96 @@
+package simplestore
+
+import (
+	"context"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Session is a per-context identity map of documents
+// Repeated `Get` and `GetAll` for the same documents are served from the session.
+// Writes through clients invalidate documents in the session.
+// Transactions always read firestore.
+type Session struct {
+	mu     sync.Mutex
+	docs   map[string]*firestore.DocumentSnapshot
+	hits   int64
+	misses int64
+}
+
+// SessionStats is statistics of a session
+type SessionStats struct {
+	// Hits is the number of documents served from the session (the number of saved reads).
+	Hits int64
+	// Misses is the number of documents read from firestore.
+	Misses int64
+}
+
+type sessionKey struct{}
+
+// WithSession returns a new context with a session
+// Typically used per HTTP request.
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &Session{
+		docs: map[string]*firestore.DocumentSnapshot{},
+	})
+}
+
+// SessionFrom returns the session in the context
+// Returns nil if the context doesn't have a session.
+func SessionFrom(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// Stats returns statistics of the session
+func (s *Session) Stats() SessionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SessionStats{
+		Hits:   s.hits,
+		Misses: s.misses,
+	}
+}
+
+// Clear removes all documents from the session
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs = map[string]*firestore.DocumentSnapshot{}
+}
+
+func (s *Session) invalidate(doc *firestore.DocumentRef) {
+	if doc == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.docs, doc.Path)
+}
+
+// getAll returns snapshots of documents in the session, and fetches others
+func (s *Session) getAll(ctx context.Context, op *Operation, docs []*firestore.DocumentRef, fetch snapshotFetcher) ([]*firestore.DocumentSnapshot, error) {
+	docsnapList, served, err := getSnapshotsThrough(
+		ctx,
+		docs,
+		func(doc *firestore.DocumentRef) (*firestore.DocumentSnapshot, bool) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			docsnap, ok := s.docs[doc.Path]
+			return docsnap, ok
+		},
+		fetch,
+		func(docsnap *firestore.DocumentSnapshot) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.docs[docsnap.Ref.Path] = docsnap
+		},
+	)
+	s.mu.Lock()
+	s.hits += int64(served)
+	s.misses += int64(len(docs) - served)
+	s.mu.Unlock()
+	op.CachedCount += served
+	return docsnapList, err
+}
@@ -0,0 +1,89 @@
+package simplestore
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSessionGetAll(t *testing.T) {
+	ctx := WithSession(context.Background())
+	client, err := New(ctx)
+	require.NoError(t, err)
+	sess := SessionFrom(ctx)
+	require.NotNil(t, sess)
+
+	var fetched [][]string
+	fetch := func(ctx context.Context, docs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error) {
+		var ids []string
+		var docsnapList []*firestore.DocumentSnapshot
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+			docsnapList = append(docsnapList, &firestore.DocumentSnapshot{Ref: doc})
+		}
+		fetched = append(fetched, ids)
+		return docsnapList, nil
+	}
+	doc1 := client.FirestoreClient.Collection("MyDocument").Doc("1")
+	doc2 := client.FirestoreClient.Collection("MyDocument").Doc("2")
+
+	op := &Operation{}
+	docsnapList, err := sess.getAll(ctx, op, []*firestore.DocumentRef{doc1}, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, doc1, docsnapList[0].Ref)
+	assert.Equal(t, 0, op.CachedCount)
+
+	op = &Operation{}
+	docsnapList, err = sess.getAll(ctx, op, []*firestore.DocumentRef{doc2, doc1}, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, doc2, docsnapList[0].Ref)
+	assert.Equal(t, doc1, docsnapList[1].Ref)
+	assert.Equal(t, 1, op.CachedCount)
+
+	sess.invalidate(doc1)
+	_, err = sess.getAll(ctx, &Operation{}, []*firestore.DocumentRef{doc1, doc2}, fetch)
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]string{{"1"}, {"2"}, {"1"}}, fetched)
+	assert.Equal(t, SessionStats{Hits: 2, Misses: 3}, sess.Stats())
+}
+
+func TestSession(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := WithSession(context.Background())
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	_, err = client.Set(ctx, &MyDocument{ID: "docid1", Name: "Alice"})
+	require.NoError(t, err)
+
+	doc := &MyDocument{ID: "docid1"}
+	require.NoError(t, client.Get(ctx, doc))
+	assert.Equal(t, "Alice", doc.Name)
+
+	// served from the session
+	_, err = client.GetAll(ctx, []*MyDocument{{ID: "docid1"}, {ID: "docid2"}})
+	require.NoError(t, err)
+	err = client.Get(ctx, &MyDocument{ID: "docid2"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, SessionStats{Hits: 2, Misses: 2}, SessionFrom(ctx).Stats())
+
+	// writes invalidate the session
+	_, err = client.Set(ctx, &MyDocument{ID: "docid1", Name: "Bob"})
+	require.NoError(t, err)
+	doc = &MyDocument{ID: "docid1"}
+	require.NoError(t, client.Get(ctx, doc))
+	assert.Equal(t, "Bob", doc.Name)
+
+	// transactions bypass the session
+	err = client.RunTransaction(ctx, func(ctx context.Context, client *Client) error {
+		return client.Get(ctx, &MyDocument{ID: "docid1"})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, SessionStats{Hits: 2, Misses: 3}, SessionFrom(ctx).Stats())
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -171,3 +172,21 @@ func TestTableMapWithQuery(t *testing.T) {
 	assert.Len(t, docs, 1)
 	assert.Equal(t, "Test Document", docs[0].Name)
 }
+
+func TestCheckWritableCollection(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+	client.AddReadonlyTableMaps(map[string]string{
+		"TestReadOnlyDocument": "readonly_collection",
+	})
+
+	assert.NoError(t, client.CheckWritableCollection("TestTableMapDocument"))
+	assertProgrammingError(t, client.CheckWritableCollection("readonly_collection"))
+
+	view, err := client.AtReadTime(time.Now())
+	require.NoError(t, err)
+	defer view.Close()
+	assertProgrammingError(t, view.CheckWritableCollection("TestTableMapDocument"))
+}
@@ -6,12 +6,45 @@ import (
 	"cloud.google.com/go/firestore"
 )
 
+// RunTransaction runs f in a transaction
+// f is called with a new client bound to the transaction.
+// Middlewares registered to c are inherited to the new client.
+// Writes in transactions with `firestore.ReadOnly` fail with ProgrammingError.
+// For clients from `AtReadTime`, f is called with c without a transaction:
+// firestore can't read at a time in transactions, and reads at a single time are already consistent,
+// so it works as a read-only transaction.
+// Firestore rejects reads after writes in a transaction.
+// Writes of documents with unique fields, audit or history read indexes and current documents,
+// so they are staged and sent with their index documents, audit entries and versions when f returns,
+// after other writes. Errors of staged writes are returned from RunTransaction.
+// Other writes are sent immediately as with `FirestoreTransaction`: reads after them fail.
 func (c *Client) RunTransaction(ctx context.Context, f func(ctx context.Context, client *Client) error, opts ...firestore.TransactionOption) error {
+	if !c.readTime.IsZero() {
+		// reads at a time are consistent without transactions
+		_, err := c.invoke(ctx, &Operation{Kind: OperationTransaction}, func(ctx context.Context, op *Operation) (any, error) {
+			op.Attempt = 1
+			return nil, f(ctx, c)
+		})
+		return err
+	}
 	newClient := *c
-	err := c.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, t *firestore.Transaction) error {
-		newClient.FirestoreTransaction = t
-		return f(ctx, &newClient)
-	}, opts...)
+	for _, opt := range opts {
+		if opt == firestore.ReadOnly {
+			newClient.readOnlyTransaction = true
+		}
+	}
+	_, err := c.invoke(ctx, &Operation{Kind: OperationTransaction}, func(ctx context.Context, op *Operation) (any, error) {
+		return nil, c.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, t *firestore.Transaction) error {
+			op.Attempt++
+			newClient.FirestoreTransaction = t
+			newClient.transactionAttempt = op.Attempt
+			newClient.txWrites = &transactionWrites{}
+			if err := f(ctx, &newClient); err != nil {
+				return err
+			}
+			return newClient.txWrites.flush()
+		}, opts...)
+	})
 	if err != nil {
 		for _, callback := range newClient.transactionFailureCallbacks {
 			callback()
@@ -19,3 +52,28 @@ func (c *Client) RunTransaction(ctx context.Context, f func(ctx context.Context,
 	}
 	return err
 }
+
+// transactionWrites is writes of documents with unique fields, audit or history staged in a transaction attempt
+// Reads for unique indexes, audit entries and versions are done when writes are staged,
+// so staged writes are sent after all reads of the transaction.
+type transactionWrites struct {
+	writes []func() error
+	// written is paths of documents with unique fields, audit or history written in the transaction.
+	written map[string]bool
+	// uniqueOwners is owners of unique index documents reserved in the transaction.
+	// nil for released index documents.
+	uniqueOwners map[string]*firestore.DocumentRef
+}
+
+func (w *transactionWrites) stage(f func() error) {
+	w.writes = append(w.writes, f)
+}
+
+func (w *transactionWrites) flush() error {
+	for _, f := range w.writes {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
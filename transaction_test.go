@@ -42,3 +42,21 @@ func TestRunTransaction(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "Bob", updatedDoc.Name)
 }
+
+func TestRunTransactionWritesNotStaged(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	assert.NoError(t, err)
+
+	// writes of documents without unique fields, audit nor history are sent immediately
+	err = client.RunTransaction(ctx, func(ctx context.Context, txClient *Client) error {
+		if _, err := txClient.Set(ctx, &MyDocument{ID: "docid", Name: "Alice"}); err != nil {
+			return err
+		}
+		return txClient.Get(ctx, &MyDocument{ID: "docid"})
+	})
+	assert.Error(t, err)
+	_, ok := err.(*ProgrammingError)
+	assert.False(t, ok)
+}
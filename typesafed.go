@@ -2,6 +2,7 @@ package simplestore
 
 import (
 	"context"
+	"reflect"
 
 	"cloud.google.com/go/firestore"
 )
@@ -45,7 +46,99 @@ func (c *TypeSafedClient[T, P]) GetAll(ctx context.Context, os []*T) ([]*T, erro
 	if res == nil {
 		return nil, err
 	}
-	return res.([]*T), err
+	found, _ := res.([]*T)
+	return found, err
+}
+
+// GetByID retrieves a document with the ID from firestore
+// Returns the same error as `Get` if the document doesn't exist.
+func (c *TypeSafedClient[T, P]) GetByID(ctx context.Context, id string) (*T, error) {
+	return c.getByID(ctx, nil, id)
+}
+
+// GetByIDWithParent retrieves a document with the ID under the document specified by `parent` from firestore
+// Parent of the returned object is set to parent.
+func (c *TypeSafedClient[T, P]) GetByIDWithParent(ctx context.Context, parent *P, id string) (*T, error) {
+	if parent == nil {
+		return nil, NewProgrammingError("parent is nil")
+	}
+	return c.getByID(ctx, parent, id)
+}
+
+// GetByIDs retrieves documents with IDs from firestore
+// Returns slice of found objects in the order of ids.
+// Missing documents are skipped as `GetAll`.
+func (c *TypeSafedClient[T, P]) GetByIDs(ctx context.Context, ids []string) ([]*T, error) {
+	return c.getByIDs(ctx, nil, ids)
+}
+
+// GetByIDsWithParent retrieves documents with IDs under the document specified by `parent` from firestore
+// Returns slice of found objects in the order of ids.
+// Parent of returned objects are set to parent.
+func (c *TypeSafedClient[T, P]) GetByIDsWithParent(ctx context.Context, parent *P, ids []string) ([]*T, error) {
+	if parent == nil {
+		return nil, NewProgrammingError("parent is nil")
+	}
+	return c.getByIDs(ctx, parent, ids)
+}
+
+func (c *TypeSafedClient[T, P]) newDocument(parent any, id string) (*T, error) {
+	o, err := c.untyped.newDocument(reflect.TypeOf((*T)(nil)), parent, id)
+	if err != nil {
+		return nil, err
+	}
+	return o.(*T), nil
+}
+
+func (c *TypeSafedClient[T, P]) getByID(ctx context.Context, parent any, id string) (*T, error) {
+	o, err := c.newDocument(parent, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Get(ctx, o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (c *TypeSafedClient[T, P]) getByIDs(ctx context.Context, parent any, ids []string) ([]*T, error) {
+	os := make([]*T, 0, len(ids))
+	for _, id := range ids {
+		o, err := c.newDocument(parent, id)
+		if err != nil {
+			return nil, err
+		}
+		os = append(os, o)
+	}
+	return c.GetAll(ctx, os)
+}
+
+// TypeSafedGetAllResult is the result of `GetAllDetailed` of TypeSafedClient
+type TypeSafedGetAllResult[T any] struct {
+	// Found is the slice of found objects in the input order.
+	Found []*T
+	// Missing is the list of references of missing documents in the input order.
+	Missing []*firestore.DocumentRef
+	// MissingIndexes is the list of indexes in the input for Missing.
+	MissingIndexes []int
+	// Skipped is the list of indexes of nil entries in the input.
+	Skipped []int
+}
+
+// GetAllDetailed retrieves multiple documents from firestore and reports missing documents
+// Fill os with found documents.
+func (c *TypeSafedClient[T, P]) GetAllDetailed(ctx context.Context, os []*T, opts ...GetAllOption) (*TypeSafedGetAllResult[T], error) {
+	res, err := c.untyped.GetAllDetailed(ctx, os, opts...)
+	if res == nil {
+		return nil, err
+	}
+	found, _ := res.Found.([]*T)
+	return &TypeSafedGetAllResult[T]{
+		Found:          found,
+		Missing:        res.Missing,
+		MissingIndexes: res.MissingIndexes,
+		Skipped:        res.Skipped,
+	}, err
 }
 
 // Create creates a new document in firestore
@@ -5,6 +5,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestTypeSafedClient_Get(t *testing.T) {
@@ -47,3 +50,98 @@ func TestTypeSafedClient_Delete(t *testing.T) {
 	_, err = typesafed.Delete(ctx, doc)
 	assert.NoError(t, err)
 }
+
+func TestTypeSafedClient_GetByID(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	typesafed := TypeSafed[MyDocument](client)
+
+	_, err = typesafed.Set(ctx, &MyDocument{ID: "docid", Name: "Alice"})
+	require.NoError(t, err)
+
+	doc, err := typesafed.GetByID(ctx, "docid")
+	require.NoError(t, err)
+	assert.Equal(t, &MyDocument{ID: "docid", Name: "Alice"}, doc)
+
+	_, err = typesafed.GetByID(ctx, "nonexistent")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestTypeSafedClient_GetByIDs(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	typesafed := TypeSafed[MyDocument](client)
+
+	_, err = typesafed.Set(ctx, &MyDocument{ID: "docid1", Name: "Alice"})
+	require.NoError(t, err)
+	_, err = typesafed.Set(ctx, &MyDocument{ID: "docid2", Name: "Bob"})
+	require.NoError(t, err)
+
+	docs, err := typesafed.GetByIDs(ctx, []string{"docid2", "nonexistent", "docid1"})
+	require.NoError(t, err)
+	assert.Equal(t, []*MyDocument{
+		{ID: "docid2", Name: "Bob"},
+		{ID: "docid1", Name: "Alice"},
+	}, docs)
+}
+
+func TestTypeSafedClient_GetByIDWithParent(t *testing.T) {
+	clearAllDocuments(t, &ParentDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	typesafed := TypeSafedWithParent[ChildDocument, ParentDocument](client)
+
+	parent := &ParentDocument{ID: "parent1"}
+	_, err = typesafed.Set(ctx, &ChildDocument{Parent: parent, ID: "child1", Name: "Child"})
+	require.NoError(t, err)
+
+	child, err := typesafed.GetByIDWithParent(ctx, parent, "child1")
+	require.NoError(t, err)
+	assert.Same(t, parent, child.Parent)
+	assert.Equal(t, "Child", child.Name)
+
+	children, err := typesafed.GetByIDsWithParent(ctx, parent, []string{"child1"})
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	assert.Same(t, parent, children[0].Parent)
+}
+
+func TestTypeSafedClient_GetByIDForIDer(t *testing.T) {
+	clearAllDocuments(t, &CustomIDDocument{})
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	typesafed := TypeSafed[CustomIDDocument](client)
+
+	_, err = typesafed.Set(ctx, &CustomIDDocument{MyID: "test123", Name: "Custom"})
+	require.NoError(t, err)
+
+	doc, err := typesafed.GetByID(ctx, "hash_test123")
+	require.NoError(t, err)
+	assert.Equal(t, &CustomIDDocument{MyID: "test123", Name: "Custom"}, doc)
+}
+
+func TestTypeSafedClient_GetAllDetailedShortCircuit(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+	client.Use(func(next Handler) Handler {
+		return func(ctx context.Context, op *Operation) (any, error) {
+			return nil, nil
+		}
+	})
+	typesafed := TypeSafed[MyDocument](client)
+
+	res, err := typesafed.GetAllDetailed(ctx, []*MyDocument{{ID: "docid"}})
+	require.NoError(t, err)
+	assert.Equal(t, []*MyDocument{}, res.Found)
+
+	untypedRes, err := client.GetAllDetailed(ctx, []*MyDocument{{ID: "docid"}})
+	require.NoError(t, err)
+	assert.Equal(t, []*MyDocument{}, untypedRes.Found)
+}
@@ -0,0 +1,219 @@
+package simplestore
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// UniqueTagOption is the tag option for unique fields
+	// e.g. `simplestore:"unique"`
+	UniqueTagOption = "unique"
+	// UniqueIndexCollection is the collection to store index documents for unique fields
+	// Index documents are stored as `_unique/<Collection>.<field>/values/<value>`.
+	UniqueIndexCollection = "_unique"
+)
+
+// ErrUniqueViolation indicates a value of a unique field is already used by another document
+// Test with `errors.Is(err, ErrUniqueViolation)`.
+var ErrUniqueViolation = errors.New("simplestore: unique constraint violation")
+
+// UniqueViolationError indicates a value of a unique field is already used by another document
+type UniqueViolationError struct {
+	// Field is the name of the field in firestore.
+	Field string
+	// Value is the value of the field.
+	Value any
+	// Owner is the document using the value.
+	Owner *firestore.DocumentRef
+}
+
+// Error is an implementation for error
+func (e *UniqueViolationError) Error() string {
+	return fmt.Sprintf("%v: %s=%v is used by %s", ErrUniqueViolation, e.Field, e.Value, relativePath(e.Owner.Path))
+}
+
+// Is reports UniqueViolationError is ErrUniqueViolation
+func (e *UniqueViolationError) Is(target error) bool {
+	return target == ErrUniqueViolation
+}
+
+// GRPCStatus returns the gRPC status for `status.Code()`
+func (e *UniqueViolationError) GRPCStatus() *status.Status {
+	return status.New(codes.AlreadyExists, e.Error())
+}
+
+type uniqueField struct {
+	index []int
+	name  string
+}
+
+// uniqueIndex is the content of index documents for unique fields
+type uniqueIndex struct {
+	Ref *firestore.DocumentRef `firestore:"ref"`
+}
+
+func newUniqueFields(t reflect.Type) ([]uniqueField, error) {
+	var fields []uniqueField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !hasTagOption(f, UniqueTagOption) {
+			continue
+		}
+		name := firestoreFieldName(f)
+		if name == "" {
+			return nil, NewProgrammingErrorf("unique field must be stored: %s.%s.%s", t.PkgPath(), t.Name(), f.Name)
+		}
+		fields = append(fields, uniqueField{
+			index: f.Index,
+			name:  name,
+		})
+	}
+	return fields, nil
+}
+
+// uniqueIndexRef returns the index document for the value
+// Returns nil for zero values, which are not unique.
+func (c *Client) uniqueIndexRef(a *accessor, f uniqueField, value any) *firestore.DocumentRef {
+	if value == nil || reflect.ValueOf(value).IsZero() {
+		return nil
+	}
+	return c.FirestoreClient.Collection(UniqueIndexCollection).
+		Doc(a.collectionName + "." + f.name).
+		Collection("values").
+		Doc(url.PathEscape(fmt.Sprint(value)))
+}
+
+// updateUniqueIndexes reserves values of unique fields and releases old values
+// Must be called in a transaction before other writes.
+// Reservations and releases of other documents in the transaction are taken into account.
+func (c *Client) updateUniqueIndexes(kind OperationKind, a *accessor, doc *firestore.DocumentRef, pv reflect.Value) error {
+	tx := c.FirestoreTransaction
+	oldValues := make([]any, len(a.uniqueFields))
+	if kind != OperationCreate {
+		docsnap, err := tx.Get(doc)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if docsnap.Exists() {
+			for i, f := range a.uniqueFields {
+				oldValues[i], _ = docsnap.DataAt(f.name)
+			}
+		}
+	}
+	newValues := make([]any, len(a.uniqueFields))
+	if kind != OperationDelete {
+		for i, f := range a.uniqueFields {
+			newValues[i] = pv.Elem().FieldByIndex(f.index).Interface()
+		}
+	}
+
+	type change struct {
+		field   uniqueField
+		value   any
+		reserve *firestore.DocumentRef
+		release *firestore.DocumentRef
+	}
+	var changes []*change
+	var refs []*firestore.DocumentRef
+	for i, f := range a.uniqueFields {
+		oldRef := c.uniqueIndexRef(a, f, oldValues[i])
+		newRef := c.uniqueIndexRef(a, f, newValues[i])
+		if oldRef != nil && newRef != nil && oldRef.Path == newRef.Path {
+			continue
+		}
+		ch := &change{
+			field:   f,
+			value:   newValues[i],
+			reserve: newRef,
+			release: oldRef,
+		}
+		changes = append(changes, ch)
+		if newRef != nil {
+			refs = append(refs, newRef)
+		}
+		if oldRef != nil {
+			refs = append(refs, oldRef)
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	docsnaps, err := tx.GetAll(refs)
+	if err != nil {
+		return err
+	}
+	owners := make(map[string]*firestore.DocumentRef, len(docsnaps))
+	for _, docsnap := range docsnaps {
+		if !docsnap.Exists() {
+			continue
+		}
+		var index uniqueIndex
+		if err := docsnap.DataTo(&index); err != nil {
+			return err
+		}
+		owners[docsnap.Ref.Path] = index.Ref
+	}
+	if c.txWrites != nil {
+		// reservations and releases in the transaction are not read yet
+		for path, owner := range c.txWrites.uniqueOwners {
+			owners[path] = owner
+		}
+	}
+	for _, ch := range changes {
+		if ch.reserve == nil {
+			continue
+		}
+		if owner := owners[ch.reserve.Path]; owner != nil && owner.Path != doc.Path {
+			return &UniqueViolationError{
+				Field: ch.field.name,
+				Value: ch.value,
+				Owner: owner,
+			}
+		}
+	}
+
+	for _, ch := range changes {
+		// release only reservations of this document
+		if ch.release != nil {
+			if owner := owners[ch.release.Path]; owner != nil && owner.Path == doc.Path {
+				release := ch.release
+				if err := c.writeUniqueIndex(release, nil, func() error {
+					return tx.Delete(release)
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		if ch.reserve != nil {
+			reserve := ch.reserve
+			if err := c.writeUniqueIndex(reserve, doc, func() error {
+				return tx.Set(reserve, &uniqueIndex{Ref: doc})
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeUniqueIndex stages the write of the index document in the transaction
+// owner is nil for releases.
+func (c *Client) writeUniqueIndex(index *firestore.DocumentRef, owner *firestore.DocumentRef, f func() error) error {
+	if c.txWrites == nil {
+		return f()
+	}
+	if c.txWrites.uniqueOwners == nil {
+		c.txWrites.uniqueOwners = map[string]*firestore.DocumentRef{}
+	}
+	c.txWrites.uniqueOwners[index.Path] = owner
+	c.txWrites.stage(f)
+	return nil
+}
@@ -0,0 +1,211 @@
+package simplestore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type UniqueUser struct {
+	ID    string
+	Email string `firestore:"email" simplestore:"unique"`
+	Name  string
+}
+
+func clearUniqueIndexes(t *testing.T) {
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, getProjectID())
+	require.NoError(t, err)
+	// index documents are in subcollections of missing documents
+	require.NoError(t, DeleteCollection(ctx, client, UniqueIndexCollection+"/UniqueUser.email/values", 100))
+}
+
+func TestUniqueFields(t *testing.T) {
+	a, err := newAccessor(reflect.TypeOf(&UniqueUser{}), nil)
+	require.NoError(t, err)
+	require.Len(t, a.uniqueFields, 1)
+	assert.Equal(t, "email", a.uniqueFields[0].name)
+
+	_, err = newAccessor(reflect.TypeOf(&struct {
+		ID    string
+		Email string `firestore:"-" simplestore:"unique"`
+	}{}), nil)
+	assertProgrammingError(t, err)
+}
+
+func TestUniqueIndexRef(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewWithProjectID(ctx, "testproject")
+	require.NoError(t, err)
+	defer client.Close()
+	a, err := newAccessor(reflect.TypeOf(&UniqueUser{}), nil)
+	require.NoError(t, err)
+
+	ref := client.uniqueIndexRef(a, a.uniqueFields[0], "a/b@example.com")
+	assert.Equal(t, "_unique/UniqueUser.email/values/a%2Fb@example.com", relativePath(ref.Path))
+	assert.Nil(t, client.uniqueIndexRef(a, a.uniqueFields[0], ""))
+	assert.Nil(t, client.uniqueIndexRef(a, a.uniqueFields[0], nil))
+}
+
+func TestUniqueViolationError(t *testing.T) {
+	err := error(&UniqueViolationError{
+		Field: "email",
+		Value: "alice@example.com",
+		Owner: &firestore.DocumentRef{Path: "projects/p/databases/(default)/documents/UniqueUser/alice"},
+	})
+	assert.True(t, errors.Is(err, ErrUniqueViolation))
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Contains(t, err.Error(), "email=alice@example.com is used by UniqueUser/alice")
+}
+
+func TestUniqueConstraint(t *testing.T) {
+	clearAllDocuments(t, &UniqueUser{})
+	clearUniqueIndexes(t)
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	alice := &UniqueUser{Email: "alice@example.com", Name: "Alice"}
+	_, err = client.Create(ctx, alice)
+	require.NoError(t, err)
+	assert.NotEmpty(t, alice.ID)
+
+	// violation
+	bob := &UniqueUser{Email: "alice@example.com", Name: "Bob"}
+	_, err = client.Create(ctx, bob)
+	require.ErrorIs(t, err, ErrUniqueViolation)
+	var violation *UniqueViolationError
+	require.ErrorAs(t, err, &violation)
+	assert.Equal(t, "email", violation.Field)
+	assert.Equal(t, alice.ID, violation.Owner.ID)
+	assert.Empty(t, bob.ID)
+
+	// setting the same value is allowed
+	alice.Name = "Alice2"
+	_, err = client.Set(ctx, alice)
+	require.NoError(t, err)
+
+	// changing the value releases the old value
+	alice.Email = "alice2@example.com"
+	_, err = client.Set(ctx, alice)
+	require.NoError(t, err)
+	_, err = client.Create(ctx, bob)
+	require.NoError(t, err)
+
+	bob2 := &UniqueUser{ID: bob.ID, Email: "alice2@example.com"}
+	_, err = client.Set(ctx, bob2)
+	require.ErrorIs(t, err, ErrUniqueViolation)
+
+	// deleting releases the value
+	_, err = client.Delete(ctx, alice)
+	require.NoError(t, err)
+	_, err = client.Set(ctx, bob2)
+	require.NoError(t, err)
+
+	// empty values are not unique
+	_, err = client.Create(ctx, &UniqueUser{})
+	require.NoError(t, err)
+	_, err = client.Create(ctx, &UniqueUser{})
+	require.NoError(t, err)
+
+	_, err = client.Set(ctx, bob2, firestore.MergeAll)
+	assertProgrammingError(t, err)
+}
+
+func TestUniqueConstraintInTransaction(t *testing.T) {
+	clearAllDocuments(t, &UniqueUser{})
+	clearUniqueIndexes(t)
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	_, err = client.Create(ctx, &UniqueUser{ID: "alice", Email: "alice@example.com"})
+	require.NoError(t, err)
+
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		_, err := tc.Create(ctx, &UniqueUser{ID: "bob", Email: "alice@example.com"})
+		return err
+	})
+	require.ErrorIs(t, err, ErrUniqueViolation)
+	err = client.Get(ctx, &UniqueUser{ID: "bob"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestUniqueConstraintMultipleWritesInTransaction(t *testing.T) {
+	clearAllDocuments(t, &UniqueUser{})
+	clearUniqueIndexes(t)
+	ctx := context.Background()
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	_, err = client.Create(ctx, &UniqueUser{ID: "alice", Email: "alice@example.com"})
+	require.NoError(t, err)
+
+	// reads for the second write are before writes
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		if _, err := tc.Create(ctx, &UniqueUser{ID: "bob", Email: "bob@example.com"}); err != nil {
+			return err
+		}
+		_, err := tc.Create(ctx, &UniqueUser{ID: "carol", Email: "carol@example.com"})
+		return err
+	})
+	require.NoError(t, err)
+	carol := &UniqueUser{ID: "carol"}
+	require.NoError(t, client.Get(ctx, carol))
+	assert.Equal(t, "carol@example.com", carol.Email)
+
+	// duplicates in the transaction
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		if _, err := tc.Create(ctx, &UniqueUser{ID: "dave", Email: "dave@example.com"}); err != nil {
+			return err
+		}
+		_, err := tc.Create(ctx, &UniqueUser{ID: "eve", Email: "dave@example.com"})
+		return err
+	})
+	require.ErrorIs(t, err, ErrUniqueViolation)
+	err = client.Get(ctx, &UniqueUser{ID: "dave"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// values released in the transaction are available
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		if _, err := tc.Set(ctx, &UniqueUser{ID: "alice", Email: "alice2@example.com"}); err != nil {
+			return err
+		}
+		_, err := tc.Create(ctx, &UniqueUser{ID: "dave", Email: "alice@example.com"})
+		return err
+	})
+	require.NoError(t, err)
+	_, err = client.Create(ctx, &UniqueUser{Email: "alice@example.com"})
+	require.ErrorIs(t, err, ErrUniqueViolation)
+
+	// changes after Set are not written
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		user := &UniqueUser{ID: "frank", Email: "frank@example.com"}
+		if _, err := tc.Set(ctx, user); err != nil {
+			return err
+		}
+		user.Email = "changed@example.com"
+		return nil
+	})
+	require.NoError(t, err)
+	frank := &UniqueUser{ID: "frank"}
+	require.NoError(t, client.Get(ctx, frank))
+	assert.Equal(t, "frank@example.com", frank.Email)
+
+	// writing the same document twice
+	err = client.RunTransaction(ctx, func(ctx context.Context, tc *Client) error {
+		if _, err := tc.Set(ctx, &UniqueUser{ID: "alice", Email: "alice3@example.com"}); err != nil {
+			return err
+		}
+		_, err := tc.Set(ctx, &UniqueUser{ID: "alice", Email: "alice4@example.com"})
+		return err
+	})
+	assertProgrammingError(t, err)
+}
@@ -0,0 +1,153 @@
+package simplestore
+
+import (
+	"context"
+	"path"
+	"sync"
+)
+
+// countAggregationUnit is the number of index entries billed as a read for count aggregations
+const countAggregationUnit = 1000
+
+// Usage counts document reads and writes consumed in a context
+// Firestore bills per document read and write, and Usage helps to attribute the cost.
+// This is an estimation:
+// * Get and GetAll count a read for each document whether it exists or not, except ones served from sessions or caches.
+// * Query counts a read for each returned document, and a read for an empty result.
+// * Count counts a read for each 1000 counted documents (at least one).
+// * Writes in transactions are counted when they are staged even if the transaction fails.
+type Usage struct {
+	mu          sync.Mutex
+	parent      *Usage
+	collections map[string]*CollectionUsage
+}
+
+// CollectionUsage is the usage for a collection
+type CollectionUsage struct {
+	Reads   int64
+	Writes  int64
+	Deletes int64
+}
+
+type usageKey struct{}
+
+// WithUsageMeter returns a new context to count reads and writes
+// Get the usage with `UsageFrom()`.
+// When the context already has a usage meter, operations are counted to both.
+func WithUsageMeter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, usageKey{}, &Usage{
+		parent:      UsageFrom(ctx),
+		collections: map[string]*CollectionUsage{},
+	})
+}
+
+// UsageFrom returns the usage meter in the context
+// Returns nil if the context doesn't have a usage meter.
+func UsageFrom(ctx context.Context) *Usage {
+	u, _ := ctx.Value(usageKey{}).(*Usage)
+	return u
+}
+
+// Collections returns usages for each collection
+// Returns a copy.
+func (u *Usage) Collections() map[string]CollectionUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	collections := make(map[string]CollectionUsage, len(u.collections))
+	for name, cu := range u.collections {
+		collections[name] = *cu
+	}
+	return collections
+}
+
+// Collection returns the usage for the collection
+func (u *Usage) Collection(name string) CollectionUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if cu, ok := u.collections[name]; ok {
+		return *cu
+	}
+	return CollectionUsage{}
+}
+
+// Total returns the sum of usages for all collections
+func (u *Usage) Total() CollectionUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var total CollectionUsage
+	for _, cu := range u.collections {
+		total.Reads += cu.Reads
+		total.Writes += cu.Writes
+		total.Deletes += cu.Deletes
+	}
+	return total
+}
+
+func (u *Usage) add(collection string, delta CollectionUsage) {
+	for ; u != nil; u = u.parent {
+		u.mu.Lock()
+		cu, ok := u.collections[collection]
+		if !ok {
+			cu = &CollectionUsage{}
+			u.collections[collection] = cu
+		}
+		cu.Reads += delta.Reads
+		cu.Writes += delta.Writes
+		cu.Deletes += delta.Deletes
+		u.mu.Unlock()
+	}
+}
+
+// recordUsage counts reads and writes consumed by the operation
+func recordUsage(ctx context.Context, op *Operation, err error) {
+	u := UsageFrom(ctx)
+	if u == nil {
+		return
+	}
+	switch op.Kind {
+	case OperationGet:
+		if reads := op.ResultCount + op.MissingCount - op.CachedCount; reads > 0 {
+			u.add(op.Collection, CollectionUsage{Reads: int64(reads)})
+		}
+	case OperationGetAll:
+		if err != nil {
+			return
+		}
+		if op.CachedCount > 0 {
+			// paths served from caches are not tracked
+			if reads := op.ResultCount + op.MissingCount - op.CachedCount; reads > 0 {
+				u.add(op.Collection, CollectionUsage{Reads: int64(reads)})
+			}
+			return
+		}
+		for _, p := range op.Paths {
+			u.add(path.Base(path.Dir(p)), CollectionUsage{Reads: 1})
+		}
+	case OperationQuery:
+		reads := int64(op.ResultCount)
+		if reads == 0 && err == nil {
+			// an empty result is billed as a read
+			reads = 1
+		}
+		if reads > 0 {
+			u.add(op.Collection, CollectionUsage{Reads: reads})
+		}
+	case OperationCount:
+		if err != nil {
+			return
+		}
+		reads := (int64(op.ResultCount) + countAggregationUnit - 1) / countAggregationUnit
+		if reads == 0 {
+			reads = 1
+		}
+		u.add(op.Collection, CollectionUsage{Reads: reads})
+	case OperationCreate, OperationSet:
+		if err == nil {
+			u.add(op.Collection, CollectionUsage{Writes: 1})
+		}
+	case OperationDelete:
+		if err == nil {
+			u.add(op.Collection, CollectionUsage{Deletes: 1})
+		}
+	}
+}
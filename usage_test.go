@@ -0,0 +1,55 @@
+package simplestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordUsage(t *testing.T) {
+	ctx := WithUsageMeter(context.Background())
+	innerCtx := WithUsageMeter(ctx)
+
+	recordUsage(innerCtx, &Operation{Kind: OperationGet, Collection: "A", MissingCount: 1}, errors.New("not found"))
+	recordUsage(innerCtx, &Operation{Kind: OperationGetAll, Collection: "A", Paths: []string{"A/1", "B/2/A/3", "B/4"}}, nil)
+	recordUsage(ctx, &Operation{Kind: OperationQuery, Collection: "A"}, nil)
+	recordUsage(ctx, &Operation{Kind: OperationQuery, Collection: "A", ResultCount: 3}, nil)
+	recordUsage(ctx, &Operation{Kind: OperationCount, Collection: "B", ResultCount: 1001}, nil)
+	recordUsage(ctx, &Operation{Kind: OperationSet, Collection: "B"}, nil)
+	recordUsage(ctx, &Operation{Kind: OperationCreate, Collection: "B"}, errors.New("already exists"))
+	recordUsage(ctx, &Operation{Kind: OperationDelete, Collection: "B"}, nil)
+
+	assert.Equal(t, map[string]CollectionUsage{
+		"A": {Reads: 3},
+		"B": {Reads: 1},
+	}, UsageFrom(innerCtx).Collections())
+	assert.Equal(t, CollectionUsage{Reads: 1 + 2 + 1 + 3}, UsageFrom(ctx).Collection("A"))
+	assert.Equal(t, CollectionUsage{Reads: 1 + 2, Writes: 1, Deletes: 1}, UsageFrom(ctx).Collection("B"))
+	assert.Equal(t, CollectionUsage{Reads: 10, Writes: 1, Deletes: 1}, UsageFrom(ctx).Total())
+	assert.Nil(t, UsageFrom(context.Background()))
+}
+
+func TestUsageMeter(t *testing.T) {
+	clearAllDocuments(t, &MyDocument{})
+	ctx := WithUsageMeter(context.Background())
+	client, err := New(ctx)
+	require.NoError(t, err)
+
+	_, err = client.Set(ctx, &MyDocument{ID: "docid1", Name: "Alice"})
+	require.NoError(t, err)
+	_, err = client.GetAll(ctx, []*MyDocument{{ID: "docid1"}, {ID: "docid2"}})
+	require.NoError(t, err)
+	err = client.RunTransaction(ctx, func(ctx context.Context, client *Client) error {
+		return client.Get(ctx, &MyDocument{ID: "docid1"})
+	})
+	require.NoError(t, err)
+	var docs []*MyDocument
+	require.NoError(t, client.Query(&docs).GetAll(ctx))
+	_, err = client.Delete(ctx, &MyDocument{ID: "docid1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, CollectionUsage{Reads: 4, Writes: 1, Deletes: 1}, UsageFrom(ctx).Collection("MyDocument"))
+}